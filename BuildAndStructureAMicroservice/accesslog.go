@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"BuildAndStructureAMicroservice/catfact"
+)
+
+// Access log formats.
+const (
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+	AccessLogJSON     = "json"
+)
+
+// AccessLogConfig holds the settings of the HTTP access log.
+type AccessLogConfig struct {
+	// Path is the file the access log is written to, or "-" for stdout. Empty disables it.
+	Path   string `json:"path"`
+	Format string `json:"format"`
+	// MaxBytes rotates the file before it grows beyond this size. Zero means no limit.
+	MaxBytes int64 `json:"max_bytes"`
+	// MaxAge rotates the file once it has been written to for this long. Zero means no limit.
+	MaxAge time.Duration `json:"max_age"`
+	// MaxBackups is how many rotated files are kept. Zero keeps them all.
+	MaxBackups int `json:"max_backups"`
+	// Compress gzips rotated files.
+	Compress bool `json:"compress"`
+	// BufferSize is how many entries may wait to be written. Entries beyond it are dropped
+	// rather than slowing requests down.
+	BufferSize int `json:"buffer_size"`
+}
+
+// DefaultAccessLogConfig returns the default access log settings, with the access log off.
+func DefaultAccessLogConfig() AccessLogConfig {
+	return AccessLogConfig{
+		Format:     AccessLogCombined,
+		MaxBytes:   100 << 20,
+		MaxAge:     24 * time.Hour,
+		MaxBackups: 7,
+		Compress:   true,
+		BufferSize: 4096,
+	}
+}
+
+// accessEntry is a request as recorded by the access log.
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	Remote    string    `json:"remote_addr"`
+	User      string    `json:"user,omitempty"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Latency   float64   `json:"latency_ms"`
+	RequestID string    `json:"request_id"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// AccessLog writes an entry for every HTTP request. Entries are handed to a background
+// writer so the request path never waits on disk I/O.
+type AccessLog struct {
+	format  string
+	out     io.WriteCloser
+	entries chan accessEntry
+	dropped atomic.Uint64
+	done    chan struct{}
+
+	// mu guards closed, so no entry is sent once entries is closed.
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewAccessLog creates a new instance of AccessLog writing to the file of cfg, and starts its writer.
+func NewAccessLog(cfg AccessLogConfig) (*AccessLog, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	var out io.WriteCloser = nopCloser{os.Stdout}
+	if cfg.Path != "-" {
+		f, err := OpenRotatingFile(cfg.Path, cfg.MaxBytes, cfg.MaxAge, cfg.MaxBackups, cfg.Compress)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	return newAccessLog(out, cfg)
+}
+
+// validate checks the format and the buffer size. Without a buffer, nearly every entry
+// would be dropped.
+func (c AccessLogConfig) validate() error {
+	switch c.Format {
+	case AccessLogCommon, AccessLogCombined, AccessLogJSON:
+	default:
+		return fmt.Errorf("unknown access log format %q", c.Format)
+	}
+	if c.BufferSize <= 0 {
+		return fmt.Errorf("buffer_size must be positive, got %d", c.BufferSize)
+	}
+	return nil
+}
+
+// newAccessLog creates a new instance of AccessLog writing to out.
+func newAccessLog(out io.WriteCloser, cfg AccessLogConfig) (*AccessLog, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	l := &AccessLog{
+		format:  cfg.Format,
+		out:     out,
+		entries: make(chan accessEntry, cfg.BufferSize),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+// Dropped returns how many entries were dropped because the writer couldn't keep up.
+func (l *AccessLog) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// Close writes the pending entries and closes the file. Requests served afterwards aren't logged.
+func (l *AccessLog) Close() error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.entries)
+	}
+	l.mu.Unlock()
+
+	<-l.done
+	return l.out.Close()
+}
+
+// run writes entries until the log is closed.
+func (l *AccessLog) run() {
+	defer close(l.done)
+
+	var (
+		line     []byte
+		reported uint64
+	)
+	for entry := range l.entries {
+		line = l.appendEntry(line[:0], entry)
+		if _, err := l.out.Write(line); err != nil {
+			logf(LevelWarn, "access log err=%v", err)
+		}
+		if dropped := l.Dropped(); dropped != reported {
+			logf(LevelWarn, "access log dropped=%d", dropped)
+			reported = dropped
+		}
+	}
+}
+
+// Middleware records every request served by next.
+func (l *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			entry := accessEntry{
+				Time:      start,
+				Remote:    r.RemoteAddr,
+				Method:    r.Method,
+				URI:       r.RequestURI,
+				Proto:     r.Proto,
+				Status:    rec.status,
+				Bytes:     rec.bytes,
+				Latency:   float64(time.Since(start)) / float64(time.Millisecond),
+				RequestID: catfact.RequestIDFromContext(r.Context()),
+				Referer:   r.Referer(),
+				UserAgent: r.UserAgent(),
+			}
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				entry.Remote = host
+			}
+			if id, ok := ClientIdentityFromContext(r.Context()); ok {
+				entry.User = id.CommonName
+			}
+			if entry.Status == 0 {
+				entry.Status = http.StatusOK
+			}
+			l.record(entry)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// record queues entry for writing, dropping it if the queue is full or the log is closed.
+func (l *AccessLog) record(entry accessEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		// The log was closed while the server was still answering requests.
+		l.dropped.Add(1)
+		return
+	}
+	select {
+	case l.entries <- entry:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// appendEntry appends the formatted entry, with a trailing newline, to line.
+func (l *AccessLog) appendEntry(line []byte, e accessEntry) []byte {
+	if l.format == AccessLogJSON {
+		raw, _ := json.Marshal(e)
+		return append(append(line, raw...), '\n')
+	}
+
+	user, bytes := dash(e.User), "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+	line = fmt.Appendf(line, "%s - %s [%s] \"%s %s %s\" %d %s",
+		e.Remote, clfEscape(user), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		clfEscape(e.Method), clfEscape(e.URI), clfEscape(e.Proto), e.Status, bytes)
+	if l.format == AccessLogCombined {
+		line = fmt.Appendf(line, " \"%s\" \"%s\"", clfEscape(dash(e.Referer)), clfEscape(dash(e.UserAgent)))
+	}
+	// Latency and request ID follow the standard fields, where log parsers ignore them.
+	return fmt.Appendf(line, " request_id=%s latency_ms=%.3f\n", dash(e.RequestID), e.Latency)
+}
+
+// dash returns s, or "-" for an empty s, as log formats write missing values.
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// clfEscape escapes quotes, backslashes and control characters so a value can't break a log line.
+func clfEscape(s string) string {
+	if !strings.ContainsAny(s, "\"\\") && strings.IndexFunc(s, func(r rune) bool { return r < 0x20 || r == 0x7f }) < 0 {
+		return s
+	}
+	quoted := strconv.Quote(s)
+	return quoted[1 : len(quoted)-1]
+}
+
+// responseRecorder records the status and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// nopCloser is a WriteCloser whose Close does nothing, so stdout is never closed.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"BuildAndStructureAMicroservice/catfact"
+)
+
+// bufferCloser collects what is written to it.
+type bufferCloser struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *bufferCloser) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *bufferCloser) Close() error { return nil }
+
+func (b *bufferCloser) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// serveLogged sends a request through an ApiServer logging in format and returns the log.
+func serveLogged(t *testing.T, format string) string {
+	t.Helper()
+
+	out := &bufferCloser{}
+	cfg := DefaultAccessLogConfig()
+	cfg.Format = format
+	accessLog, err := newAccessLog(out, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewApiServer(&staticService{fact: "Cats purr."}, WithAccessLog(accessLog)).Handler()
+	request := httptest.NewRequest(http.MethodGet, "/?page=1", nil)
+	request.Header.Set("Referer", "https://example.com/")
+	request.Header.Set("User-Agent", `curl/8.0 "quoted"`)
+	request.Header.Set(catfact.RequestIDHeader, "req-7")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	if err := accessLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		line   string
+	}{
+		{AccessLogCommon, `^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /\?page=1 HTTP/1\.1" 200 \d+ request_id=req-7 latency_ms=\d+\.\d{3}\n$`},
+		{AccessLogCombined, `^192\.0\.2\.1 - - \[[^\]]+\] "GET /\?page=1 HTTP/1\.1" 200 \d+ "https://example\.com/" "curl/8\.0 \\"quoted\\"" request_id=req-7 latency_ms=\d+\.\d{3}\n$`},
+	}
+
+	for _, tt := range tests {
+		if line := serveLogged(t, tt.format); !regexp.MustCompile(tt.line).MatchString(line) {
+			t.Errorf("%s: Expected a line matching %s but got %q", tt.format, tt.line, line)
+		}
+	}
+
+	var entry accessEntry
+	if err := json.Unmarshal([]byte(serveLogged(t, AccessLogJSON)), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Status != http.StatusOK || entry.Bytes == 0 || entry.RequestID != "req-7" || entry.URI != "/?page=1" {
+		t.Errorf("Expected a JSON entry for GET /?page=1 but got %+v", entry)
+	}
+}
+
+// blockingWriter blocks every write until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func (w *blockingWriter) Close() error { return nil }
+
+func TestAccessLogNeverBlocksRequests(t *testing.T) {
+	out := &blockingWriter{release: make(chan struct{})}
+	cfg := DefaultAccessLogConfig()
+	cfg.BufferSize = 1
+	accessLog, err := newAccessLog(out, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewApiServer(&staticService{fact: "Cats purr."}, WithAccessLog(accessLog)).Handler()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected requests to complete while the log writer is stuck")
+	}
+	if accessLog.Dropped() == 0 {
+		t.Error("Expected entries to be dropped")
+	}
+	close(out.release)
+	accessLog.Close()
+}
+
+func TestAccessLogConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*AccessLogConfig)
+		ok     bool
+	}{
+		{"default", func(*AccessLogConfig) {}, true},
+		{"unknown format", func(c *AccessLogConfig) { c.Format = "apache" }, false},
+		{"zero buffer", func(c *AccessLogConfig) { c.BufferSize = 0 }, false},
+		{"negative buffer", func(c *AccessLogConfig) { c.BufferSize = -1 }, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultAccessLogConfig()
+		tt.modify(&cfg)
+		accessLog, err := newAccessLog(nopCloser{io.Discard}, cfg)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Expected ok %v but got %v", tt.name, tt.ok, err)
+		}
+		if err == nil {
+			accessLog.Close()
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	f, err := OpenRotatingFile(path, 10, time.Hour, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every write overflows the 10 bytes, so each one after the first rotates.
+	now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+	for _, line := range []string{"first  1\n", "second 2\n", "third  3\n", "fourth 4\n"} {
+		now = now.Add(time.Second)
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Then the age limit rotates too.
+	now = now.Add(2 * time.Hour)
+	if _, err := f.Write([]byte("late\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := os.ReadFile(path)
+	if string(current) != "late\n" {
+		t.Errorf("Expected the current file to hold the last line but got %q", current)
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups but got %v", backups)
+	}
+	for _, name := range backups {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Errorf("Expected %s to be gzipped", name)
+		}
+	}
+
+	// The newest backup holds the line written before the age rotation.
+	raw, _ := os.Open(backups[1])
+	defer raw.Close()
+	zr, err := gzip.NewReader(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(zr)
+	if string(content) != "fourth 4\n" {
+		t.Errorf("Expected the newest backup to hold %q but got %q", "fourth 4\n", content)
+	}
+}
+
+func TestRotatingFileKeepsWritingWhenRotationFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	f, err := OpenRotatingFile(path, 10, 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+
+	// A non-empty directory in the way of the rotated name makes the rename fail.
+	blocker := path + "." + now.Format(rotationTimeFormat)
+	if err := os.MkdirAll(filepath.Join(blocker, "busy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first  1\n", "second 2\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Expected the write to succeed despite the failed rotation but got %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := os.ReadFile(path)
+	if string(current) != "first  1\nsecond 2\n" {
+		t.Errorf("Expected both lines in the current file but got %q", current)
+	}
+}
+
+func TestAccessLogDropsEntriesAfterClose(t *testing.T) {
+	accessLog, err := newAccessLog(nopCloser{io.Discard}, DefaultAccessLogConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := accessLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := accessLog.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if dropped := accessLog.Dropped(); dropped != 1 {
+		t.Errorf("Expected the entry to be dropped but got %d dropped", dropped)
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+
+	"BuildAndStructureAMicroservice/catfact"
+)
+
+// AdminServer serves the admin API on its own listener, away from public traffic.
+// Every route, including pprof, requires the admin bearer token.
+type AdminServer struct {
+	cfg        Config
+	stack      *Stack
+	prefetcher *Prefetcher
+	webhooks   *Webhooks
+	chaos      *ChaosService
+	policy     *PolicyService
+	tracer     *Tracer
+	started    time.Time
+	server     *http.Server
+}
+
+// NewAdminServer creates a new instance of AdminServer controlling stack, prefetcher and the
+// webhook subscriptions, and serving the traces kept by tracer. The prefetcher, webhooks and
+// tracer may be nil.
+func NewAdminServer(cfg Config, stack *Stack, prefetcher *Prefetcher, webhooks *Webhooks, tracer *Tracer) *AdminServer {
+	s := &AdminServer{
+		cfg:        cfg,
+		stack:      stack,
+		prefetcher: prefetcher,
+		webhooks:   webhooks,
+		tracer:     tracer,
+		started:    time.Now(),
+	}
+	for _, layer := range stack.Layers {
+		if chaos, ok := layer.Service.(*ChaosService); ok && s.chaos == nil {
+			s.chaos = chaos
+		}
+		if policy, ok := layer.Service.(*PolicyService); ok && s.policy == nil {
+			s.policy = policy
+		}
+	}
+	s.server = &http.Server{Handler: s.Handler()}
+	return s
+}
+
+// Handler returns the HTTP handler serving all the admin routes.
+func (s *AdminServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.HandleFunc(pattern, requireToken(s.cfg.AdminToken, h))
+	}
+
+	handle("/config", s.handleConfig)
+	handle("/loglevel", s.handleLogLevel)
+	handle("/cache/flush", s.handleCacheFlush)
+	handle("/breakers", s.handleBreakers)
+	handle("/breakers/trip", s.handleBreakers)
+	handle("/breakers/reset", s.handleBreakers)
+	handle("/prefetch", s.handlePrefetch)
+	handle("/prefetch/pause", s.handlePrefetch)
+	handle("/prefetch/resume", s.handlePrefetch)
+	handle("/webhooks", s.handleWebhooks)
+	handle("/webhooks/", s.handleWebhook)
+	handle("/chaos", s.handleChaos)
+	handle("/policy", s.handlePolicy)
+	handle("/runtime", s.handleRuntime)
+	handle("/debug/traces", s.handleTraces)
+	handle("/debug/vars", expvar.Handler().ServeHTTP)
+
+	handle("/debug/pprof/", pprof.Index)
+	handle("/debug/pprof/cmdline", pprof.Cmdline)
+	handle("/debug/pprof/profile", pprof.Profile)
+	handle("/debug/pprof/symbol", pprof.Symbol)
+	handle("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// Start starts the admin server on the specified address.
+// It returns http.ErrServerClosed once Shutdown has been called.
+func (s *AdminServer) Start(listenAddr string) error {
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	return s.server.Serve(ln)
+}
+
+// Shutdown gracefully stops the admin server.
+func (s *AdminServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// handleConfig shows the effective config, with the decorator options filled in and secrets hidden.
+func (s *AdminServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	cfg := s.cfg
+	if cfg.AdminToken != "" {
+		cfg.AdminToken = "[REDACTED]"
+	}
+	decorators, err := s.stack.Describe()
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg.Decorators = decorators
+
+	raw, err := marshalConfig(cfg)
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(raw))
+}
+
+// handleLogLevel shows the log level on GET and changes it on PUT or POST.
+func (s *AdminServer) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPut, http.MethodPost) {
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		var body struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&body); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		level, err := ParseLogLevel(body.Level)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		SetLogLevel(level)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"level": CurrentLogLevel().String()})
+}
+
+// handleCacheFlush drops the cached fact of every cache in the stack.
+func (s *AdminServer) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var flushed int
+	for _, layer := range s.stack.Layers {
+		if cache, ok := layer.Service.(*CacheService); ok {
+			cache.Flush()
+			flushed++
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"flushed": flushed})
+}
+
+// handleBreakers shows the state of every breaker in the stack, after tripping or
+// resetting them all when called on /breakers/trip or /breakers/reset.
+func (s *AdminServer) handleBreakers(w http.ResponseWriter, r *http.Request) {
+	action := strings.TrimPrefix(r.URL.Path, "/breakers")
+	method := http.MethodGet
+	if action != "" {
+		method = http.MethodPost
+	}
+	if !allowMethods(w, r, method) {
+		return
+	}
+
+	states := []BreakerState{}
+	for _, layer := range s.stack.Layers {
+		breaker, ok := layer.Service.(*BreakerService)
+		if !ok {
+			continue
+		}
+		switch action {
+		case "/trip":
+			breaker.Trip()
+		case "/reset":
+			breaker.Reset()
+		}
+		states = append(states, breaker.State())
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"breakers": states})
+}
+
+// handlePrefetch shows the prefetch pool, after pausing or resuming it when called on
+// /prefetch/pause or /prefetch/resume.
+func (s *AdminServer) handlePrefetch(w http.ResponseWriter, r *http.Request) {
+	action := strings.TrimPrefix(r.URL.Path, "/prefetch")
+	method := http.MethodGet
+	if action != "" {
+		method = http.MethodPost
+	}
+	if !allowMethods(w, r, method) {
+		return
+	}
+	if s.prefetcher == nil {
+		writeProblem(w, r, http.StatusNotFound, "prefetching is disabled")
+		return
+	}
+
+	switch action {
+	case "/pause":
+		s.prefetcher.Pause()
+	case "/resume":
+		s.prefetcher.Resume()
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"paused": s.prefetcher.Paused(),
+		"pooled": s.prefetcher.Len(),
+	})
+}
+
+// handleChaos shows the settings of the chaos decorator on GET and updates them on PUT or POST.
+// Updates are applied on top of the current settings, so partial documents are fine.
+func (s *AdminServer) handleChaos(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPut, http.MethodPost) {
+		return
+	}
+	if s.chaos == nil {
+		writeProblem(w, r, http.StatusNotFound, "the stack has no chaos decorator")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<16))
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		cfg := s.chaos.Config()
+		if err := unmarshalConfig(body, &cfg); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.chaos.SetConfig(cfg); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	raw, err := marshalConfig(s.chaos.Config())
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(raw))
+}
+
+// handlePolicy shows the policy rules and rejection counts on GET, and reloads the rules on POST.
+func (s *AdminServer) handlePolicy(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if s.policy == nil {
+		writeProblem(w, r, http.StatusNotFound, "the stack has no policy decorator")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if err := s.policy.Reload(); err != nil {
+			writeProblem(w, r, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, s.policy.Stats())
+}
+
+// handleWebhooks lists the webhook subscriptions on GET and registers a new one on POST.
+// The secret used to sign deliveries is only ever returned on registration.
+func (s *AdminServer) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	if s.webhooks == nil {
+		writeProblem(w, r, http.StatusNotFound, "webhooks are disabled")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"subscriptions": s.webhooks.Subscriptions()})
+	case http.MethodPost:
+		var body struct {
+			URL    string `json:"url"`
+			Secret string `json:"secret"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		sub, err := s.webhooks.Subscribe(body.URL, body.Secret)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, sub)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleWebhook shows a webhook subscription and its delivery state on GET and removes it on DELETE.
+func (s *AdminServer) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.webhooks == nil {
+		writeProblem(w, r, http.StatusNotFound, "webhooks are disabled")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/webhooks/")
+
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		var status SubscriptionStatus
+		if status, err = s.webhooks.Status(id); err == nil {
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+	case http.MethodDelete:
+		if err = s.webhooks.Unsubscribe(id); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	status := http.StatusInternalServerError
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		status = http.StatusNotFound
+	}
+	writeProblem(w, r, status, err.Error())
+}
+
+// handleRuntime shows Go runtime statistics.
+func (s *AdminServer) handleRuntime(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"go_version":     runtime.Version(),
+		"uptime":         time.Since(s.started).Round(time.Second).String(),
+		"goroutines":     runtime.NumGoroutine(),
+		"cpus":           runtime.NumCPU(),
+		"heap_alloc":     mem.HeapAlloc,
+		"heap_objects":   mem.HeapObjects,
+		"total_alloc":    mem.TotalAlloc,
+		"sys":            mem.Sys,
+		"num_gc":         mem.NumGC,
+		"gc_pause_total": time.Duration(mem.PauseTotalNs).String(),
+	})
+}
+
+// allowMethods reports whether r uses one of methods, answering 405 when it doesn't.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeProblem(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// generateToken returns a random token, used for the admin token when none is configured
+// and for webhook IDs and secrets.
+func generateToken() (string, error) {
+	return catfact.RandomHex(16)
+}
+
+// handleTraces lists the traces kept in memory, newest first. The trace_id query parameter
+// picks a single trace.
+func (s *AdminServer) handleTraces(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	if s.tracer == nil || s.tracer.Ring() == nil {
+		writeProblem(w, r, http.StatusNotFound, "traces aren't kept in memory")
+		return
+	}
+
+	limit, err := queryInt(r, "limit", 0)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	traces := s.tracer.Ring().Traces()
+	if id := r.URL.Query().Get("trace_id"); id != "" {
+		var found []Trace
+		for _, trace := range traces {
+			if trace.TraceID == id {
+				found = append(found, trace)
+			}
+		}
+		if len(found) == 0 {
+			writeProblem(w, r, http.StatusNotFound, fmt.Sprintf("no trace %s", id))
+			return
+		}
+		traces = found
+	}
+	if limit > 0 && len(traces) > limit {
+		traces = traces[:limit]
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Traces []Trace `json:"traces"`
+	}{traces})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestAdmin starts an admin server over a stack with a cache and a breaker.
+func newTestAdmin(t *testing.T) (*Stack, *httptest.Server) {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	cfg.Decorators = []DecoratorConfig{{Name: "cache"}, {Name: "breaker"}}
+
+	stack, err := BuildStack(&staticService{fact: "Cats purr."}, cfg.Decorators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewAdminServer(cfg, stack, nil, nil, nil).Handler())
+	t.Cleanup(server.Close)
+	return stack, server
+}
+
+// adminRequest sends an authenticated request to the admin server and decodes the JSON answer.
+func adminRequest(t *testing.T, method, url, body string, v interface{}) *http.Response {
+	t.Helper()
+
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer secret")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if v != nil {
+		if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return response
+}
+
+func TestAdminServerRequiresToken(t *testing.T) {
+	_, server := newTestAdmin(t)
+
+	for _, path := range []string{"/config", "/runtime", "/chaos", "/policy", "/debug/traces", "/debug/pprof/", "/debug/vars"} {
+		response, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: Expected 401 but got %d", path, response.StatusCode)
+		}
+	}
+}
+
+func TestAdminServerConfigHidesToken(t *testing.T) {
+	_, server := newTestAdmin(t)
+
+	var cfg map[string]interface{}
+	adminRequest(t, http.MethodGet, server.URL+"/config", "", &cfg)
+
+	if cfg["admin_token"] != "[REDACTED]" {
+		t.Errorf("Expected the admin token to be redacted but got %v", cfg["admin_token"])
+	}
+	if cfg["request_timeout"] != "15s" {
+		t.Errorf("Expected request_timeout 15s but got %v", cfg["request_timeout"])
+	}
+}
+
+func TestAdminServerBreakersAndLogLevel(t *testing.T) {
+	stack, server := newTestAdmin(t)
+	defer SetLogLevel(CurrentLogLevel())
+
+	adminRequest(t, http.MethodPost, server.URL+"/breakers/trip", "", nil)
+	if state := stack.Layers[1].Service.(*BreakerService).State(); state != BreakerOpen {
+		t.Errorf("Expected the breaker to be %s but got %s", BreakerOpen, state)
+	}
+
+	adminRequest(t, http.MethodPost, server.URL+"/breakers/reset", "", nil)
+	if state := stack.Layers[1].Service.(*BreakerService).State(); state != BreakerClosed {
+		t.Errorf("Expected the breaker to be %s but got %s", BreakerClosed, state)
+	}
+
+	response := adminRequest(t, http.MethodPut, server.URL+"/loglevel", `{"level": "debug"}`, nil)
+	if response.StatusCode != http.StatusOK || CurrentLogLevel() != LevelDebug {
+		t.Errorf("Expected log level debug but got %s (status %d)", CurrentLogLevel(), response.StatusCode)
+	}
+}
+
+func TestAdminServerRequiresBearerScheme(t *testing.T) {
+	_, server := newTestAdmin(t)
+
+	for _, header := range []string{"secret", "Basic secret", "bearer secret", "Bearer wrong"} {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/config", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Authorization", header)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%q: Expected 401 but got %d", header, response.StatusCode)
+		}
+	}
+}
+
+func TestAdminServerPolicy(t *testing.T) {
+	_, server := newTestAdmin(t)
+
+	// The test stack has no policy decorator.
+	if response := adminRequest(t, http.MethodGet, server.URL+"/policy", "", nil); response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 without a policy decorator but got %d", response.StatusCode)
+	}
+
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	stack, err := BuildStack(&staticService{fact: "Cats purr."}, []DecoratorConfig{{Name: "policy"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = httptest.NewServer(NewAdminServer(cfg, stack, nil, nil, nil).Handler())
+	defer server.Close()
+
+	var stats PolicyStats
+	if response := adminRequest(t, http.MethodPost, server.URL+"/policy", "", &stats); response.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 but got %d", response.StatusCode)
+	}
+	if response := adminRequest(t, http.MethodDelete, server.URL+"/policy", "", nil); response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 but got %d", response.StatusCode)
+	}
+}
+
+func TestAdminServerWebhooks(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	stack, err := BuildStack(&staticService{fact: "Cats purr."}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks := newTestWebhooks(t, stack, filepath.Join(t.TempDir(), "webhooks.json"))
+	server := httptest.NewServer(NewAdminServer(cfg, stack, nil, webhooks, nil).Handler())
+	defer server.Close()
+
+	// Subscriptions can't be managed without the token.
+	response, err := http.Post(server.URL+"/webhooks", "application/json", strings.NewReader(`{"url": "http://example.com/hook"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 but got %d", response.StatusCode)
+	}
+
+	var sub Subscription
+	if response := adminRequest(t, http.MethodPost, server.URL+"/webhooks", `{"url": "http://example.com/hook"}`, &sub); response.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected 201 but got %d", response.StatusCode)
+	}
+	if sub.Secret == "" {
+		t.Error("Expected the secret to be returned on registration")
+	}
+	var status SubscriptionStatus
+	adminRequest(t, http.MethodGet, server.URL+"/webhooks/"+sub.ID, "", &status)
+	if status.ID != sub.ID || status.Secret != "" {
+		t.Errorf("Expected subscription %s without its secret but got %+v", sub.ID, status)
+	}
+	if response := adminRequest(t, http.MethodDelete, server.URL+"/webhooks/"+sub.ID, "", nil); response.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected 204 but got %d", response.StatusCode)
+	}
+}
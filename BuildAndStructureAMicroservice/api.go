@@ -2,43 +2,495 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"BuildAndStructureAMicroservice/catfact"
 )
 
 // ApiServer handles incoming HTTP requests and routes them to the appropriate handlers.
 type ApiServer struct {
-	svc Service
+	svc            Service
+	policies       []*PolicyService
+	prefetcher     *Prefetcher
+	webhooks       *Webhooks
+	scheduler      *Scheduler
+	tls            *TLSReloader
+	accessLog      *AccessLog
+	tracer         *Tracer
+	dashboard      *Dashboard
+	requestTimeout time.Duration
+	server         *http.Server
+}
+
+// ApiOption configures an ApiServer.
+type ApiOption func(*ApiServer)
+
+// WithPrefetcher makes the server hand out prefetched facts before asking the Service.
+// The prefetcher is started with the server and stopped when the server shuts down.
+func WithPrefetcher(p *Prefetcher) ApiOption {
+	return func(s *ApiServer) {
+		s.prefetcher = p
+	}
+}
+
+// WithRequestTimeout bounds how long a handler waits on the Service. Zero means no limit.
+func WithRequestTimeout(d time.Duration) ApiOption {
+	return func(s *ApiServer) {
+		s.requestTimeout = d
+	}
+}
+
+// WithWebhooks runs the delivery worker of w with the server: it is started with the server
+// and stopped when the server shuts down. Subscriptions are managed on the admin listener.
+func WithWebhooks(w *Webhooks) ApiOption {
+	return func(s *ApiServer) {
+		s.webhooks = w
+	}
+}
+
+// WithScheduler serves the facts of the scheduled jobs at /v1/fact/{job}. The scheduler is
+// started with the server and stopped when the server shuts down.
+func WithScheduler(sc *Scheduler) ApiOption {
+	return func(s *ApiServer) {
+		s.scheduler = sc
+	}
+}
+
+// WithTLS serves HTTPS with the certificates of r. Verified client certificates put a
+// ClientIdentity into the request context.
+func WithTLS(r *TLSReloader) ApiOption {
+	return func(s *ApiServer) {
+		s.tls = r
+	}
 }
 
-// NewApiServer creates a new instance of ApiServer with the provided Service.
-func NewApiServer(svc Service) *ApiServer {
-	return &ApiServer{
+// WithAccessLog records every request in l. The access log is closed when the server shuts down.
+func WithAccessLog(l *AccessLog) ApiOption {
+	return func(s *ApiServer) {
+		s.accessLog = l
+	}
+}
+
+// WithTracer traces every request with t. Traces kept in memory are served at /debug/traces
+// of the admin API.
+func WithTracer(t *Tracer) ApiOption {
+	return func(s *ApiServer) {
+		s.tracer = t
+	}
+}
+
+// WithDashboard records the requests of the server in d and serves its status page at /dashboard.
+func WithDashboard(d *Dashboard) ApiOption {
+	return func(s *ApiServer) {
+		s.dashboard = d
+	}
+}
+
+// NewApiServer creates a new instance of ApiServer with the provided Service. When svc is a
+// *Stack, requests that a client-scoped policy rule applies to skip the prefetch pool and the
+// caches of the stack, whose facts were checked for other clients.
+func NewApiServer(svc Service, opts ...ApiOption) *ApiServer {
+	s := &ApiServer{
 		svc: svc,
 	}
+	if stack, ok := svc.(*Stack); ok {
+		for _, layer := range stack.Layers {
+			if policy, ok := layer.Service.(*PolicyService); ok {
+				s.policies = append(s.policies, policy)
+			}
+		}
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.server = &http.Server{Handler: s.Handler()}
+	return s
+}
+
+// route is an entry of the routing table of an ApiServer, together with its documentation
+// for the OpenAPI document.
+type route struct {
+	pattern string
+	handler http.Handler
+	doc     routeDoc
+}
+
+// routes returns the routing table of the server.
+func (s *ApiServer) routes() []route {
+	routes := []route{
+		{"/", http.HandlerFunc(s.handleGetCatFact), routeDoc{Operations: []operationDoc{{
+			Method: http.MethodGet, Summary: "Get a random cat fact", Params: enrichParams,
+			Status: http.StatusOK, Response: CatFact{}, Errors: append([]int{http.StatusBadRequest}, serviceErrors...),
+		}}}},
+		{"/facts", http.HandlerFunc(s.handleListFacts), routeDoc{Operations: []operationDoc{{
+			Method: http.MethodGet, Summary: "List cat facts",
+			Params: append(append(pageParams[:len(pageParams):len(pageParams)],
+				paramDoc{Name: "max_length", In: "query", Type: "integer", Description: "Longest fact to return."}), enrichParams...),
+			Status: http.StatusOK, Response: FactPage{}, Errors: append([]int{http.StatusBadRequest}, serviceErrors...),
+		}}}},
+		{"/breeds", http.HandlerFunc(s.handleListBreeds), routeDoc{Operations: []operationDoc{{
+			Method: http.MethodGet, Summary: "List cat breeds", Params: pageParams,
+			Status: http.StatusOK, Response: BreedPage{}, Errors: append([]int{http.StatusBadRequest}, serviceErrors...),
+		}}}},
+		{"/openapi.json", http.HandlerFunc(s.handleOpenAPI), routeDoc{Operations: []operationDoc{{
+			Method: http.MethodGet, Summary: "Get this OpenAPI document",
+			Status: http.StatusOK, Response: map[string]interface{}{},
+		}}}},
+	}
+	if s.scheduler != nil {
+		routes = append(routes, route{"/v1/fact/", http.HandlerFunc(s.handleScheduledFact), routeDoc{Path: "/v1/fact/{job}", Operations: []operationDoc{{
+			Method: http.MethodGet, Summary: "Get the latest fact of a scheduled job, such as daily",
+			Params: []paramDoc{{Name: "job", In: "path", Type: "string"}},
+			Status: http.StatusOK, Response: ScheduledFact{}, Errors: []int{http.StatusNotFound, http.StatusMethodNotAllowed},
+		}}}})
+	}
+	if s.dashboard != nil {
+		routes = append(routes,
+			route{"/dashboard", http.HandlerFunc(s.dashboard.handleDashboard), routeDoc{Operations: []operationDoc{{
+				Method: http.MethodGet, Summary: "Show the status dashboard",
+				Status: http.StatusOK, ContentType: "text/html",
+			}}}},
+			route{"/dashboard/data", http.HandlerFunc(s.dashboard.handleData), routeDoc{Operations: []operationDoc{{
+				Method: http.MethodGet, Summary: "Get the data shown by the status dashboard",
+				Status: http.StatusOK, Response: DashboardData{},
+			}}}},
+			route{"/dashboard/assets/", s.dashboard.assets(), routeDoc{Path: "/dashboard/assets/{file}", Operations: []operationDoc{{
+				Method: http.MethodGet, Summary: "Get a script or style of the status dashboard",
+				Params: []paramDoc{{Name: "file", In: "path", Type: "string", Description: "Name of the asset."}},
+				Status: http.StatusOK, ContentType: "application/octet-stream", Errors: []int{http.StatusNotFound},
+			}}}},
+		)
+	}
+	return routes
+}
+
+// Handler returns the HTTP handler serving all the routes of the server.
+func (s *ApiServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range s.routes() {
+		mux.Handle(rt.pattern, rt.handler)
+	}
+	var handler http.Handler = mux
+	if s.dashboard != nil {
+		handler = s.dashboard.Middleware(handler, func(r *http.Request) bool {
+			switch _, pattern := mux.Handler(r); pattern {
+			case "/", "/facts", "/breeds":
+				return true
+			}
+			return false
+		})
+	}
+	if s.accessLog != nil {
+		handler = s.accessLog.Middleware(handler)
+	}
+	if s.tracer != nil {
+		handler = s.tracer.Middleware(handler, func(r *http.Request) string {
+			_, pattern := mux.Handler(r)
+			return "HTTP " + r.Method + " " + pattern
+		})
+	}
+	return withRequestID(withClientIdentity(handler))
+}
+
+// withRequestID makes sure every request has an ID, reusing the one sent by the client if any.
+// The ID is echoed in the response and carried by the request context, so a remote Service
+// called while handling the request propagates it.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(catfact.RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = catfact.NewRequestID()
+		}
+		w.Header().Set(catfact.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(catfact.WithRequestID(r.Context(), id)))
+	})
 }
 
 // Start starts the HTTP server and listens for incoming requests on the specified address.
+// It returns http.ErrServerClosed once Shutdown has been called.
 func (s *ApiServer) Start(listenAddr string) error {
-	http.HandleFunc("/", s.handleGetCatFact)
-	return http.ListenAndServe(listenAddr, nil)
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves incoming requests on ln, over TLS when configured.
+// It returns http.ErrServerClosed once Shutdown has been called.
+func (s *ApiServer) Serve(ln net.Listener) error {
+	if s.tls != nil {
+		ln = tls.NewListener(ln, s.tls.TLSConfig())
+	}
+	if s.prefetcher != nil {
+		s.prefetcher.Start()
+	}
+	if s.webhooks != nil {
+		s.webhooks.Start()
+	}
+	if s.scheduler != nil {
+		s.scheduler.Start()
+	}
+	return s.server.Serve(ln)
+}
+
+// Shutdown gracefully stops the HTTP server and the background work tied to it.
+func (s *ApiServer) Shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	if s.prefetcher != nil {
+		s.prefetcher.Stop()
+	}
+	if s.webhooks != nil {
+		s.webhooks.Stop()
+	}
+	if s.scheduler != nil {
+		s.scheduler.Stop()
+	}
+	if s.accessLog != nil {
+		if closeErr := s.accessLog.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
 
 // handleGetCatFact is the HTTP handler function for retrieving a cat fact.
 func (s *ApiServer) handleGetCatFact(w http.ResponseWriter, r *http.Request) {
+	ctx, err := enrichParam(r.Context(), r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Facts from the prefetch pool and the caches were checked for other clients.
+	scoped := s.clientScoped(ctx)
+	if scoped {
+		ctx = withoutCache(ctx)
+	}
+
+	// Hand out a prefetched fact when one is ready, unless the request asks for its own
+	// enrichers, which prefetched facts weren't fetched with.
+	if _, ok := r.URL.Query()["enrich"]; !ok && s.prefetcher != nil && !scoped {
+		if fact, ok := s.prefetcher.Take(); ok {
+			w.Header().Set("X-Cache", string(CachePrefetch))
+			s.dashboard.ObserveFact(fact)
+			writeJSON(w, http.StatusOK, fact)
+			return
+		}
+	}
+
+	// Ask a caching service, if any, to tell us how the fact was produced.
+	ctx, cancel := s.requestContext(r.WithContext(ctx))
+	defer cancel()
+	ctx, cacheStatus := withCacheStatus(ctx)
+
 	// Call the GetCatFact method of the underlying service to retrieve a cat fact.
-	fact, err := s.svc.GetCatFact(context.Background())
+	fact, err := s.svc.GetCatFact(ctx)
+	if *cacheStatus != "" {
+		w.Header().Set("X-Cache", string(*cacheStatus))
+	}
+	if err != nil {
+		writeProblem(w, r, errorStatus(err), err.Error())
+		return
+	}
+
+	s.dashboard.ObserveFact(fact)
+	writeJSON(w, http.StatusOK, fact)
+}
+
+// clientScoped reports whether a client-scoped policy rule applies to the request of ctx.
+func (s *ApiServer) clientScoped(ctx context.Context) bool {
+	for _, policy := range s.policies {
+		if policy.ScopedTo(ctx) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleListFacts is the HTTP handler function for retrieving a page of cat facts.
+func (s *ApiServer) handleListFacts(w http.ResponseWriter, r *http.Request) {
+	page, limit, err := paginationParams(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	maxLength, err := queryInt(r, "max_length", 0)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx, err := enrichParam(r.Context(), r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := s.requestContext(r.WithContext(ctx))
+	defer cancel()
+
+	facts, err := s.svc.ListFacts(ctx, page, limit, maxLength)
+	if err != nil {
+		writeProblem(w, r, errorStatus(err), err.Error())
+		return
+	}
+
+	rewritePageLinks(&facts.Page, r)
+	writeJSON(w, http.StatusOK, facts)
+}
+
+// handleListBreeds is the HTTP handler function for retrieving a page of cat breeds.
+func (s *ApiServer) handleListBreeds(w http.ResponseWriter, r *http.Request) {
+	page, limit, err := paginationParams(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+
+	breeds, err := s.svc.ListBreeds(ctx, page, limit)
 	if err != nil {
-		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"error": err.Error()})
+		writeProblem(w, r, errorStatus(err), err.Error())
 		return
 	}
 
+	rewritePageLinks(&breeds.Page, r)
+	writeJSON(w, http.StatusOK, breeds)
+}
+
+// handleScheduledFact is the HTTP handler function for retrieving the latest fact of a scheduled job,
+// such as the fact of the day at /v1/fact/daily.
+func (s *ApiServer) handleScheduledFact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	fact, err := s.scheduler.Latest(strings.TrimPrefix(r.URL.Path, "/v1/fact/"))
+	if err != nil {
+		writeProblem(w, r, http.StatusNotFound, err.Error())
+		return
+	}
 	writeJSON(w, http.StatusOK, fact)
 }
 
+// requireToken only lets requests carrying token as a bearer token through to next.
+// An empty token disables the route altogether.
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeProblem(w, r, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requestContext returns the context handlers pass to the Service, bounded by the request timeout.
+func (s *ApiServer) requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if s.requestTimeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), s.requestTimeout)
+}
+
+// paginationParams reads the page and limit query parameters of r.
+func paginationParams(r *http.Request) (page, limit int, err error) {
+	if page, err = queryInt(r, "page", 1); err != nil {
+		return 0, 0, err
+	}
+	if limit, err = queryInt(r, "limit", 0); err != nil {
+		return 0, 0, err
+	}
+	return page, limit, nil
+}
+
+// queryInt reads a non-negative integer query parameter, falling back to def when it is absent.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, raw)
+	}
+	return n, nil
+}
+
+// rewritePageLinks points the upstream pagination links at this server, keeping their cursors.
+func rewritePageLinks(page *Page, r *http.Request) {
+	rewrite := func(link string) string {
+		if link == "" {
+			return ""
+		}
+		upstream, err := url.Parse(link)
+		if err != nil {
+			return link
+		}
+		local := url.URL{Path: r.URL.Path, RawQuery: upstream.RawQuery}
+		return local.String()
+	}
+
+	page.Path = r.URL.Path
+	page.FirstPageURL = rewrite(page.FirstPageURL)
+	page.LastPageURL = rewrite(page.LastPageURL)
+	page.NextPageURL = rewrite(page.NextPageURL)
+	page.PrevPageURL = rewrite(page.PrevPageURL)
+	for i := range page.Links {
+		page.Links[i].URL = rewrite(page.Links[i].URL)
+	}
+}
+
+// errorStatus maps an error returned by the Service to an HTTP status code.
+func errorStatus(err error) int {
+	var (
+		upstreamErr *UpstreamError
+		urlErr      *url.Error
+		netErr      net.Error
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout
+	case errors.As(err, &upstreamErr), errors.Is(err, ErrEmptyFact), errors.Is(err, ErrPolicyRejected), errors.As(err, &urlErr):
+		return http.StatusBadGateway
+	case errors.Is(err, ErrBreakerOpen), errors.Is(err, ErrChaos):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// writeProblem writes an RFC 7807 problem document describing an error of r.
+func writeProblem(w http.ResponseWriter, r *http.Request, statusCode int, detail string) error {
+	problem := catfact.NewProblem(statusCode, detail)
+	problem.Instance = catfact.RequestIDFromContext(r.Context())
+	w.Header().Set("Content-Type", catfact.ProblemContentType)
+	w.WriteHeader(statusCode)
+	return json.NewEncoder(w).Encode(problem)
+}
+
 // writeJSON writes the provided data as JSON response with the specified status code.
 func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	w.Header().Add("Content-Type", "application/json")
 	return json.NewEncoder(w).Encode(data)
 }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"BuildAndStructureAMicroservice/catfact"
+	"BuildAndStructureAMicroservice/fakeupstream"
+)
+
+// newTestStack wires CatFactService, LoggingService and ApiServer against a fake upstream,
+// the same way main does against catfact.ninja.
+func newTestStack(t *testing.T, clientTimeout time.Duration) (*fakeupstream.Server, *httptest.Server) {
+	t.Helper()
+
+	upstream := fakeupstream.New()
+	t.Cleanup(upstream.Close)
+
+	cfg := DefaultHTTPClientConfig()
+	cfg.Timeout = clientTimeout
+	client, err := NewHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewCatFactService(upstream.URL, WithHTTPClient(client))
+	svc = NewLoggingService(svc)
+
+	server := httptest.NewServer(NewApiServer(svc).Handler())
+	t.Cleanup(server.Close)
+
+	return upstream, server
+}
+
+// getJSON performs a GET request against url and decodes the JSON response into v.
+func getJSON(t *testing.T, url string, v interface{}) *http.Response {
+	t.Helper()
+
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	// Errors are described by problem documents.
+	expected := "application/json"
+	if response.StatusCode >= 400 {
+		expected = catfact.ProblemContentType
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != expected {
+		t.Errorf("Expected Content-Type %s but got %q", expected, contentType)
+	}
+	if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestApiServerGetCatFact(t *testing.T) {
+	_, server := newTestStack(t, time.Second)
+
+	var fact CatFact
+	response := getJSON(t, server.URL, &fact)
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 but got %d", response.StatusCode)
+	}
+	if expected := fakeupstream.DefaultFacts()[0].Fact; fact.Fact != expected {
+		t.Errorf("Expected %q but got %q", expected, fact.Fact)
+	}
+}
+
+func TestApiServerUpstreamFailures(t *testing.T) {
+	upstream, server := newTestStack(t, 100*time.Millisecond)
+
+	tests := []struct {
+		name       string
+		fault      fakeupstream.Fault
+		sticky     bool
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name:       "upstream error status",
+			fault:      fakeupstream.Fault{Status: http.StatusServiceUnavailable},
+			wantStatus: http.StatusBadGateway,
+			wantError:  "status=503",
+		},
+		{
+			name:       "upstream html page",
+			fault:      fakeupstream.Fault{ContentType: "text/html"},
+			wantStatus: http.StatusBadGateway,
+			wantError:  "unexpected content type",
+		},
+		{
+			name:       "upstream timeout",
+			fault:      fakeupstream.Fault{Latency: time.Second},
+			wantStatus: http.StatusGatewayTimeout,
+			wantError:  "Timeout",
+		},
+		{
+			name:       "malformed json",
+			fault:      fakeupstream.Fault{Malformed: true},
+			wantStatus: http.StatusBadGateway,
+			wantError:  "invalid response body",
+		},
+		{
+			name:       "truncated body",
+			fault:      fakeupstream.Fault{Truncate: true},
+			wantStatus: http.StatusBadGateway,
+			wantError:  "invalid response body",
+		},
+		{
+			name:       "empty fact",
+			fault:      fakeupstream.Fault{Body: `{"fact":"","length":0}`},
+			wantStatus: http.StatusBadGateway,
+			wantError:  ErrEmptyFact.Error(),
+		},
+		{
+			name:       "dropped connection",
+			fault:      fakeupstream.Fault{Drop: true},
+			sticky:     true,
+			wantStatus: http.StatusBadGateway,
+			wantError:  "EOF",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream.Reset()
+			if tt.sticky {
+				upstream.SetFault(fakeupstream.PathFact, tt.fault)
+			} else {
+				upstream.Inject(fakeupstream.PathFact, tt.fault)
+			}
+
+			var problem catfact.Problem
+			response := getJSON(t, server.URL, &problem)
+
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("Expected %d but got %d", tt.wantStatus, response.StatusCode)
+			}
+			if !strings.Contains(problem.Detail, tt.wantError) {
+				t.Errorf("Expected error containing %q but got %q", tt.wantError, problem.Detail)
+			}
+			if problem.Instance == "" || problem.Instance != response.Header.Get(catfact.RequestIDHeader) {
+				t.Errorf("Expected the problem instance to be the request ID but got %q", problem.Instance)
+			}
+		})
+	}
+}
+
+func TestApiServerListFacts(t *testing.T) {
+	_, server := newTestStack(t, time.Second)
+
+	var page FactPage
+	response := getJSON(t, server.URL+"/facts?page=1&limit=5", &page)
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 but got %d", response.StatusCode)
+	}
+	if len(page.Data) != 5 {
+		t.Errorf("Expected 5 facts but got %d", len(page.Data))
+	}
+	// Pagination links must point back at us rather than at the upstream.
+	if expected := "/facts?page=2"; page.NextPageURL != expected {
+		t.Errorf("Expected next page %q but got %q", expected, page.NextPageURL)
+	}
+}
+
+func TestApiServerListBreedsBadRequest(t *testing.T) {
+	_, server := newTestStack(t, time.Second)
+
+	var problem catfact.Problem
+	response := getJSON(t, server.URL+"/breeds?page=abc", &problem)
+
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 but got %d", response.StatusCode)
+	}
+}
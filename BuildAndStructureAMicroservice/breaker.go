@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen is returned while the circuit breaker refuses calls.
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerConfig holds the settings of a BreakerService.
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker.
+	FailureThreshold int `json:"failure_threshold"`
+	// OpenTimeout is how long the breaker stays open before letting a trial call through.
+	OpenTimeout time.Duration `json:"open_timeout"`
+}
+
+// DefaultBreakerConfig returns the default circuit breaker settings.
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+	}
+}
+
+// validate checks that the threshold and timeout make sense.
+func (c BreakerConfig) validate() error {
+	if c.FailureThreshold <= 0 {
+		return fmt.Errorf("failure_threshold must be positive, got %d", c.FailureThreshold)
+	}
+	if c.OpenTimeout <= 0 {
+		return fmt.Errorf("open_timeout must be positive, got %v", c.OpenTimeout)
+	}
+	return nil
+}
+
+// BreakerService is a service wrapper that stops calling the underlying service after
+// repeated failures, giving the upstream time to recover.
+type BreakerService struct {
+	next Service
+	cfg  BreakerConfig
+	now  func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// NewBreakerService creates a new instance of BreakerService with the provided underlying Service.
+// It returns an error when the threshold or timeout of cfg make no sense.
+func NewBreakerService(next Service, cfg BreakerConfig) (*BreakerService, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &BreakerService{
+		next:  next,
+		cfg:   cfg,
+		now:   time.Now,
+		state: BreakerClosed,
+	}, nil
+}
+
+// GetCatFact retrieves a cat fact unless the breaker is open.
+func (s *BreakerService) GetCatFact(ctx context.Context) (fact *CatFact, err error) {
+	if err := s.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.record(ctx, err) }()
+
+	return s.next.GetCatFact(ctx)
+}
+
+// ListFacts retrieves a page of cat facts unless the breaker is open.
+func (s *BreakerService) ListFacts(ctx context.Context, page, limit, maxLength int) (facts *FactPage, err error) {
+	if err := s.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.record(ctx, err) }()
+
+	return s.next.ListFacts(ctx, page, limit, maxLength)
+}
+
+// ListBreeds retrieves a page of cat breeds unless the breaker is open.
+func (s *BreakerService) ListBreeds(ctx context.Context, page, limit int) (breeds *BreedPage, err error) {
+	if err := s.allow(); err != nil {
+		return nil, err
+	}
+	defer func() { s.record(ctx, err) }()
+
+	return s.next.ListBreeds(ctx, page, limit)
+}
+
+// State returns the current state of the breaker.
+func (s *BreakerService) State() BreakerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state
+}
+
+// Trip forces the breaker open.
+func (s *BreakerService) Trip() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.open()
+}
+
+// Reset forces the breaker closed and forgets past failures.
+func (s *BreakerService) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state, s.failures, s.trial = BreakerClosed, 0, false
+}
+
+// allow reports whether a call may go through, moving an expired open breaker to half-open.
+func (s *BreakerService) allow() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == BreakerOpen && s.now().Sub(s.openedAt) >= s.cfg.OpenTimeout {
+		s.state = BreakerHalfOpen
+	}
+	switch {
+	case s.state == BreakerOpen:
+		return ErrBreakerOpen
+	case s.state == BreakerHalfOpen && s.trial:
+		// Only one trial call at a time while half-open.
+		return ErrBreakerOpen
+	case s.state == BreakerHalfOpen:
+		s.trial = true
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of a call. Calls abandoned by the caller don't count.
+func (s *BreakerService) record(ctx context.Context, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trial = false
+	switch {
+	case err == nil:
+		s.state, s.failures = BreakerClosed, 0
+	case ctx.Err() != nil:
+	case s.state == BreakerHalfOpen:
+		s.open()
+	default:
+		s.failures++
+		if s.failures >= s.cfg.FailureThreshold {
+			s.open()
+		}
+	}
+}
+
+// open moves the breaker to the open state. The caller must hold s.mu.
+func (s *BreakerService) open() {
+	s.state, s.openedAt, s.trial = BreakerOpen, s.now(), false
+}
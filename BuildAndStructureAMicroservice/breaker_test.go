@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestBreaker wraps next in a breaker with the settings of cfg.
+func newTestBreaker(t *testing.T, next Service, cfg BreakerConfig) *BreakerService {
+	t.Helper()
+
+	breaker, err := NewBreakerService(next, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return breaker
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	upstream := &flakyService{failures: 1 << 30, err: errors.New("upstream down")}
+	breaker := newTestBreaker(t, upstream, BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute})
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+
+	// Each step makes a call after moving the clock, and checks its outcome and the state it leaves.
+	steps := []struct {
+		name    string
+		advance time.Duration
+		succeed bool
+		wantErr error
+		state   BreakerState
+	}{
+		{name: "first failure", wantErr: upstream.err, state: BreakerClosed},
+		{name: "threshold reached", wantErr: upstream.err, state: BreakerOpen},
+		{name: "open refuses", wantErr: ErrBreakerOpen, state: BreakerOpen},
+		{name: "failed trial", advance: time.Minute, wantErr: upstream.err, state: BreakerOpen},
+		{name: "still open", advance: time.Second, wantErr: ErrBreakerOpen, state: BreakerOpen},
+		{name: "successful trial", advance: time.Minute, succeed: true, state: BreakerClosed},
+		{name: "closed again", succeed: true, state: BreakerClosed},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if step.succeed {
+			upstream.failures = 0
+		}
+		calls := upstream.calls
+		_, err := breaker.GetCatFact(context.Background())
+		if !errors.Is(err, step.wantErr) || (step.wantErr == nil && err != nil) {
+			t.Errorf("%s: Expected %v but got %v", step.name, step.wantErr, err)
+		}
+		if refused := upstream.calls == calls; refused != (step.wantErr == ErrBreakerOpen) {
+			t.Errorf("%s: Expected the upstream to be called: %v", step.name, !refused)
+		}
+		if state := breaker.State(); state != step.state {
+			t.Errorf("%s: Expected state %s but got %s", step.name, step.state, state)
+		}
+	}
+}
+
+func TestBreakerSingleTrialWhileHalfOpen(t *testing.T) {
+	breaker := newTestBreaker(t, &staticService{fact: "Cats purr."}, DefaultBreakerConfig())
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+	breaker.Trip()
+
+	now = now.Add(DefaultBreakerConfig().OpenTimeout)
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("Expected a trial call but got %v", err)
+	}
+	if state := breaker.State(); state != BreakerHalfOpen {
+		t.Errorf("Expected state %s but got %s", BreakerHalfOpen, state)
+	}
+	if err := breaker.allow(); !errors.Is(err, ErrBreakerOpen) {
+		t.Errorf("Expected a second trial to be refused but got %v", err)
+	}
+}
+
+func TestBreakerIgnoresAbandonedCalls(t *testing.T) {
+	upstream := &flakyService{failures: 1 << 30, err: context.Canceled}
+	breaker := newTestBreaker(t, upstream, BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	breaker.GetCatFact(ctx)
+	if state := breaker.State(); state != BreakerClosed {
+		t.Errorf("Expected a call abandoned by the caller not to count but got state %s", state)
+	}
+}
+
+func TestBreakerConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*BreakerConfig)
+		ok     bool
+	}{
+		{"default", func(*BreakerConfig) {}, true},
+		{"zero threshold", func(c *BreakerConfig) { c.FailureThreshold = 0 }, false},
+		{"negative threshold", func(c *BreakerConfig) { c.FailureThreshold = -1 }, false},
+		{"zero open timeout", func(c *BreakerConfig) { c.OpenTimeout = 0 }, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultBreakerConfig()
+		tt.modify(&cfg)
+		_, err := NewBreakerService(&staticService{}, cfg)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Expected ok %v but got %v", tt.name, tt.ok, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CacheStatus describes how a cached response was produced.
+type CacheStatus string
+
+const (
+	CacheHit   CacheStatus = "HIT"
+	CacheStale CacheStatus = "STALE"
+	CacheMiss  CacheStatus = "MISS"
+
+	// CachePrefetch marks a fact handed out from the Prefetcher pool.
+	CachePrefetch CacheStatus = "PREFETCH"
+)
+
+type cacheStatusKey struct{}
+
+// withCacheStatus returns a context in which a caching Service can record the CacheStatus of a call.
+func withCacheStatus(ctx context.Context) (context.Context, *CacheStatus) {
+	status := new(CacheStatus)
+	return context.WithValue(ctx, cacheStatusKey{}, status), status
+}
+
+// setCacheStatus records status in ctx, if the caller asked for it.
+func setCacheStatus(ctx context.Context, status CacheStatus) {
+	if s, ok := ctx.Value(cacheStatusKey{}).(*CacheStatus); ok {
+		*s = status
+	}
+}
+
+type cacheBypassKey struct{}
+
+// withoutCache returns a context in which caching Services pass calls straight through.
+func withoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+// CacheConfig holds the settings of a CacheService.
+type CacheConfig struct {
+	// TTL is how long a fact is served as fresh.
+	TTL time.Duration `json:"ttl"`
+	// StaleWhileRevalidate is how long after the TTL a stale fact is still served
+	// while a background refresh is running.
+	StaleWhileRevalidate time.Duration `json:"stale_while_revalidate"`
+	// StaleIfError is how long after the TTL a stale fact is served when the upstream fails.
+	StaleIfError time.Duration `json:"stale_if_error"`
+	// RefreshTimeout bounds a single background refresh.
+	RefreshTimeout time.Duration `json:"refresh_timeout"`
+}
+
+// DefaultCacheConfig returns the default cache settings.
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		TTL:                  10 * time.Second,
+		StaleWhileRevalidate: time.Minute,
+		StaleIfError:         10 * time.Minute,
+		RefreshTimeout:       5 * time.Second,
+	}
+}
+
+// CacheService is a service wrapper that keeps serving the last good cat fact while it
+// refreshes it in the background, and keeps serving it for a while when the upstream fails.
+// Concurrent misses share a single upstream call. Listings are passed straight through to
+// the underlying service.
+type CacheService struct {
+	next Service
+	cfg  CacheConfig
+	now  func() time.Time
+
+	mu         sync.Mutex
+	fact       *CatFact
+	fetchedAt  time.Time
+	refreshing bool
+	inflight   *cacheFetch
+}
+
+// cacheFetch is an upstream call shared by the callers that missed the cache meanwhile.
+type cacheFetch struct {
+	done chan struct{}
+	fact *CatFact
+	err  error
+	// abandoned is set when the caller making the call gave up on it.
+	abandoned bool
+}
+
+// NewCacheService creates a new instance of CacheService with the provided underlying Service.
+func NewCacheService(next Service, cfg CacheConfig) *CacheService {
+	return &CacheService{
+		next: next,
+		cfg:  cfg,
+		now:  time.Now,
+	}
+}
+
+// GetCatFact returns the cached cat fact, refreshing it from the underlying service as needed.
+func (s *CacheService) GetCatFact(ctx context.Context) (*CatFact, error) {
+	if bypass, _ := ctx.Value(cacheBypassKey{}).(bool); bypass {
+		return s.next.GetCatFact(ctx)
+	}
+
+	for {
+		s.mu.Lock()
+		fact, age := s.fact, s.now().Sub(s.fetchedAt)
+		switch {
+		case fact != nil && age < s.cfg.TTL:
+			s.mu.Unlock()
+			setCacheStatus(ctx, CacheHit)
+			return copyFact(fact), nil
+		case fact != nil && age < s.cfg.TTL+s.cfg.StaleWhileRevalidate:
+			if !s.refreshing {
+				s.refreshing = true
+				go s.refresh()
+			}
+			s.mu.Unlock()
+			setCacheStatus(ctx, CacheStale)
+			return copyFact(fact), nil
+		}
+
+		// Another caller is already fetching: wait for its result instead of calling the upstream too.
+		if f := s.inflight; f != nil {
+			s.mu.Unlock()
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if f.abandoned && ctx.Err() == nil {
+				// The caller that fetched gave up, which says nothing about the upstream.
+				continue
+			}
+			return s.result(ctx, f, CacheHit, fact, age)
+		}
+
+		f := &cacheFetch{done: make(chan struct{})}
+		s.inflight = f
+		s.mu.Unlock()
+
+		f.fact, f.err = s.next.GetCatFact(ctx)
+		f.abandoned = f.err != nil && ctx.Err() != nil
+		s.mu.Lock()
+		s.inflight = nil
+		if f.err == nil {
+			s.fact = f.fact
+			s.fetchedAt = s.now()
+		}
+		s.mu.Unlock()
+		close(f.done)
+
+		return s.result(ctx, f, CacheMiss, fact, age)
+	}
+}
+
+// result returns the outcome of the upstream call f, falling back to the stale fact of the
+// given age when the call failed and stale-if-error allows it.
+func (s *CacheService) result(ctx context.Context, f *cacheFetch, status CacheStatus, stale *CatFact, age time.Duration) (*CatFact, error) {
+	if f.err != nil {
+		if stale != nil && age < s.cfg.TTL+s.cfg.StaleIfError {
+			setCacheStatus(ctx, CacheStale)
+			return copyFact(stale), nil
+		}
+		return nil, f.err
+	}
+	setCacheStatus(ctx, status)
+	return copyFact(f.fact), nil
+}
+
+// ListFacts passes the call through to the underlying service.
+func (s *CacheService) ListFacts(ctx context.Context, page, limit, maxLength int) (*FactPage, error) {
+	return s.next.ListFacts(ctx, page, limit, maxLength)
+}
+
+// ListBreeds passes the call through to the underlying service.
+func (s *CacheService) ListBreeds(ctx context.Context, page, limit int) (*BreedPage, error) {
+	return s.next.ListBreeds(ctx, page, limit)
+}
+
+// Flush drops the cached fact so the next call goes to the underlying service.
+func (s *CacheService) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fact = nil
+	s.fetchedAt = time.Time{}
+}
+
+// refresh fetches a new fact in the background. A failed refresh keeps the old fact.
+func (s *CacheService) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.RefreshTimeout)
+	defer cancel()
+
+	fact, err := s.next.GetCatFact(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshing = false
+	if err == nil {
+		s.fact = fact
+		s.fetchedAt = s.now()
+	}
+}
+
+// copyFact returns a copy of fact so callers can't mutate the cached value.
+func copyFact(fact *CatFact) *CatFact {
+	c := *fact
+	return &c
+}
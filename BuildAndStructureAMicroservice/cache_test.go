@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingService answers with a new fact per call, or with err, after waiting for release
+// when it is set.
+type countingService struct {
+	Service
+
+	mu      sync.Mutex
+	calls   int
+	err     error
+	release chan struct{}
+}
+
+func (s *countingService) GetCatFact(ctx context.Context) (*CatFact, error) {
+	s.mu.Lock()
+	s.calls++
+	calls, err, release := s.calls, s.err, s.release
+	s.mu.Unlock()
+
+	if release != nil {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &CatFact{Fact: fmt.Sprintf("fact %d", calls)}, nil
+}
+
+func (s *countingService) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func TestCacheStatuses(t *testing.T) {
+	cfg := CacheConfig{TTL: 10 * time.Second, StaleWhileRevalidate: 0, StaleIfError: time.Minute, RefreshTimeout: time.Second}
+	upstreamErr := errors.New("upstream down")
+
+	tests := []struct {
+		name    string
+		age     time.Duration
+		err     error
+		bypass  bool
+		fact    string
+		status  CacheStatus
+		wantErr bool
+		calls   int
+	}{
+		{name: "fresh", age: time.Second, fact: "fact 0", status: CacheHit, calls: 0},
+		{name: "expired", age: 11 * time.Second, fact: "fact 1", status: CacheMiss, calls: 1},
+		{name: "stale if error", age: 11 * time.Second, err: upstreamErr, fact: "fact 0", status: CacheStale, calls: 1},
+		{name: "too stale", age: 2 * time.Minute, err: upstreamErr, wantErr: true, calls: 1},
+		{name: "bypass", age: time.Second, bypass: true, fact: "fact 1", status: "", calls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &countingService{err: tt.err}
+			cache := NewCacheService(upstream, cfg)
+			now := time.Now()
+			cache.now = func() time.Time { return now }
+			cache.fact, cache.fetchedAt = &CatFact{Fact: "fact 0"}, now.Add(-tt.age)
+
+			ctx, status := withCacheStatus(context.Background())
+			if tt.bypass {
+				ctx = withoutCache(ctx)
+			}
+			fact, err := cache.GetCatFact(ctx)
+			if tt.wantErr {
+				if !errors.Is(err, upstreamErr) {
+					t.Errorf("Expected the upstream error but got %v", err)
+				}
+			} else if err != nil || fact.Fact != tt.fact || *status != tt.status {
+				t.Errorf("Expected %q with status %q but got %+v, %q and %v", tt.fact, tt.status, fact, *status, err)
+			}
+			if calls := upstream.Calls(); calls != tt.calls {
+				t.Errorf("Expected %d upstream calls but got %d", tt.calls, calls)
+			}
+		})
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	upstream := &countingService{}
+	cache := NewCacheService(upstream, DefaultCacheConfig())
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	if fact, err := cache.GetCatFact(context.Background()); err != nil || fact.Fact != "fact 1" {
+		t.Fatalf("Expected the first fact but got %+v and %v", fact, err)
+	}
+
+	// Past the TTL, the stale fact is served while a refresh runs in the background.
+	now = now.Add(DefaultCacheConfig().TTL + time.Second)
+	ctx, status := withCacheStatus(context.Background())
+	if fact, _ := cache.GetCatFact(ctx); fact.Fact != "fact 1" || *status != CacheStale {
+		t.Errorf("Expected the stale fact but got %+v with status %q", fact, *status)
+	}
+	deadline := time.Now().Add(time.Second)
+	for upstream.Calls() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	cache.mu.Lock()
+	refreshed := cache.fact.Fact
+	cache.mu.Unlock()
+	if refreshed != "fact 2" {
+		t.Errorf("Expected the refreshed fact but got %q", refreshed)
+	}
+}
+
+func TestCacheCoalescesMisses(t *testing.T) {
+	upstream := &countingService{release: make(chan struct{})}
+	cache := NewCacheService(upstream, DefaultCacheConfig())
+
+	const callers = 20
+	facts := make(chan string, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fact, err := cache.GetCatFact(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			facts <- fact.Fact
+		}()
+	}
+
+	// Let every caller reach the cache before the upstream answers.
+	deadline := time.Now().Add(time.Second)
+	for upstream.Calls() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(upstream.release)
+	wg.Wait()
+	close(facts)
+
+	if calls := upstream.Calls(); calls != 1 {
+		t.Errorf("Expected a single upstream call but got %d", calls)
+	}
+	for fact := range facts {
+		if fact != "fact 1" {
+			t.Errorf("Expected every caller to get %q but got %q", "fact 1", fact)
+		}
+	}
+}
+
+func TestCacheRetriesAbandonedFetch(t *testing.T) {
+	upstream := &countingService{release: make(chan struct{})}
+	cache := NewCacheService(upstream, DefaultCacheConfig())
+
+	// The first caller gives up while a second one waits for its call.
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cache.GetCatFact(ctx)
+		first <- err
+	}()
+	deadline := time.Now().Add(time.Second)
+	for upstream.Calls() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan *CatFact, 1)
+	go func() {
+		fact, _ := cache.GetCatFact(context.Background())
+		second <- fact
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the first caller to be canceled but got %v", err)
+	}
+
+	close(upstream.release)
+	if fact := <-second; fact == nil || fact.Fact != "fact 2" {
+		t.Errorf("Expected the second caller to fetch its own fact but got %+v", fact)
+	}
+}
@@ -0,0 +1,141 @@
+// Package catfact holds the types shared by the fact service and its clients: the Service
+// interface, the facts and breeds it returns, and the problem documents describing its errors.
+package catfact
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Service is the interface that defines the methods for retrieving cat facts and breeds.
+type Service interface {
+	GetCatFact(context.Context) (*CatFact, error)
+	ListFacts(ctx context.Context, page, limit, maxLength int) (*FactPage, error)
+	ListBreeds(ctx context.Context, page, limit int) (*BreedPage, error)
+}
+
+// CatFact represents a cat fact.
+type CatFact struct {
+	Fact string `json:"fact"`
+
+	// The fields below are derived from Fact by enrichers, when asked for.
+
+	// ID is a stable hash of the content of the fact.
+	ID string `json:"id,omitempty"`
+	// Length is the number of characters of the fact.
+	Length    int      `json:"length,omitempty"`
+	Words     int      `json:"words,omitempty"`
+	Sentences int      `json:"sentences,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// Breed represents a cat breed.
+type Breed struct {
+	Breed   string `json:"breed"`
+	Country string `json:"country"`
+	Origin  string `json:"origin"`
+	Coat    string `json:"coat"`
+	Pattern string `json:"pattern"`
+}
+
+// PageLink is a single entry of the navigation links returned with a page.
+type PageLink struct {
+	URL    string `json:"url"`
+	Label  string `json:"label"`
+	Active bool   `json:"active"`
+}
+
+// Page holds the pagination metadata shared by every paginated listing.
+type Page struct {
+	CurrentPage  int        `json:"current_page"`
+	LastPage     int        `json:"last_page"`
+	PerPage      int        `json:"per_page"`
+	From         int        `json:"from"`
+	To           int        `json:"to"`
+	Total        int        `json:"total"`
+	Path         string     `json:"path"`
+	FirstPageURL string     `json:"first_page_url"`
+	LastPageURL  string     `json:"last_page_url"`
+	NextPageURL  string     `json:"next_page_url"`
+	PrevPageURL  string     `json:"prev_page_url"`
+	Links        []PageLink `json:"links"`
+}
+
+// FactPage is a single page of cat facts.
+type FactPage struct {
+	Page
+	Data []CatFact `json:"data"`
+}
+
+// BreedPage is a single page of cat breeds.
+type BreedPage struct {
+	Page
+	Data []Breed `json:"data"`
+}
+
+// ProblemContentType is the media type of error responses.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem document, the body of every error response.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the ID of the request that failed.
+	Instance string `json:"instance,omitempty"`
+}
+
+// NewProblem returns the problem document for an error with the given status and detail.
+func NewProblem(status int, detail string) Problem {
+	return Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// Error implements the error interface.
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return fmt.Sprintf("%d %s", p.Status, p.Title)
+	}
+	return fmt.Sprintf("%d %s: %s", p.Status, p.Title, p.Detail)
+}
+
+// RequestIDHeader carries the ID of a request from client to server and back.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random request ID, falling back to one made from the clock when the
+// system has no randomness to give.
+func NewRequestID() string {
+	id, err := RandomHex(8)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return id
+}
+
+// RandomHex returns n random bytes, hex-encoded.
+func RandomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrChaos is the error injected by ChaosService.
+var ErrChaos = errors.New("chaos: injected failure")
+
+// ChaosConfig holds the settings of a ChaosService. Probabilities range from 0 to 1.
+type ChaosConfig struct {
+	Enabled bool `json:"enabled"`
+	// LatencyProbability is the chance of delaying a call by up to MaxLatency.
+	LatencyProbability float64       `json:"latency_probability"`
+	MaxLatency         time.Duration `json:"max_latency"`
+	// ErrorProbability is the chance of failing a call with ErrChaos.
+	ErrorProbability float64 `json:"error_probability"`
+	// EmptyProbability is the chance of answering with an empty fact or page.
+	EmptyProbability float64 `json:"empty_probability"`
+	// Seed seeds the random source so failure sequences can be reproduced.
+	Seed int64 `json:"seed"`
+}
+
+// DefaultChaosConfig returns chaos settings that inject nothing until enabled.
+func DefaultChaosConfig() ChaosConfig {
+	return ChaosConfig{
+		MaxLatency: time.Second,
+		Seed:       1,
+	}
+}
+
+// validate checks that the probabilities and latency make sense.
+func (c ChaosConfig) validate() error {
+	for name, p := range map[string]float64{
+		"latency_probability": c.LatencyProbability,
+		"error_probability":   c.ErrorProbability,
+		"empty_probability":   c.EmptyProbability,
+	} {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("%s must be between 0 and 1, got %v", name, p)
+		}
+	}
+	if c.MaxLatency < 0 {
+		return fmt.Errorf("max_latency must not be negative, got %v", c.MaxLatency)
+	}
+	return nil
+}
+
+// ChaosService is a service wrapper that injects latency, errors and empty results so
+// consumers can be tested against a misbehaving fact service.
+type ChaosService struct {
+	next Service
+
+	mu  sync.Mutex
+	cfg ChaosConfig
+	rng *rand.Rand
+}
+
+// NewChaosService creates a new instance of ChaosService with the provided underlying Service.
+// It returns an error when the probabilities or latency of cfg make no sense.
+func NewChaosService(next Service, cfg ChaosConfig) (*ChaosService, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &ChaosService{
+		next: next,
+		cfg:  cfg,
+		rng:  rand.New(rand.NewSource(cfg.Seed)),
+	}, nil
+}
+
+// Config returns the current chaos settings.
+func (s *ChaosService) Config() ChaosConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cfg
+}
+
+// SetConfig replaces the chaos settings and reseeds the random source,
+// so the same config always produces the same sequence of faults.
+func (s *ChaosService) SetConfig(cfg ChaosConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cfg = cfg
+	s.rng = rand.New(rand.NewSource(cfg.Seed))
+	return nil
+}
+
+// chaos describes the faults drawn for a single call.
+type chaos struct {
+	delay time.Duration
+	fail  bool
+	empty bool
+}
+
+// draw picks the faults for the next call.
+func (s *ChaosService) draw() chaos {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var c chaos
+	if !s.cfg.Enabled {
+		return c
+	}
+	// Always draw every number so one setting doesn't shift the sequence of the others.
+	latency, delay, fail, empty := s.rng.Float64(), s.rng.Float64(), s.rng.Float64(), s.rng.Float64()
+	if latency < s.cfg.LatencyProbability {
+		c.delay = time.Duration(delay * float64(s.cfg.MaxLatency))
+	}
+	c.fail = fail < s.cfg.ErrorProbability
+	c.empty = empty < s.cfg.EmptyProbability
+	return c
+}
+
+// inject waits out the drawn delay and returns the drawn error, if any.
+func (c chaos) inject(ctx context.Context) error {
+	if c.delay > 0 {
+		timer := time.NewTimer(c.delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if c.fail {
+		return ErrChaos
+	}
+	return nil
+}
+
+// GetCatFact retrieves a cat fact, possibly injecting faults.
+func (s *ChaosService) GetCatFact(ctx context.Context) (*CatFact, error) {
+	c := s.draw()
+	if err := c.inject(ctx); err != nil {
+		return nil, err
+	}
+	if c.empty {
+		return &CatFact{}, nil
+	}
+	return s.next.GetCatFact(ctx)
+}
+
+// ListFacts retrieves a page of cat facts, possibly injecting faults.
+func (s *ChaosService) ListFacts(ctx context.Context, page, limit, maxLength int) (*FactPage, error) {
+	c := s.draw()
+	if err := c.inject(ctx); err != nil {
+		return nil, err
+	}
+	if c.empty {
+		return &FactPage{Page: Page{CurrentPage: page, LastPage: page}, Data: []CatFact{}}, nil
+	}
+	return s.next.ListFacts(ctx, page, limit, maxLength)
+}
+
+// ListBreeds retrieves a page of cat breeds, possibly injecting faults.
+func (s *ChaosService) ListBreeds(ctx context.Context, page, limit int) (*BreedPage, error) {
+	c := s.draw()
+	if err := c.inject(ctx); err != nil {
+		return nil, err
+	}
+	if c.empty {
+		return &BreedPage{Page: Page{CurrentPage: page, LastPage: page}, Data: []Breed{}}, nil
+	}
+	return s.next.ListBreeds(ctx, page, limit)
+}
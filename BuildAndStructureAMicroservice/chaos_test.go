@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// outcomes returns how each of n calls to svc ended: "ok", "empty" or "error".
+func outcomes(svc Service, n int) []string {
+	var out []string
+	for i := 0; i < n; i++ {
+		fact, err := svc.GetCatFact(context.Background())
+		switch {
+		case err != nil:
+			out = append(out, "error")
+		case fact.Fact == "":
+			out = append(out, "empty")
+		default:
+			out = append(out, "ok")
+		}
+	}
+	return out
+}
+
+func TestChaosServiceIsReproducible(t *testing.T) {
+	cfg := ChaosConfig{Enabled: true, ErrorProbability: 0.3, EmptyProbability: 0.3, Seed: 42}
+	chaos, err := NewChaosService(&staticService{fact: "Cats purr."}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := outcomes(chaos, 50)
+	if err := chaos.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	second := outcomes(chaos, 50)
+
+	if strings.Join(first, ",") != strings.Join(second, ",") {
+		t.Errorf("Expected the same sequence for the same seed but got\n%v\n%v", first, second)
+	}
+	if !strings.Contains(strings.Join(first, ","), "error") {
+		t.Error("Expected some injected errors but got none")
+	}
+}
+
+func TestAdminServerChaos(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	stack, err := BuildStack(&staticService{fact: "Cats purr."}, []DecoratorConfig{{Name: "chaos"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := httptest.NewServer(NewAdminServer(cfg, stack, nil, nil, nil).Handler())
+	defer admin.Close()
+	server := httptest.NewServer(NewApiServer(stack).Handler())
+	defer server.Close()
+
+	update := func(token, body string) *http.Response {
+		request, err := http.NewRequest(http.MethodPut, admin.URL+"/chaos", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	response := update("wrong", `{"enabled": true}`)
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 but got %d", response.StatusCode)
+	}
+
+	response = update("secret", `{"enabled": true, "error_probability": 1, "max_latency": "10ms"}`)
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 but got %d", response.StatusCode)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["max_latency"] != "10ms" {
+		t.Errorf("Expected max_latency 10ms but got %v", body["max_latency"])
+	}
+
+	// Every call now fails with 503.
+	fact, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fact.Body.Close()
+	if fact.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 but got %d", fact.StatusCode)
+	}
+}
+
+func TestChaosConfigValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		ok      bool
+	}{
+		{"default", `{}`, true},
+		{"probabilities in range", `{"enabled": true, "error_probability": 1, "latency_probability": 0.5}`, true},
+		{"probability above 1", `{"error_probability": 1.5}`, false},
+		{"negative probability", `{"empty_probability": -0.1}`, false},
+		{"negative latency", `{"max_latency": "-1s"}`, false},
+	}
+
+	for _, tt := range tests {
+		_, err := BuildStack(&staticService{fact: "Cats purr."}, []DecoratorConfig{{Name: "chaos", Options: json.RawMessage(tt.options)}})
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Expected ok %v but got %v", tt.name, tt.ok, err)
+		}
+	}
+	if _, err := NewChaosService(&staticService{}, ChaosConfig{ErrorProbability: 2}); err == nil {
+		t.Error("Expected NewChaosService to reject a probability of 2")
+	}
+}
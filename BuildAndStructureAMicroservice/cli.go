@@ -0,0 +1,499 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"BuildAndStructureAMicroservice/client"
+)
+
+// Exit codes of the fact binary, so scripts can tell failures apart.
+const (
+	exitOK = 0
+	// exitFailure is any failure not covered below.
+	exitFailure = 1
+	// exitUsage is an unknown subcommand or invalid flags.
+	exitUsage = 2
+	// exitConfig is a config file that can't be read or is invalid.
+	exitConfig = 3
+	// exitNetwork is a failure to reach the upstream API or fact server, or a bad answer from it.
+	exitNetwork = 4
+)
+
+// version is the version of the binary, set at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// command is a subcommand of the fact binary.
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(cmd *command, args []string, stdout, stderr io.Writer) int
+	// flags declares the flags of the command on fs.
+	flags func(fs *flag.FlagSet) interface{}
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "serve", summary: "Run the fact service.", flags: serveFlags, run: runServe},
+		{name: "get", summary: "Fetch a single fact and print it.", flags: getFlags, run: runGet},
+		{name: "bulk", summary: "Fetch several facts and print them.", flags: bulkFlags, run: runBulk},
+		{name: "config check", args: "[path]", summary: "Validate a config file and print the resulting service stack.", flags: configCheckFlags, run: runConfigCheck},
+		{name: "version", summary: "Print the version of the binary.", flags: versionFlags, run: runVersion},
+	}
+}
+
+// run runs the fact binary with args, not including the program name, and returns its exit code.
+// Without a subcommand the binary serves, as it always did, so "fact -config fact.json" still works.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && !isHelp(args[0])) {
+		args = append([]string{"serve"}, args...)
+	}
+	if isHelp(args[0]) {
+		printUsage(stdout)
+		return exitOK
+	}
+
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd.run(cmd, args[len(words):], stdout, stderr)
+		}
+	}
+
+	fmt.Fprintf(stderr, "fact: unknown command %q\n\n", strings.Join(args, " "))
+	printUsage(stderr)
+	return exitUsage
+}
+
+// isHelp reports whether arg asks for the list of commands.
+func isHelp(arg string) bool {
+	switch arg {
+	case "help", "-h", "-help", "--help":
+		return true
+	}
+	return false
+}
+
+// printUsage lists the subcommands.
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: fact <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "fact <command> -h" for the flags of a command.`)
+}
+
+// parse parses the flags of cmd. It returns ok false, and the exit code to use, when the
+// command shouldn't run.
+func (cmd *command) parse(args []string, stderr io.Writer) (opts interface{}, fs *flag.FlagSet, code int, ok bool) {
+	fs = flag.NewFlagSet("fact "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts = cmd.flags(fs)
+	fs.Usage = func() {
+		usage := strings.TrimSpace(fmt.Sprintf("fact %s [flags] %s", cmd.name, cmd.args))
+		fmt.Fprintf(fs.Output(), "usage: %s\n\n%s\n\nflags:\n", usage, cmd.summary)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, nil, exitOK, false
+		}
+		return nil, nil, exitUsage, false
+	}
+	if cmd.args == "" && fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "fact %s: unexpected arguments %q\n", cmd.name, fs.Args())
+		fs.Usage()
+		return nil, nil, exitUsage, false
+	}
+	return opts, fs, exitOK, true
+}
+
+// loadConfig loads the config at path, reporting failures on stderr.
+func loadConfig(name, path string, stderr io.Writer) (Config, int, bool) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "fact %s: %v\n", name, err)
+		return cfg, exitConfig, false
+	}
+	return cfg, exitOK, true
+}
+
+type serveOptions struct {
+	config string
+}
+
+func serveFlags(fs *flag.FlagSet) interface{} {
+	opts := &serveOptions{}
+	fs.StringVar(&opts.config, "config", "", "path to a JSON config file")
+	return opts
+}
+
+func runServe(cmd *command, args []string, stdout, stderr io.Writer) int {
+	o, _, code, ok := cmd.parse(args, stderr)
+	if !ok {
+		return code
+	}
+	opts := o.(*serveOptions)
+
+	cfg, code, ok := loadConfig(cmd.name, opts.config, stderr)
+	if !ok {
+		return code
+	}
+
+	// Stop gracefully on Ctrl+C or when the process manager asks us to.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := serve(ctx, cfg); err != nil {
+		fmt.Fprintf(stderr, "fact serve: %v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// fetchOptions are the flags shared by get and bulk.
+type fetchOptions struct {
+	config  string
+	server  string
+	format  string
+	timeout time.Duration
+}
+
+func (o *fetchOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.config, "config", "", "path to a JSON config file describing the upstream and decorators")
+	fs.StringVar(&o.server, "server", "", "fetch from a running fact server at this URL instead of the upstream")
+	fs.StringVar(&o.format, "format", "text", "output format: text or json")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "give up after this long")
+}
+
+// service returns the Service to fetch from: a remote fact server, or the configured stack.
+func (o *fetchOptions) service(name string, stderr io.Writer) (Service, int, bool) {
+	if o.format != "text" && o.format != "json" {
+		fmt.Fprintf(stderr, "fact %s: unknown format %q\n", name, o.format)
+		return nil, exitUsage, false
+	}
+
+	if o.server != "" {
+		c, err := client.New(o.server, client.WithTimeout(o.timeout))
+		if err != nil {
+			fmt.Fprintf(stderr, "fact %s: %v\n", name, err)
+			return nil, exitUsage, false
+		}
+		return c, exitOK, true
+	}
+
+	cfg, code, ok := loadConfig(name, o.config, stderr)
+	if !ok {
+		return nil, code, false
+	}
+	stack, err := buildStack(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "fact %s: %v\n", name, err)
+		return nil, exitConfig, false
+	}
+	return stack, exitOK, true
+}
+
+func getFlags(fs *flag.FlagSet) interface{} {
+	opts := &fetchOptions{}
+	opts.register(fs)
+	return opts
+}
+
+func runGet(cmd *command, args []string, stdout, stderr io.Writer) int {
+	o, _, code, ok := cmd.parse(args, stderr)
+	if !ok {
+		return code
+	}
+	opts := o.(*fetchOptions)
+
+	// Keep stdout for the fact itself.
+	defer SetLogOutput(SetLogOutput(stderr))
+
+	svc, code, ok := opts.service(cmd.name, stderr)
+	if !ok {
+		return code
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+
+	fact, err := svc.GetCatFact(ctx)
+	if err != nil {
+		fmt.Fprintf(stderr, "fact get: %v\n", err)
+		return fetchExitCode(err)
+	}
+
+	if opts.format == "json" {
+		json.NewEncoder(stdout).Encode(fact)
+	} else {
+		fmt.Fprintln(stdout, fact.Fact)
+	}
+	return exitOK
+}
+
+type bulkOptions struct {
+	fetchOptions
+	n        int
+	parallel int
+}
+
+func bulkFlags(fs *flag.FlagSet) interface{} {
+	opts := &bulkOptions{}
+	opts.register(fs)
+	fs.IntVar(&opts.n, "n", 10, "number of facts to fetch")
+	fs.IntVar(&opts.parallel, "parallel", 4, "number of facts fetched at a time")
+	return opts
+}
+
+func runBulk(cmd *command, args []string, stdout, stderr io.Writer) int {
+	o, fs, code, ok := cmd.parse(args, stderr)
+	if !ok {
+		return code
+	}
+	opts := o.(*bulkOptions)
+	if opts.n < 1 || opts.parallel < 1 {
+		fmt.Fprintln(stderr, "fact bulk: -n and -parallel must be at least 1")
+		fs.Usage()
+		return exitUsage
+	}
+
+	// Keep stdout for the facts themselves.
+	defer SetLogOutput(SetLogOutput(stderr))
+
+	svc, code, ok := opts.service(cmd.name, stderr)
+	if !ok {
+		return code
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+
+	var (
+		facts = make([]*CatFact, opts.n)
+		errs  = make([]error, opts.n)
+		next  = make(chan int)
+		wg    sync.WaitGroup
+	)
+	for w := 0; w < opts.parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				facts[i], errs[i] = svc.GetCatFact(ctx)
+			}
+		}()
+	}
+	for i := 0; i < opts.n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	// Print what we got, then fail on the first error, if any.
+	var got []*CatFact
+	for _, fact := range facts {
+		if fact != nil {
+			got = append(got, fact)
+		}
+	}
+	if opts.format == "json" {
+		if got == nil {
+			got = []*CatFact{}
+		}
+		json.NewEncoder(stdout).Encode(got)
+	} else {
+		for _, fact := range got {
+			fmt.Fprintln(stdout, fact.Fact)
+		}
+	}
+
+	if err := firstError(errs); err != nil {
+		fmt.Fprintf(stderr, "fact bulk: %d of %d facts failed: %v\n", opts.n-len(got), opts.n, err)
+		return fetchExitCode(err)
+	}
+	return exitOK
+}
+
+// firstError returns the first non-nil error of errs.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchExitCode returns the exit code for a failure to fetch facts.
+func fetchExitCode(err error) int {
+	var (
+		upstreamErr *UpstreamError
+		remoteErr   *client.Error
+		urlErr      *url.Error
+		netErr      net.Error
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &upstreamErr),
+		errors.As(err, &urlErr),
+		errors.As(err, &netErr),
+		errors.Is(err, ErrEmptyFact),
+		errors.Is(err, ErrBreakerOpen):
+		return exitNetwork
+	case errors.As(err, &remoteErr):
+		if remoteErr.Status >= 500 {
+			return exitNetwork
+		}
+	}
+	return exitFailure
+}
+
+type configCheckOptions struct {
+	config string
+}
+
+func configCheckFlags(fs *flag.FlagSet) interface{} {
+	opts := &configCheckOptions{}
+	fs.StringVar(&opts.config, "config", "", "path to the JSON config file to check")
+	return opts
+}
+
+func runConfigCheck(cmd *command, args []string, stdout, stderr io.Writer) int {
+	o, fs, code, ok := cmd.parse(args, stderr)
+	if !ok {
+		return code
+	}
+	opts := o.(*configCheckOptions)
+
+	path := opts.config
+	switch {
+	case fs.NArg() == 1 && path == "":
+		path = fs.Arg(0)
+	case fs.NArg() > 0:
+		fmt.Fprintf(stderr, "fact config check: unexpected arguments %q\n", fs.Args())
+		fs.Usage()
+		return exitUsage
+	}
+
+	cfg, code, ok := loadConfig(cmd.name, path, stderr)
+	if !ok {
+		return code
+	}
+	stack, err := checkConfig(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "fact config check: %v\n", err)
+		return exitConfig
+	}
+	fmt.Fprintf(stdout, "config OK\nservice stack: %s\n", stack)
+	return exitOK
+}
+
+// checkConfig reports the problems of cfg that loading it doesn't catch. It builds the service
+// stack to do so, and returns it when cfg has no problem.
+func checkConfig(cfg Config) (*Stack, error) {
+	var errs []error
+	if _, err := url.Parse(cfg.UpstreamURL); err != nil || cfg.UpstreamURL == "" {
+		errs = append(errs, fmt.Errorf("invalid upstream_url %q", cfg.UpstreamURL))
+	}
+	stack, err := buildStack(cfg)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.TLS.Enabled() {
+		if _, err := NewTLSReloader(cfg.TLS); err != nil {
+			errs = append(errs, fmt.Errorf("tls: %w", err))
+		}
+	}
+	if err := cfg.AccessLog.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("access log: %w", err))
+	}
+	if _, err := newRecorder(cfg.VCR); err != nil {
+		errs = append(errs, fmt.Errorf("vcr: %w", err))
+	}
+	if cfg.PrefetchEnabled {
+		if err := cfg.Prefetch.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("prefetch: %w", err))
+		}
+	}
+	if cfg.TracingEnabled {
+		if _, err := NewTracer(cfg.Tracing); err != nil {
+			errs = append(errs, fmt.Errorf("tracing: %w", err))
+		}
+	}
+	if cfg.WebhooksEnabled {
+		if err := cfg.Webhooks.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("webhooks: %w", err))
+		}
+	}
+	if cfg.SchedulerEnabled {
+		// This checks the jobs and reads the store, but starts nothing.
+		if _, err := NewScheduler(stack, cfg.Scheduler); err != nil {
+			errs = append(errs, fmt.Errorf("scheduler: %w", err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return stack, nil
+}
+
+type versionOptions struct {
+	json bool
+}
+
+func versionFlags(fs *flag.FlagSet) interface{} {
+	opts := &versionOptions{}
+	fs.BoolVar(&opts.json, "json", false, "print the version as JSON")
+	return opts
+}
+
+func runVersion(cmd *command, args []string, stdout, stderr io.Writer) int {
+	o, _, code, ok := cmd.parse(args, stderr)
+	if !ok {
+		return code
+	}
+	opts := o.(*versionOptions)
+
+	info := map[string]string{"version": version, "go_version": runtime.Version()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" {
+				info["commit"] = setting.Value
+			}
+		}
+	}
+
+	if opts.json {
+		json.NewEncoder(stdout).Encode(info)
+		return exitOK
+	}
+	fmt.Fprintf(stdout, "fact %s (%s", info["version"], info["go_version"])
+	if commit := info["commit"]; commit != "" {
+		fmt.Fprintf(stdout, ", commit %s", commit)
+	}
+	fmt.Fprintln(stdout, ")")
+	return exitOK
+}
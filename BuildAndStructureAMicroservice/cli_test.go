@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"BuildAndStructureAMicroservice/fakeupstream"
+)
+
+// writeTestConfig writes a config pointing at upstream and returns its path.
+func writeTestConfig(t *testing.T, upstream *fakeupstream.Server) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	raw := fmt.Sprintf(`{"upstream_url": %q, "decorators": [{"name": "logging"}]}`, upstream.URL)
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runCLI runs the fact binary with args and returns its exit code and output.
+func runCLI(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLIGetAndBulk(t *testing.T) {
+	upstream := fakeupstream.New(fakeupstream.WithFacts("Cats purr."))
+	defer upstream.Close()
+	config := writeTestConfig(t, upstream)
+
+	code, stdout, _ := runCLI("get", "-config", config)
+	if code != exitOK || stdout != "Cats purr.\n" {
+		t.Errorf("Expected exit 0 and the fact but got %d and %q", code, stdout)
+	}
+
+	code, stdout, _ = runCLI("get", "-config", config, "-format", "json")
+	var fact CatFact
+	if err := json.Unmarshal([]byte(stdout), &fact); code != exitOK || err != nil || fact.Fact != "Cats purr." {
+		t.Errorf("Expected exit 0 and a JSON fact but got %d and %q", code, stdout)
+	}
+
+	code, stdout, _ = runCLI("bulk", "-config", config, "-n", "3")
+	if lines := strings.Count(stdout, "\n"); code != exitOK || lines != 3 {
+		t.Errorf("Expected exit 0 and 3 facts but got %d and %d", code, lines)
+	}
+}
+
+func TestCLIExitCodes(t *testing.T) {
+	upstream := fakeupstream.New()
+	defer upstream.Close()
+	upstream.SetFault(fakeupstream.PathFact, fakeupstream.Fault{Status: http.StatusInternalServerError})
+	config := writeTestConfig(t, upstream)
+
+	invalid := filepath.Join(t.TempDir(), "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"decorators": [{"name": "nope"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	missingCassette := filepath.Join(t.TempDir(), "vcr.json")
+	raw := fmt.Sprintf(`{"vcr": {"cassette": %q, "mode": "replay"}}`, filepath.Join(t.TempDir(), "missing-cassette.json"))
+	if err := os.WriteFile(missingCassette, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"version", []string{"version"}, exitOK},
+		{"help", []string{"help"}, exitOK},
+		{"command help", []string{"get", "-h"}, exitOK},
+		{"config check", []string{"config", "check", config}, exitOK},
+		{"unknown command", []string{"fetch"}, exitUsage},
+		{"unknown flag", []string{"get", "-nope"}, exitUsage},
+		{"invalid format", []string{"get", "-config", config, "-format", "xml"}, exitUsage},
+		{"missing config", []string{"config", "check", filepath.Join(t.TempDir(), "missing.json")}, exitConfig},
+		{"invalid config", []string{"config", "check", invalid}, exitConfig},
+		{"missing cassette", []string{"config", "check", missingCassette}, exitConfig},
+		{"upstream failure", []string{"get", "-config", config}, exitNetwork},
+		{"unreachable server", []string{"get", "-server", "http://127.0.0.1:1"}, exitNetwork},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, _, stderr := runCLI(tt.args...); code != tt.want {
+				t.Errorf("Expected exit %d but got %d (%s)", tt.want, code, stderr)
+			}
+		})
+	}
+}
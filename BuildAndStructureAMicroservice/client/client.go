@@ -0,0 +1,257 @@
+// Package client is a Go client for the fact API served by ApiServer. A *Client implements
+// catfact.Service, so a remote instance can be dropped in anywhere a local Service is expected.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"BuildAndStructureAMicroservice/catfact"
+)
+
+// Errors matched by the *Error values a Client returns, following the status of the response.
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrNotFound     = errors.New("not found")
+	ErrUpstream     = errors.New("upstream failure")
+	ErrUnavailable  = errors.New("service unavailable")
+	ErrTimeout      = errors.New("timeout")
+)
+
+// statusErrors maps response statuses to the errors above.
+var statusErrors = map[int]error{
+	http.StatusBadRequest:         ErrBadRequest,
+	http.StatusUnauthorized:       ErrUnauthorized,
+	http.StatusNotFound:           ErrNotFound,
+	http.StatusBadGateway:         ErrUpstream,
+	http.StatusServiceUnavailable: ErrUnavailable,
+	http.StatusGatewayTimeout:     ErrTimeout,
+}
+
+// Error is returned when the API answers with an error status. It carries the problem
+// document of the response and matches one of the Err variables with errors.Is.
+type Error struct {
+	catfact.Problem
+	// RequestID is the ID the server handled the request under.
+	RequestID string
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Problem.Error()
+}
+
+// Is reports whether target is the error matching the status of e.
+func (e *Error) Is(target error) bool {
+	return statusErrors[e.Status] == target && target != nil
+}
+
+// A remote instance can be used wherever a local Service is expected.
+var _ catfact.Service = (*Client)(nil)
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used to talk to the API.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// WithTimeout bounds every attempt of a call. Zero means no limit besides the context.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithRetries retries calls failing with a network error or a 502, 503 or 504 up to n more
+// times, waiting backoff before the first retry and doubling it after each one.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = n
+		c.backoff = backoff
+	}
+}
+
+// WithToken sends token as a bearer token with every request.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// Client calls a remote fact API.
+type Client struct {
+	baseURL *url.URL
+	http    *http.Client
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+	token   string
+}
+
+// New creates a new Client for the API served at baseURL, such as http://localhost:3000.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	c := &Client{
+		baseURL: u,
+		http:    http.DefaultClient,
+		timeout: 10 * time.Second,
+		backoff: 100 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// GetCatFact fetches a random cat fact.
+func (c *Client) GetCatFact(ctx context.Context) (*catfact.CatFact, error) {
+	fact := &catfact.CatFact{}
+	if err := c.get(ctx, "/", nil, fact); err != nil {
+		return nil, err
+	}
+	return fact, nil
+}
+
+// ListFacts fetches a page of cat facts. Zero values leave the defaults of the server.
+func (c *Client) ListFacts(ctx context.Context, page, limit, maxLength int) (*catfact.FactPage, error) {
+	query := pageQuery(page, limit)
+	if maxLength > 0 {
+		query.Set("max_length", strconv.Itoa(maxLength))
+	}
+
+	facts := &catfact.FactPage{}
+	if err := c.get(ctx, "/facts", query, facts); err != nil {
+		return nil, err
+	}
+	return facts, nil
+}
+
+// ListBreeds fetches a page of cat breeds. Zero values leave the defaults of the server.
+func (c *Client) ListBreeds(ctx context.Context, page, limit int) (*catfact.BreedPage, error) {
+	breeds := &catfact.BreedPage{}
+	if err := c.get(ctx, "/breeds", pageQuery(page, limit), breeds); err != nil {
+		return nil, err
+	}
+	return breeds, nil
+}
+
+// pageQuery returns the pagination query parameters, leaving out zero values.
+func pageQuery(page, limit int) url.Values {
+	query := url.Values{}
+	if page > 0 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	return query
+}
+
+// get calls the API at path and decodes the JSON answer into v, retrying as configured.
+// Every attempt carries the request ID of ctx, or one made up for the call.
+func (c *Client) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	requestID := catfact.RequestIDFromContext(ctx)
+	if requestID == "" {
+		requestID = catfact.NewRequestID()
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := c.attempt(ctx, u.String(), requestID, v)
+		if err == nil || !retry || attempt >= c.retries || ctx.Err() != nil {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// attempt makes a single request and reports whether a failure is worth retrying.
+func (c *Client) attempt(ctx context.Context, rawURL, requestID string, v interface{}) (bool, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json, "+catfact.ProblemContentType)
+	req.Header.Set(catfact.RequestIDHeader, requestID)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		err := decodeError(resp)
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true, err
+		}
+		return false, err
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("decoding response: %w", err)
+	}
+	return false, nil
+}
+
+// decodeError turns an error response into an *Error, making up a problem document when the
+// response doesn't carry one.
+func decodeError(resp *http.Response) error {
+	e := &Error{
+		Problem:   catfact.NewProblem(resp.StatusCode, ""),
+		RequestID: resp.Header.Get(catfact.RequestIDHeader),
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == catfact.ProblemContentType && json.Unmarshal(body, &e.Problem) == nil {
+		e.Status = resp.StatusCode
+		return e
+	}
+	e.Detail = strings.TrimSpace(string(body))
+	return e
+}
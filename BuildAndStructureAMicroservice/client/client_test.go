@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"BuildAndStructureAMicroservice/catfact"
+)
+
+// stubAPI answers like the fact API, failing the first failures calls with status.
+type stubAPI struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	failures int
+	status   int
+}
+
+func (s *stubAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	fail := s.failures > 0
+	if fail {
+		s.failures--
+	}
+	s.mu.Unlock()
+
+	requestID := r.Header.Get(catfact.RequestIDHeader)
+	w.Header().Set(catfact.RequestIDHeader, requestID)
+	if fail {
+		problem := catfact.NewProblem(s.status, "upstream down")
+		problem.Instance = requestID
+		w.Header().Set("Content-Type", catfact.ProblemContentType)
+		w.WriteHeader(s.status)
+		json.NewEncoder(w).Encode(problem)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/":
+		json.NewEncoder(w).Encode(catfact.CatFact{Fact: "Cats purr."})
+	case "/facts":
+		json.NewEncoder(w).Encode(catfact.FactPage{
+			Page: catfact.Page{CurrentPage: 2, PerPage: 5},
+			Data: make([]catfact.CatFact, 5),
+		})
+	default:
+		http.Error(w, "no such page", http.StatusNotFound)
+	}
+}
+
+// Requests returns the requests received so far.
+func (s *stubAPI) Requests() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request(nil), s.requests...)
+}
+
+// newStub starts a server running api.
+func newStub(t *testing.T, api *stubAPI) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientCalls(t *testing.T) {
+	api := &stubAPI{}
+	server := newStub(t, api)
+
+	c, err := New(server.URL+"/", WithToken("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fact, err := c.GetCatFact(context.Background())
+	if err != nil || fact.Fact != "Cats purr." {
+		t.Fatalf("Expected a fact but got %+v and %v", fact, err)
+	}
+	page, err := c.ListFacts(context.Background(), 2, 5, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.CurrentPage != 2 || len(page.Data) != 5 {
+		t.Errorf("Expected page 2 with 5 facts but got page %d with %d", page.CurrentPage, len(page.Data))
+	}
+
+	requests := api.Requests()
+	if query := requests[1].URL.RawQuery; query != "limit=5&max_length=80&page=2" {
+		t.Errorf("Expected the paging parameters but got %q", query)
+	}
+	for _, r := range requests {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("%s: Expected the bearer token but got %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if r.Header.Get(catfact.RequestIDHeader) == "" {
+			t.Errorf("%s: Expected a request ID", r.URL.Path)
+		}
+	}
+}
+
+func TestClientRetriesAndDecodesProblems(t *testing.T) {
+	api := &stubAPI{failures: 1, status: http.StatusBadGateway}
+	server := newStub(t, api)
+
+	c, err := New(server.URL, WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One failure is retried away.
+	if _, err := c.GetCatFact(context.Background()); err != nil {
+		t.Errorf("Expected the retry to succeed but got %v", err)
+	}
+
+	// A lasting failure comes back as a typed error carrying our request ID.
+	api.mu.Lock()
+	api.failures = 10
+	api.mu.Unlock()
+	ctx := catfact.WithRequestID(context.Background(), "req-42")
+	_, err = c.GetCatFact(ctx)
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || !errors.Is(err, ErrUpstream) {
+		t.Fatalf("Expected an upstream *Error but got %v", err)
+	}
+	if apiErr.RequestID != "req-42" || apiErr.Instance != "req-42" || apiErr.Detail != "upstream down" {
+		t.Errorf("Expected request ID req-42 but got %+v", apiErr)
+	}
+	// Two requests for the first call, then three attempts for the second one.
+	if requests := len(api.Requests()); requests != 5 {
+		t.Errorf("Expected 5 requests but got %d", requests)
+	}
+}
+
+func TestClientDoesNotRetryClientErrors(t *testing.T) {
+	api := &stubAPI{}
+	server := newStub(t, api)
+
+	c, err := New(server.URL+"/missing", WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors without a problem document keep the body as the detail.
+	_, err = c.GetCatFact(context.Background())
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || !errors.Is(err, ErrNotFound) || apiErr.Detail != "no such page" {
+		t.Fatalf("Expected a not found *Error but got %v", err)
+	}
+	if requests := len(api.Requests()); requests != 1 {
+		t.Errorf("Expected a single request but got %d", requests)
+	}
+}
+
+func TestNewRejectsBadURL(t *testing.T) {
+	for _, baseURL := range []string{"localhost:3000", "ftp://example.com", "://"} {
+		if _, err := New(baseURL); err == nil {
+			t.Errorf("%s: Expected an error", baseURL)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Command loadgen drives a running fact service at a fixed request rate or a fixed
+// concurrency for a set duration, then reports latency percentiles, errors by status and
+// throughput.
+//
+// To measure the service rather than catfact.ninja, let loadgen serve the fake upstream
+// and point the service at it:
+//
+//	go run ./cmd/loadgen -upstream-addr 127.0.0.1:3100 -url http://localhost:3000 -rate 200 -duration 30s -out run.json
+//
+// with "upstream_url": "http://127.0.0.1:3100" in the config of the service.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"BuildAndStructureAMicroservice/fakeupstream"
+	"BuildAndStructureAMicroservice/stats"
+)
+
+// Result is the outcome of a run, as written by -out.
+type Result struct {
+	Target      string    `json:"target"`
+	Mode        string    `json:"mode"`
+	Rate        float64   `json:"rate,omitempty"`
+	Concurrency int       `json:"concurrency"`
+	StartedAt   time.Time `json:"started_at"`
+	// Duration is how long the run actually took, in seconds.
+	Duration float64 `json:"duration_s"`
+	Requests int     `json:"requests"`
+	// Dropped counts the requests a fixed-rate run couldn't send because every worker was busy.
+	Dropped    int            `json:"dropped"`
+	Throughput float64        `json:"throughput_rps"`
+	Latency    Latency        `json:"latency_ms"`
+	Statuses   map[string]int `json:"statuses"`
+}
+
+// Latency summarises the latencies of a run, in milliseconds.
+type Latency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+	Max  float64 `json:"max"`
+}
+
+// sample is the outcome of a single request.
+type sample struct {
+	latency time.Duration
+	status  string
+}
+
+// recorder collects the samples of a run.
+type recorder struct {
+	mu      sync.Mutex
+	samples []sample
+	dropped int
+}
+
+func (r *recorder) add(s sample) {
+	r.mu.Lock()
+	r.samples = append(r.samples, s)
+	r.mu.Unlock()
+}
+
+func (r *recorder) drop() {
+	r.mu.Lock()
+	r.dropped++
+	r.mu.Unlock()
+}
+
+func main() {
+	var (
+		target       = flag.String("url", "http://localhost:3000", "base URL of the fact service")
+		path         = flag.String("path", "/", "path to request")
+		duration     = flag.Duration("duration", 10*time.Second, "how long to generate load")
+		rate         = flag.Float64("rate", 0, "requests per second; 0 runs at fixed concurrency instead")
+		concurrency  = flag.Int("concurrency", 10, "number of workers, the most requests in flight at a time")
+		timeout      = flag.Duration("timeout", 5*time.Second, "timeout of a single request")
+		out          = flag.String("out", "", "write the result as JSON to this file")
+		upstreamAddr = flag.String("upstream-addr", "", "serve the fake upstream on this address during the run")
+	)
+	flag.Parse()
+
+	if *concurrency < 1 {
+		log.Fatal("concurrency must be at least 1")
+	}
+
+	if *upstreamAddr != "" {
+		ln, err := net.Listen("tcp", *upstreamAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		upstream := fakeupstream.New(fakeupstream.WithListener(ln))
+		defer upstream.Close()
+		fmt.Printf("fake upstream listening on %s\n", upstream.URL)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	client := &http.Client{
+		Timeout: *timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConns:        *concurrency,
+			MaxIdleConnsPerHost: *concurrency,
+		},
+	}
+	url := *target + *path
+
+	rec := &recorder{}
+	started := time.Now()
+	if *rate > 0 {
+		runRate(ctx, client, url, *rate, *concurrency, rec)
+	} else {
+		runConcurrency(ctx, client, url, *concurrency, rec)
+	}
+	elapsed := time.Since(started)
+
+	result := summarise(rec, elapsed)
+	result.Target = url
+	result.Concurrency = *concurrency
+	result.StartedAt = started.UTC()
+	result.Mode = "concurrency"
+	if *rate > 0 {
+		result.Mode = "rate"
+		result.Rate = *rate
+	}
+
+	printResult(os.Stdout, result)
+	if *out != "" {
+		raw, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*out, append(raw, '\n'), 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// runConcurrency keeps workers busy sending requests back to back until ctx is done.
+func runConcurrency(ctx context.Context, client *http.Client, url string, workers int, rec *recorder) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				rec.add(send(ctx, client, url))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// runRate sends requests at a fixed rate until ctx is done, whether or not earlier requests
+// have been answered, with at most workers requests in flight.
+func runRate(ctx context.Context, client *http.Client, url string, rate float64, workers int, rec *recorder) {
+	slots := make(chan struct{}, workers)
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		select {
+		case slots <- struct{}{}:
+		default:
+			rec.drop()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			rec.add(send(ctx, client, url))
+		}()
+	}
+}
+
+// send makes a single request and records its latency and status. Requests cut short by
+// the end of the run are not counted as errors.
+func send(ctx context.Context, client *http.Client, url string) sample {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return sample{status: "error"}
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return sample{status: "cancelled"}
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return sample{latency: time.Since(start), status: "timeout"}
+		}
+		return sample{latency: time.Since(start), status: "error"}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return sample{latency: time.Since(start), status: strconv.Itoa(resp.StatusCode)}
+}
+
+// summarise computes the result of a run from its samples.
+func summarise(rec *recorder, elapsed time.Duration) Result {
+	result := Result{
+		Duration: elapsed.Seconds(),
+		Dropped:  rec.dropped,
+		Statuses: make(map[string]int),
+	}
+
+	var latencies []time.Duration
+	for _, s := range rec.samples {
+		if s.status == "cancelled" {
+			continue
+		}
+		result.Statuses[s.status]++
+		latencies = append(latencies, s.latency)
+	}
+	result.Requests = len(latencies)
+	if len(latencies) == 0 {
+		return result
+	}
+	result.Throughput = float64(len(latencies)) / elapsed.Seconds()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	result.Latency = Latency{
+		Min:  ms(latencies[0]),
+		Mean: ms(total / time.Duration(len(latencies))),
+		P50:  ms(stats.Percentile(latencies, 0.50)),
+		P90:  ms(stats.Percentile(latencies, 0.90)),
+		P99:  ms(stats.Percentile(latencies, 0.99)),
+		P999: ms(stats.Percentile(latencies, 0.999)),
+		Max:  ms(latencies[len(latencies)-1]),
+	}
+	return result
+}
+
+// ms converts d to fractional milliseconds.
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// printResult writes a human readable report of result to w.
+func printResult(w io.Writer, result Result) {
+	fmt.Fprintf(w, "target:      %s (%s mode)\n", result.Target, result.Mode)
+	fmt.Fprintf(w, "requests:    %d in %.2fs, %.1f req/s", result.Requests, result.Duration, result.Throughput)
+	if result.Dropped > 0 {
+		fmt.Fprintf(w, ", %d dropped", result.Dropped)
+	}
+	fmt.Fprintln(w)
+
+	l := result.Latency
+	fmt.Fprintf(w, "latency ms:  min=%.2f mean=%.2f p50=%.2f p90=%.2f p99=%.2f p999=%.2f max=%.2f\n",
+		l.Min, l.Mean, l.P50, l.P90, l.P99, l.P999, l.Max)
+
+	statuses := make([]string, 0, len(result.Statuses))
+	for status := range result.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(w, "status %-6s %d\n", status+":", result.Statuses[status])
+	}
+}
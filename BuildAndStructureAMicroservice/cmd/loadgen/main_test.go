@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummarise(t *testing.T) {
+	rec := &recorder{dropped: 2}
+	for _, s := range []sample{
+		{latency: 30 * time.Millisecond, status: "200"},
+		{latency: 10 * time.Millisecond, status: "200"},
+		{latency: 20 * time.Millisecond, status: "503"},
+		{latency: 40 * time.Millisecond, status: "timeout"},
+		{status: "cancelled"},
+	} {
+		rec.add(s)
+	}
+
+	result := summarise(rec, 2*time.Second)
+
+	// Cancelled requests are left out of the counts and latencies.
+	if result.Requests != 4 || result.Dropped != 2 || result.Throughput != 2 {
+		t.Errorf("Expected 4 requests, 2 dropped and 2 req/s but got %+v", result)
+	}
+	if result.Statuses["200"] != 2 || result.Statuses["503"] != 1 || result.Statuses["timeout"] != 1 || len(result.Statuses) != 3 {
+		t.Errorf("Expected the statuses to be counted but got %v", result.Statuses)
+	}
+	expected := Latency{Min: 10, Mean: 25, P50: 20, P90: 40, P99: 40, P999: 40, Max: 40}
+	if result.Latency != expected {
+		t.Errorf("Expected %+v but got %+v", expected, result.Latency)
+	}
+}
+
+func TestSummariseWithoutSamples(t *testing.T) {
+	result := summarise(&recorder{samples: []sample{{status: "cancelled"}}}, time.Second)
+	if result.Requests != 0 || result.Throughput != 0 || result.Latency != (Latency{}) {
+		t.Errorf("Expected an empty result but got %+v", result)
+	}
+}
+
+func TestPrintResult(t *testing.T) {
+	var out bytes.Buffer
+	printResult(&out, Result{
+		Target:     "http://localhost:3000/",
+		Mode:       "rate",
+		Requests:   4,
+		Duration:   2,
+		Throughput: 2,
+		Dropped:    1,
+		Latency:    Latency{Min: 10, Mean: 25, P50: 20, P90: 40, P99: 40, P999: 40, Max: 40},
+		Statuses:   map[string]int{"503": 1, "200": 3},
+	})
+
+	for _, line := range []string{
+		"target:      http://localhost:3000/ (rate mode)\n",
+		"requests:    4 in 2.00s, 2.0 req/s, 1 dropped\n",
+		"latency ms:  min=10.00 mean=25.00 p50=20.00 p90=40.00 p99=40.00 p999=40.00 max=40.00\n",
+		"status 200:   3\nstatus 503:   1\n",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected the report to contain %q but got\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Config holds everything needed to wire up the fact service.
+// Durations are written as Go duration strings such as "5s" or "1m30s".
+type Config struct {
+	ListenAddr     string        `json:"listen_addr"`
+	UpstreamURL    string        `json:"upstream_url"`
+	RequestTimeout time.Duration `json:"request_timeout"`
+	// AdminAddr is where the admin API listens. Leave it empty to disable the admin API.
+	AdminAddr string `json:"admin_addr"`
+	// RPCAddr is where JSON-RPC is served over HTTP, at /rpc. Leave it empty to disable it.
+	RPCAddr string `json:"rpc_addr"`
+	// RPCTCPAddr is where JSON-RPC is served over raw TCP. Leave it empty to disable it.
+	RPCTCPAddr string `json:"rpc_tcp_addr"`
+	// AdminToken protects the admin routes. The FACT_ADMIN_TOKEN environment variable overrides it.
+	AdminToken string `json:"admin_token"`
+	// AdminTokenFile is where the token made up for the run is written when no AdminToken is set.
+	AdminTokenFile string `json:"admin_token_file"`
+	// TLS turns HTTPS, and optionally client certificate verification, on for the API server.
+	TLS        TLSConfig        `json:"tls"`
+	AccessLog  AccessLogConfig  `json:"access_log"`
+	HTTPClient HTTPClientConfig `json:"http_client"`
+	// VCR records the upstream traffic to a cassette, or replays it instead of calling the upstream.
+	VCR      VCRConfig      `json:"vcr"`
+	Prefetch PrefetchConfig `json:"prefetch"`
+	// PrefetchEnabled turns the background prefetch pool on.
+	PrefetchEnabled bool          `json:"prefetch_enabled"`
+	Webhooks        WebhookConfig `json:"webhooks"`
+	// WebhooksEnabled turns the webhook subscription routes and delivery worker on.
+	WebhooksEnabled bool            `json:"webhooks_enabled"`
+	Scheduler       SchedulerConfig `json:"scheduler"`
+	// SchedulerEnabled turns the scheduled fact jobs and the /v1/fact routes on.
+	SchedulerEnabled bool          `json:"scheduler_enabled"`
+	Tracing          TracingConfig `json:"tracing"`
+	// TracingEnabled traces requests through the decorators and the upstream call.
+	TracingEnabled bool            `json:"tracing_enabled"`
+	Dashboard      DashboardConfig `json:"dashboard"`
+	// DashboardEnabled serves the status dashboard at /dashboard.
+	DashboardEnabled bool `json:"dashboard_enabled"`
+	// Decorators lists the decorators wrapped around the upstream service, outermost first.
+	Decorators []DecoratorConfig `json:"decorators"`
+}
+
+// DecoratorConfig selects a registered decorator by name and carries its options.
+type DecoratorConfig struct {
+	Name    string          `json:"name"`
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+// DefaultConfig returns the configuration used when no config file is given.
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:      ":3000",
+		UpstreamURL:     "https://catfact.ninja",
+		RequestTimeout:  15 * time.Second,
+		AdminAddr:       "127.0.0.1:3001",
+		AdminTokenFile:  filepath.Join(os.TempDir(), "fact-admin-token"),
+		TLS:             DefaultTLSConfig(),
+		AccessLog:       DefaultAccessLogConfig(),
+		HTTPClient:      DefaultHTTPClientConfig(),
+		VCR:             DefaultVCRConfig(),
+		Prefetch:        DefaultPrefetchConfig(),
+		PrefetchEnabled: true,
+		Webhooks:        DefaultWebhookConfig(),
+		Scheduler:       DefaultSchedulerConfig(),
+		Tracing:         DefaultTracingConfig(),
+		Dashboard:       DefaultDashboardConfig(),
+		Decorators: []DecoratorConfig{
+			{Name: "cache"},
+			{Name: "logging"},
+		},
+	}
+}
+
+// LoadConfig reads the JSON config file at path on top of DefaultConfig.
+// Unknown fields are rejected so typos don't go unnoticed.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := unmarshalConfig(raw, &cfg); err != nil {
+			return cfg, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+
+	if token := os.Getenv("FACT_ADMIN_TOKEN"); token != "" {
+		cfg.AdminToken = token
+	}
+	return cfg, nil
+}
+
+// unmarshalConfig decodes data into v, accepting duration strings for time.Duration fields.
+func unmarshalConfig(data []byte, v interface{}) error {
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return err
+	}
+	tree, err := convertDurations(tree, reflect.TypeOf(v), true)
+	if err != nil {
+		return err
+	}
+	data, err = json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// marshalConfig encodes v as JSON, writing time.Duration fields as duration strings.
+func marshalConfig(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	tree, err = convertDurations(tree, reflect.TypeOf(v), false)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tree)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// convertDurations walks a decoded JSON tree alongside the Go type it maps to, converting
+// time.Duration values from strings to nanoseconds when parsing, and back when not.
+func convertDurations(tree interface{}, t reflect.Type, parsing bool) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch node := tree.(type) {
+	case string:
+		if t == durationType && parsing {
+			d, err := time.ParseDuration(node)
+			if err != nil {
+				return nil, err
+			}
+			return int64(d), nil
+		}
+	case float64:
+		if t == durationType && !parsing {
+			return time.Duration(node).String(), nil
+		}
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return node, nil
+		}
+		for i := range node {
+			converted, err := convertDurations(node[i], t.Elem(), parsing)
+			if err != nil {
+				return nil, err
+			}
+			node[i] = converted
+		}
+	case map[string]interface{}:
+		if t.Kind() != reflect.Struct {
+			return node, nil
+		}
+		for key, value := range node {
+			field, ok := jsonField(t, key)
+			if !ok {
+				continue
+			}
+			converted, err := convertDurations(value, field.Type, parsing)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			node[key] = converted
+		}
+	}
+	return tree, nil
+}
+
+// jsonField finds the field of struct type t encoded under the JSON key name.
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" {
+			if f, ok := jsonField(field.Type, name); ok {
+				return f, true
+			}
+			continue
+		}
+		if tag == name || (tag == "" && strings.EqualFold(field.Name, name)) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		expr     string
+		loc      *time.Location
+		from     time.Time
+		expected time.Time
+	}{
+		{"0 9 * * *", time.UTC, time.Date(2024, 5, 1, 8, 59, 30, 0, time.UTC), time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * *", time.UTC, time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC), time.Date(2024, 5, 2, 9, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.UTC, time.Date(2024, 5, 1, 9, 1, 0, 0, time.UTC), time.Date(2024, 5, 1, 9, 15, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.UTC, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 8 * * mon-fri", time.UTC, time.Date(2024, 5, 3, 9, 0, 0, 0, time.UTC), time.Date(2024, 5, 6, 8, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.UTC, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted.
+		{"0 0 13 * 5", time.UTC, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.UTC, time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.UTC, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+		// 9:00 in Berlin is 7:00 UTC in summer.
+		{"0 9 * * *", berlin, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 7, 1, 7, 0, 0, 0, time.UTC)},
+		// 2:30 doesn't exist on the day clocks go forward in Berlin.
+		{"30 2 * * *", berlin, time.Date(2024, 3, 30, 12, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 0, 30, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		s, err := ParseSchedule(test.expr, test.loc)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if next := s.Next(test.from); !next.Equal(test.expected) {
+			t.Errorf("%s after %s: Expected %s but got %s", test.expr, test.from, test.expected, next)
+		}
+	}
+}
+
+func TestParseScheduleRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *"} {
+		if _, err := ParseSchedule(expr, nil); err == nil {
+			t.Errorf("%q: Expected an error but got none", expr)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"BuildAndStructureAMicroservice/catfact"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardTemplate renders the dashboard page.
+var dashboardTemplate = template.Must(template.New("dashboard.html").Funcs(template.FuncMap{
+	"sparkline": sparkline,
+	"percent":   func(ratio float64) string { return fmt.Sprintf("%.1f%%", ratio*100) },
+	"ms":        func(ms float64) string { return fmt.Sprintf("%.1f ms", ms) },
+	"clock":     func(t time.Time) string { return t.Format("15:04:05") },
+	"last": func(points []RequestPoint) *RequestPoint {
+		if len(points) == 0 {
+			return nil
+		}
+		return &points[len(points)-1]
+	},
+}).ParseFS(dashboardFiles, "dashboard/dashboard.html"))
+
+// Provider health states.
+const (
+	ProviderUp       = "up"
+	ProviderDegraded = "degraded"
+	ProviderDown     = "down"
+)
+
+// DashboardConfig holds the settings of the status dashboard.
+type DashboardConfig struct {
+	// Bucket is the time covered by each point of the request sparklines.
+	Bucket time.Duration `json:"bucket"`
+	// Buckets is the number of points of the request sparklines.
+	Buckets int `json:"buckets"`
+	// MaxErrors is how many of the last errors are shown.
+	MaxErrors int `json:"max_errors"`
+	// Refresh is how often the page reloads its data.
+	Refresh time.Duration `json:"refresh"`
+}
+
+// DefaultDashboardConfig returns the default dashboard settings: ten minutes of requests
+// in ten second points.
+func DefaultDashboardConfig() DashboardConfig {
+	return DashboardConfig{
+		Bucket:    10 * time.Second,
+		Buckets:   60,
+		MaxErrors: 20,
+		Refresh:   5 * time.Second,
+	}
+}
+
+// DashboardData is the state shown by the dashboard, as served by /dashboard/data.
+type DashboardData struct {
+	Time   time.Time `json:"time"`
+	Uptime string    `json:"uptime"`
+	// Fact is the last fact served, if any.
+	Fact   *CatFact  `json:"fact,omitempty"`
+	FactAt time.Time `json:"fact_at,omitempty"`
+	// Requests holds a point per bucket, oldest first.
+	Requests []RequestPoint `json:"requests"`
+	// RequestRate is the average number of requests per second over the points.
+	RequestRate float64 `json:"request_rate"`
+	// CacheHitRatio is the share of single facts answered from a cache or the prefetch
+	// pool over the points.
+	CacheHitRatio float64          `json:"cache_hit_ratio"`
+	Provider      ProviderHealth   `json:"provider"`
+	Errors        []DashboardError `json:"errors"`
+	// Refresh is how often the page reloads this data, in seconds.
+	Refresh float64 `json:"refresh"`
+}
+
+// RequestPoint sums up the requests of a bucket.
+type RequestPoint struct {
+	Start      time.Time `json:"start"`
+	Requests   int64     `json:"requests"`
+	Errors     int64     `json:"errors"`
+	AvgLatency float64   `json:"avg_latency_ms"`
+	MaxLatency float64   `json:"max_latency_ms"`
+}
+
+// ProviderHealth describes the health of the upstream fact provider.
+type ProviderHealth struct {
+	Upstream string `json:"upstream"`
+	// Status is up, degraded when recent requests failed or a breaker is probing, and down
+	// when a breaker is open.
+	Status      string         `json:"status"`
+	Breakers    []BreakerState `json:"breakers"`
+	LastSuccess time.Time      `json:"last_success,omitempty"`
+	LastFailure time.Time      `json:"last_failure,omitempty"`
+}
+
+// DashboardError is a failed request.
+type DashboardError struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Detail    string    `json:"detail"`
+	RequestID string    `json:"request_id"`
+}
+
+// requestBucket holds the counters of the requests started within a bucket.
+type requestBucket struct {
+	start                time.Time
+	requests, errors     int64
+	totalLatency         time.Duration
+	maxLatency           time.Duration
+	cacheHits, cacheMiss int64
+}
+
+// Dashboard records the requests served by an ApiServer and renders a status page for them.
+type Dashboard struct {
+	cfg      DashboardConfig
+	stack    *Stack
+	upstream string
+	started  time.Time
+	now      func() time.Time
+
+	mu          sync.Mutex
+	buckets     []requestBucket
+	errors      []DashboardError
+	fact        *CatFact
+	factAt      time.Time
+	lastSuccess time.Time
+	lastFailure time.Time
+}
+
+// NewDashboard creates a new instance of Dashboard showing the breakers of stack, which
+// may be nil, and the health of the upstream at the given URL.
+func NewDashboard(stack *Stack, upstream string, cfg DashboardConfig) *Dashboard {
+	if cfg.Buckets < 1 {
+		cfg.Buckets = 1
+	}
+	if cfg.Bucket <= 0 {
+		cfg.Bucket = time.Second
+	}
+	return &Dashboard{
+		cfg:      cfg,
+		stack:    stack,
+		upstream: upstream,
+		started:  time.Now(),
+		now:      time.Now,
+		buckets:  make([]requestBucket, cfg.Buckets),
+	}
+}
+
+// Middleware records every request served by next. Only the requests that provider reports as
+// answered by the fact provider, such as a fact or a page of facts, tell how the provider is doing.
+func (d *Dashboard) Middleware(next http.Handler, provider func(*http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The dashboard polling itself would only add noise.
+		if r.URL.Path == "/dashboard" || strings.HasPrefix(r.URL.Path, "/dashboard/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := d.now()
+		rec := &dashboardRecorder{responseRecorder: responseRecorder{ResponseWriter: w}}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		d.record(r, start, d.now().Sub(start), rec, provider(r))
+	})
+}
+
+// ObserveFact records fact as the last fact served.
+func (d *Dashboard) ObserveFact(fact *CatFact) {
+	if d == nil || fact == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.fact, d.factAt = copyFact(fact), d.now()
+}
+
+// record adds a served request to its bucket, and to the last errors if it failed. Requests
+// answered by the provider also update its last success or failure.
+func (d *Dashboard) record(r *http.Request, start time.Time, latency time.Duration, rec *dashboardRecorder, provider bool) {
+	failed := rec.status >= http.StatusInternalServerError
+	cached := false
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b := d.bucket(start)
+	b.requests++
+	b.totalLatency += latency
+	if latency > b.maxLatency {
+		b.maxLatency = latency
+	}
+	switch CacheStatus(rec.Header().Get("X-Cache")) {
+	case CacheHit, CacheStale, CachePrefetch:
+		b.cacheHits++
+		cached = true
+	case CacheMiss:
+		b.cacheMiss++
+	}
+
+	// Client errors and facts kept by a cache or the prefetch pool say nothing of the provider.
+	if provider && !failed && !cached && rec.status < http.StatusBadRequest {
+		d.lastSuccess = start
+	}
+	if !failed {
+		return
+	}
+	b.errors++
+	if provider {
+		d.lastFailure = start
+	}
+	d.errors = append(d.errors, DashboardError{
+		Time:      start,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Status:    rec.status,
+		Detail:    rec.detail(),
+		RequestID: catfact.RequestIDFromContext(r.Context()),
+	})
+	if len(d.errors) > d.cfg.MaxErrors {
+		d.errors = append(d.errors[:0], d.errors[len(d.errors)-d.cfg.MaxErrors:]...)
+	}
+}
+
+// bucket returns the bucket of the time t, resetting it if it last held an older time.
+// The caller must hold d.mu.
+func (d *Dashboard) bucket(t time.Time) *requestBucket {
+	start := t.Truncate(d.cfg.Bucket)
+	b := &d.buckets[int(start.UnixNano()/int64(d.cfg.Bucket))%len(d.buckets)]
+	if !b.start.Equal(start) {
+		*b = requestBucket{start: start}
+	}
+	return b
+}
+
+// Data returns the current state of the dashboard.
+func (d *Dashboard) Data() DashboardData {
+	now := d.now()
+	data := DashboardData{
+		Time:     now,
+		Uptime:   now.Sub(d.started).Truncate(time.Second).String(),
+		Requests: make([]RequestPoint, 0, len(d.buckets)),
+		Provider: ProviderHealth{Upstream: d.upstream, Status: ProviderUp, Breakers: []BreakerState{}},
+		Refresh:  d.cfg.Refresh.Seconds(),
+	}
+
+	d.mu.Lock()
+	var requests, errors, hits, lookups int64
+	current := now.Truncate(d.cfg.Bucket)
+	for i := len(d.buckets) - 1; i >= 0; i-- {
+		start := current.Add(-time.Duration(i) * d.cfg.Bucket)
+		point := RequestPoint{Start: start}
+		if b := d.buckets[int(start.UnixNano()/int64(d.cfg.Bucket))%len(d.buckets)]; b.start.Equal(start) {
+			point.Requests, point.Errors = b.requests, b.errors
+			if b.requests > 0 {
+				point.AvgLatency = milliseconds(b.totalLatency / time.Duration(b.requests))
+			}
+			point.MaxLatency = milliseconds(b.maxLatency)
+			hits += b.cacheHits
+			lookups += b.cacheHits + b.cacheMiss
+		}
+		requests += point.Requests
+		errors += point.Errors
+		data.Requests = append(data.Requests, point)
+	}
+	if d.fact != nil {
+		data.Fact, data.FactAt = copyFact(d.fact), d.factAt
+	}
+	data.Errors = make([]DashboardError, 0, len(d.errors))
+	for i := len(d.errors) - 1; i >= 0; i-- {
+		data.Errors = append(data.Errors, d.errors[i])
+	}
+	data.Provider.LastSuccess, data.Provider.LastFailure = d.lastSuccess, d.lastFailure
+	d.mu.Unlock()
+
+	data.RequestRate = float64(requests) / (time.Duration(len(d.buckets)) * d.cfg.Bucket).Seconds()
+	if lookups > 0 {
+		data.CacheHitRatio = float64(hits) / float64(lookups)
+	}
+
+	if errors > 0 {
+		data.Provider.Status = ProviderDegraded
+	}
+	if d.stack != nil {
+		for _, layer := range d.stack.Layers {
+			breaker, ok := layer.Service.(*BreakerService)
+			if !ok {
+				continue
+			}
+			state := breaker.State()
+			data.Provider.Breakers = append(data.Provider.Breakers, state)
+			switch {
+			case state == BreakerOpen:
+				data.Provider.Status = ProviderDown
+			case state == BreakerHalfOpen && data.Provider.Status == ProviderUp:
+				data.Provider.Status = ProviderDegraded
+			}
+		}
+	}
+	return data
+}
+
+// milliseconds converts d to fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// sparkline returns the points of an SVG polyline drawing values in a 100x20 box.
+func sparkline(points []RequestPoint, field string) string {
+	values := make([]float64, len(points))
+	highest := 0.0
+	for i, p := range points {
+		switch field {
+		case "requests":
+			values[i] = float64(p.Requests)
+		case "errors":
+			values[i] = float64(p.Errors)
+		case "latency":
+			values[i] = p.AvgLatency
+		}
+		if values[i] > highest {
+			highest = values[i]
+		}
+	}
+
+	var b strings.Builder
+	for i, v := range values {
+		x := 0.0
+		if len(values) > 1 {
+			x = float64(i) * 100 / float64(len(values)-1)
+		}
+		y := 20.0
+		if highest > 0 {
+			y = 20 - v*20/highest
+		}
+		fmt.Fprintf(&b, "%.1f,%.1f ", x, y)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// handleDashboard renders the dashboard page.
+func (d *Dashboard) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, d.Data()); err != nil {
+		logf(LevelWarn, "dashboard render err=%v", err)
+	}
+}
+
+// handleData serves the dashboard data the page polls.
+func (d *Dashboard) handleData(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, d.Data())
+}
+
+// assets serves the embedded scripts and styles of the page under /dashboard/assets/.
+func (d *Dashboard) assets() http.Handler {
+	assets, err := fs.Sub(dashboardFiles, "dashboard/assets")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/dashboard/assets/", http.FileServer(http.FS(assets)))
+}
+
+// dashboardRecorder records a response and the start of failed response bodies.
+type dashboardRecorder struct {
+	responseRecorder
+	body []byte
+}
+
+func (r *dashboardRecorder) Write(p []byte) (int, error) {
+	n, err := r.responseRecorder.Write(p)
+	if r.status >= http.StatusInternalServerError && len(r.body) < 1024 {
+		r.body = append(r.body, p[:n]...)
+	}
+	return n, err
+}
+
+// detail returns the detail of the problem answered, or the start of the body.
+func (r *dashboardRecorder) detail() string {
+	var problem catfact.Problem
+	if err := json.Unmarshal(r.body, &problem); err == nil && problem.Detail != "" {
+		return problem.Detail
+	}
+	detail := []rune(strings.TrimSpace(string(r.body)))
+	if len(detail) > 200 {
+		return string(detail[:200]) + "…"
+	}
+	return string(detail)
+}
@@ -0,0 +1,74 @@
+// Reloads the dashboard data every few seconds and updates the page in place.
+(function () {
+  "use strict";
+
+  var refresh = parseFloat(document.body.dataset.refresh) || 5;
+
+  function text(id, value) {
+    document.getElementById(id).textContent = value;
+  }
+
+  function clock(iso) {
+    var t = new Date(iso);
+    return isNaN(t) || t.getFullYear() < 2 ? "never" : t.toLocaleTimeString([], { hour12: false });
+  }
+
+  function sparkline(id, values) {
+    var highest = Math.max.apply(null, values.concat([0]));
+    var points = values.map(function (v, i) {
+      var x = values.length > 1 ? i * 100 / (values.length - 1) : 0;
+      var y = highest > 0 ? 20 - v * 20 / highest : 20;
+      return x.toFixed(1) + "," + y.toFixed(1);
+    });
+    document.getElementById(id).setAttribute("points", points.join(" "));
+  }
+
+  function render(data) {
+    var status = document.getElementById("provider-status");
+    status.className = "provider " + data.provider.status;
+    status.textContent = data.provider.status;
+    text("uptime", data.uptime);
+    text("updated", clock(data.time));
+    text("fact", data.fact ? data.fact.fact : "No fact served yet.");
+
+    text("request-rate", data.request_rate.toFixed(2));
+    sparkline("requests-line", data.requests.map(function (p) { return p.requests; }));
+    var last = data.requests[data.requests.length - 1];
+    text("latency", last ? last.avg_latency_ms.toFixed(1) + " ms" : "");
+    sparkline("latency-line", data.requests.map(function (p) { return p.avg_latency_ms; }));
+    text("cache-hit-ratio", (data.cache_hit_ratio * 100).toFixed(1) + "%");
+
+    text("upstream", data.provider.upstream);
+    text("breakers", data.provider.breakers.length ? data.provider.breakers.join(", ") : "none");
+    text("last-success", clock(data.provider.last_success));
+    text("last-failure", clock(data.provider.last_failure));
+
+    var rows = document.getElementById("errors");
+    rows.textContent = "";
+    if (data.errors.length === 0) {
+      var empty = rows.insertRow().insertCell();
+      empty.colSpan = 5;
+      empty.className = "muted";
+      empty.textContent = "No errors.";
+    }
+    data.errors.forEach(function (e) {
+      var row = rows.insertRow();
+      [clock(e.time), e.method + " " + e.path, e.status, e.detail].forEach(function (value) {
+        row.insertCell().textContent = value;
+      });
+      var id = document.createElement("code");
+      id.textContent = e.request_id;
+      row.insertCell().appendChild(id);
+    });
+  }
+
+  function poll() {
+    fetch("/dashboard/data", { cache: "no-store" })
+      .then(function (response) { return response.json(); })
+      .then(render)
+      .catch(function () { /* Keep the last data until the service answers again. */ })
+      .then(function () { setTimeout(poll, refresh * 1000); });
+  }
+
+  setTimeout(poll, refresh * 1000);
+})();
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard(t *testing.T) {
+	stack, err := BuildStack(&staticService{fact: "Cats purr."}, []DecoratorConfig{{Name: "breaker"}, {Name: "cache"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dashboard := NewDashboard(stack, "http://upstream.test", DefaultDashboardConfig())
+	handler := NewApiServer(stack, WithDashboard(dashboard)).Handler()
+	get := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		return response
+	}
+
+	// A miss, a hit, then a failure once the breaker is open.
+	get("/")
+	get("/")
+	stack.Layers[0].Service.(*BreakerService).Trip()
+	get("/")
+
+	data := dashboard.Data()
+	var requests, errors int64
+	for _, point := range data.Requests {
+		requests += point.Requests
+		errors += point.Errors
+	}
+	if requests != 3 || errors != 1 {
+		t.Errorf("Expected 3 requests and 1 error but got %d and %d", requests, errors)
+	}
+	if data.Fact == nil || data.Fact.Fact != "Cats purr." {
+		t.Errorf("Expected the current fact to be %q but got %+v", "Cats purr.", data.Fact)
+	}
+	if data.CacheHitRatio != 0.5 {
+		t.Errorf("Expected a cache hit ratio of 0.5 but got %v", data.CacheHitRatio)
+	}
+	if data.Provider.Status != ProviderDown || len(data.Provider.Breakers) != 1 || data.Provider.Breakers[0] != BreakerOpen {
+		t.Errorf("Expected the provider to be down with an open breaker but got %+v", data.Provider)
+	}
+	if len(data.Errors) != 1 || data.Errors[0].Status != http.StatusServiceUnavailable || data.Errors[0].Detail != ErrBreakerOpen.Error() || data.Errors[0].RequestID == "" {
+		t.Errorf("Expected the breaker error but got %+v", data.Errors)
+	}
+
+	// The page is rendered with the same data, and the dashboard doesn't count itself.
+	page := get("/dashboard")
+	if contentType := page.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+		t.Errorf("Expected an HTML page but got %q", contentType)
+	}
+	for _, expected := range []string{"Cats purr.", `class="provider down"`, "50.0%", ErrBreakerOpen.Error()} {
+		if !strings.Contains(page.Body.String(), expected) {
+			t.Errorf("Expected the page to contain %q", expected)
+		}
+	}
+	if script := get("/dashboard/assets/dashboard.js"); script.Code != http.StatusOK || !strings.Contains(script.Body.String(), "/dashboard/data") {
+		t.Errorf("Expected the embedded script but got status %d", script.Code)
+	}
+	get("/dashboard/data")
+	requests = 0
+	for _, point := range dashboard.Data().Requests {
+		requests += point.Requests
+	}
+	if requests != 3 {
+		t.Errorf("Expected the dashboard requests not to be counted but got %d requests", requests)
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	points := []RequestPoint{{Requests: 0}, {Requests: 5}, {Requests: 10}}
+	if line := sparkline(points, "requests"); line != "0.0,20.0 50.0,10.0 100.0,0.0" {
+		t.Errorf("Expected a rising line but got %q", line)
+	}
+	if line := sparkline(points[:1], "latency"); line != "0.0,20.0" {
+		t.Errorf("Expected a flat point but got %q", line)
+	}
+}
+
+func TestDashboardProviderHealth(t *testing.T) {
+	stack, err := BuildStack(&staticService{fact: "Cats purr."}, []DecoratorConfig{{Name: "cache"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dashboard := NewDashboard(stack, "http://upstream.test", DefaultDashboardConfig())
+	now := time.Now()
+	dashboard.now = func() time.Time { return now }
+	handler := NewApiServer(stack, WithDashboard(dashboard)).Handler()
+	get := func(path string) {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// Neither other routes nor bad requests tell how the provider is doing.
+	get("/openapi.json")
+	get("/facts?page=zero")
+	if provider := dashboard.Data().Provider; !provider.LastSuccess.IsZero() || !provider.LastFailure.IsZero() {
+		t.Errorf("Expected no success nor failure of the provider but got %+v", provider)
+	}
+
+	// A fact fetched from the provider is a success, one kept by the cache isn't.
+	get("/")
+	fetched := now
+	now = now.Add(time.Minute)
+	get("/")
+	if provider := dashboard.Data().Provider; !provider.LastSuccess.Equal(fetched) {
+		t.Errorf("Expected the last success at %v but got %v", fetched, provider.LastSuccess)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Enricher adds fields derived from CatFact.Fact to a fact.
+type Enricher interface {
+	Enrich(fact *CatFact)
+}
+
+// EnricherFunc adapts a function to the Enricher interface.
+type EnricherFunc func(fact *CatFact)
+
+// Enrich implements Enricher.
+func (f EnricherFunc) Enrich(fact *CatFact) {
+	f(fact)
+}
+
+// EnrichConfig holds the settings of an EnrichService.
+type EnrichConfig struct {
+	// Default lists the enrichers applied when a request doesn't ask for any.
+	Default []string `json:"default"`
+	// Lexicon maps each tag to the keywords that earn a fact that tag.
+	Lexicon map[string][]string `json:"lexicon"`
+}
+
+// DefaultEnrichConfig returns the default enrichment settings: no enricher unless asked
+// for, and a small lexicon of cat topics.
+func DefaultEnrichConfig() EnrichConfig {
+	return EnrichConfig{
+		Default: []string{},
+		Lexicon: map[string][]string{
+			"anatomy":  {"whisker", "whiskers", "tail", "paw", "paws", "claw", "claws", "eye", "eyes", "ear", "ears", "teeth", "bone", "bones", "fur", "nose", "tongue"},
+			"behavior": {"purr", "purrs", "purring", "sleep", "sleeps", "hunt", "hunting", "meow", "meows", "groom", "grooming", "play", "scratch"},
+			"history":  {"egypt", "egyptian", "egyptians", "ancient", "century", "history", "historical"},
+			"health":   {"health", "healthy", "vet", "disease", "diet", "weight", "lifespan"},
+			"kittens":  {"kitten", "kittens", "litter", "mother"},
+		},
+	}
+}
+
+// enrichers is the registry of named enrichers, built from the settings of an EnrichService.
+var enrichers = map[string]func(EnrichConfig) Enricher{
+	"id":        func(EnrichConfig) Enricher { return EnricherFunc(enrichID) },
+	"length":    func(EnrichConfig) Enricher { return EnricherFunc(enrichLength) },
+	"words":     func(EnrichConfig) Enricher { return EnricherFunc(enrichWords) },
+	"sentences": func(EnrichConfig) Enricher { return EnricherFunc(enrichSentences) },
+	"tags":      func(cfg EnrichConfig) Enricher { return newTagEnricher(cfg.Lexicon) },
+}
+
+// RegisterEnricher adds a named enricher to the registry, replacing any with the same name.
+func RegisterEnricher(name string, build func(EnrichConfig) Enricher) {
+	enrichers[name] = build
+}
+
+// EnricherNames returns the names of all registered enrichers, sorted.
+func EnricherNames() []string {
+	names := make([]string, 0, len(enrichers))
+	for name := range enrichers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// enrichKey is the context key of the enrichers asked for by a request.
+type enrichKey struct{}
+
+// withEnrichers returns a copy of ctx asking for the named enrichers.
+func withEnrichers(ctx context.Context, names []string) context.Context {
+	return context.WithValue(ctx, enrichKey{}, names)
+}
+
+// enrichParam reads the enrich query parameter of r, a comma-separated list of enricher
+// names or "all", and returns ctx asking for them. Without the parameter ctx is returned as is.
+func enrichParam(ctx context.Context, r *http.Request) (context.Context, error) {
+	raw, ok := r.URL.Query()["enrich"]
+	if !ok {
+		return ctx, nil
+	}
+
+	names := []string{}
+	for _, value := range raw {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			switch {
+			case name == "":
+			case name == "all":
+				names = append(names, EnricherNames()...)
+			case enrichers[name] == nil:
+				return ctx, fmt.Errorf("unknown enricher %q (known: %s)", name, strings.Join(EnricherNames(), ", "))
+			default:
+				names = append(names, name)
+			}
+		}
+	}
+	return withEnrichers(ctx, names), nil
+}
+
+// EnrichService is a decorator adding derived fields to the facts returned by the next Service.
+type EnrichService struct {
+	next      Service
+	cfg       EnrichConfig
+	enrichers map[string]Enricher
+}
+
+// NewEnrichService creates a new instance of EnrichService.
+func NewEnrichService(next Service, cfg EnrichConfig) *EnrichService {
+	s := &EnrichService{
+		next:      next,
+		cfg:       cfg,
+		enrichers: make(map[string]Enricher, len(enrichers)),
+	}
+	for name, build := range enrichers {
+		s.enrichers[name] = build(cfg)
+	}
+	return s
+}
+
+// GetCatFact implements the Service interface.
+func (s *EnrichService) GetCatFact(ctx context.Context) (*CatFact, error) {
+	fact, err := s.next.GetCatFact(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.enrich(ctx, fact), nil
+}
+
+// ListFacts implements the Service interface.
+func (s *EnrichService) ListFacts(ctx context.Context, page, limit, maxLength int) (*FactPage, error) {
+	facts, err := s.next.ListFacts(ctx, page, limit, maxLength)
+	if err != nil {
+		return nil, err
+	}
+
+	enriched := *facts
+	enriched.Data = make([]CatFact, len(facts.Data))
+	for i := range facts.Data {
+		enriched.Data[i] = *s.enrich(ctx, &facts.Data[i])
+	}
+	return &enriched, nil
+}
+
+// ListBreeds implements the Service interface.
+func (s *EnrichService) ListBreeds(ctx context.Context, page, limit int) (*BreedPage, error) {
+	return s.next.ListBreeds(ctx, page, limit)
+}
+
+// enrich returns a copy of fact with the fields of the enrichers asked for by ctx. The
+// original is left alone since it may be shared, such as by a cache.
+func (s *EnrichService) enrich(ctx context.Context, fact *CatFact) *CatFact {
+	names, ok := ctx.Value(enrichKey{}).([]string)
+	if !ok {
+		names = s.cfg.Default
+	}
+	if len(names) == 0 {
+		return fact
+	}
+
+	enriched := *fact
+	enriched.Tags = append([]string(nil), fact.Tags...)
+	for _, name := range names {
+		if e, ok := s.enrichers[name]; ok {
+			e.Enrich(&enriched)
+		}
+	}
+	return &enriched
+}
+
+// enrichID sets a stable ID hashed from the fact, ignoring case and spacing.
+func enrichID(fact *CatFact) {
+	fact.ID = contentID(fact.Fact)
+}
+
+// contentID returns a stable hash of text, ignoring case and spacing.
+func contentID(text string) string {
+	normalized := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:8])
+}
+
+// enrichLength sets the number of characters of the fact.
+func enrichLength(fact *CatFact) {
+	fact.Length = utf8.RuneCountInString(fact.Fact)
+}
+
+// enrichWords sets the number of words of the fact.
+func enrichWords(fact *CatFact) {
+	fact.Words = len(strings.Fields(fact.Fact))
+}
+
+// abbreviations end with a period without ending a sentence.
+var abbreviations = map[string]bool{
+	"e.g.": true, "i.e.": true, "etc.": true, "vs.": true, "approx.": true, "ca.": true,
+	"mr.": true, "mrs.": true, "ms.": true, "dr.": true, "st.": true, "mt.": true, "no.": true,
+	"u.s.": true, "a.d.": true, "b.c.": true,
+}
+
+// enrichSentences sets the number of sentences of the fact. A sentence ends with a period,
+// an exclamation or a question mark followed by a space or the end of the fact, unless the
+// word is a common abbreviation. Text after the last end still counts as a sentence.
+func enrichSentences(fact *CatFact) {
+	count, pending := 0, false
+	for _, word := range strings.Fields(fact.Fact) {
+		pending = true
+		end := strings.TrimRight(word, `"')]`)
+		if end == "" || !strings.ContainsAny(end[len(end)-1:], ".!?") {
+			continue
+		}
+		if strings.HasSuffix(end, ".") && abbreviations[strings.ToLower(end)] {
+			continue
+		}
+		count++
+		pending = false
+	}
+	if pending {
+		count++
+	}
+	fact.Sentences = count
+}
+
+// tagEnricher tags facts with the topics of a lexicon whose keywords they mention.
+type tagEnricher struct {
+	// tags maps each lowercase keyword to its tags.
+	tags map[string][]string
+}
+
+// newTagEnricher creates a new instance of tagEnricher for lexicon, mapping tags to keywords.
+func newTagEnricher(lexicon map[string][]string) *tagEnricher {
+	e := &tagEnricher{tags: make(map[string][]string)}
+	for tag, keywords := range lexicon {
+		for _, keyword := range keywords {
+			keyword = strings.ToLower(keyword)
+			e.tags[keyword] = append(e.tags[keyword], tag)
+		}
+	}
+	return e
+}
+
+// Enrich implements Enricher.
+func (e *tagEnricher) Enrich(fact *CatFact) {
+	seen := make(map[string]bool)
+	for _, tag := range fact.Tags {
+		seen[tag] = true
+	}
+
+	for _, word := range factWords(fact.Fact) {
+		for _, tag := range e.tags[word] {
+			if !seen[tag] {
+				seen[tag] = true
+				fact.Tags = append(fact.Tags, tag)
+			}
+		}
+	}
+	sort.Strings(fact.Tags)
+}
+
+// factWords splits text into lowercase words, without punctuation.
+func factWords(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+	for i, word := range words {
+		words[i] = strings.Trim(word, "'")
+	}
+	return words
+}
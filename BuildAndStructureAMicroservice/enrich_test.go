@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"BuildAndStructureAMicroservice/catfact"
+)
+
+func TestEnrichers(t *testing.T) {
+	fact := CatFact{Fact: "Ancient Egyptians shaved their eyebrows when a cat died, e.g. in mourning. Cats purr at 25 Hz! Isn't that 1.5 times odd?"}
+	svc := NewEnrichService(&staticService{fact: fact.Fact}, DefaultEnrichConfig())
+
+	enriched, err := svc.GetCatFact(withEnrichers(context.Background(), EnricherNames()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enriched.Length != 120 {
+		t.Errorf("Expected length 120 but got %d", enriched.Length)
+	}
+	if enriched.Words != 22 {
+		t.Errorf("Expected 22 words but got %d", enriched.Words)
+	}
+	if enriched.Sentences != 3 {
+		t.Errorf("Expected 3 sentences but got %d", enriched.Sentences)
+	}
+	if expected := []string{"behavior", "history"}; !reflect.DeepEqual(enriched.Tags, expected) {
+		t.Errorf("Expected tags %v but got %v", expected, enriched.Tags)
+	}
+
+	// The ID ignores case and spacing, but not wording.
+	same := CatFact{Fact: "  ancient egyptians shaved their EYEBROWS when a cat died, e.g. in mourning.  Cats purr at 25 Hz! Isn't that 1.5 times odd?"}
+	other := CatFact{Fact: "Cats sleep a lot."}
+	enrichID(&same)
+	enrichID(&other)
+	if len(enriched.ID) != 16 || same.ID != enriched.ID || other.ID == enriched.ID {
+		t.Errorf("Expected a stable 16-character ID but got %q, %q and %q", enriched.ID, same.ID, other.ID)
+	}
+}
+
+func TestEnrichServiceSelection(t *testing.T) {
+	cfg := DefaultEnrichConfig()
+	cfg.Default = []string{"length"}
+	cfg.Lexicon = map[string][]string{"sound": {"purr"}}
+	svc := NewEnrichService(&staticService{fact: "Cats purr."}, cfg)
+
+	fact, _ := svc.GetCatFact(context.Background())
+	if fact.Length != 10 || fact.Words != 0 || fact.Tags != nil {
+		t.Errorf("Expected only the default length but got %+v", fact)
+	}
+
+	fact, _ = svc.GetCatFact(withEnrichers(context.Background(), []string{"words", "tags"}))
+	if fact.Length != 0 || fact.Words != 2 || !reflect.DeepEqual(fact.Tags, []string{"sound"}) {
+		t.Errorf("Expected words and tags only but got %+v", fact)
+	}
+
+	fact, _ = svc.GetCatFact(withEnrichers(context.Background(), []string{}))
+	if fact.Length != 0 {
+		t.Errorf("Expected no enrichment but got %+v", fact)
+	}
+}
+
+func TestEnrichQueryParameter(t *testing.T) {
+	stack := NewEnrichService(&staticService{fact: "Cats purr. Dogs bark."}, DefaultEnrichConfig())
+	handler := NewApiServer(stack).Handler()
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/?enrich=words,sentences", nil))
+	var fact CatFact
+	if err := json.NewDecoder(response.Body).Decode(&fact); err != nil {
+		t.Fatal(err)
+	}
+	if fact.Words != 4 || fact.Sentences != 2 || fact.ID != "" {
+		t.Errorf("Expected 4 words and 2 sentences only but got %+v", fact)
+	}
+
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/?enrich=words,rhymes", nil))
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d but got %d", http.StatusBadRequest, response.Code)
+	}
+	var problem catfact.Problem
+	if err := json.NewDecoder(response.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Detail == "" {
+		t.Error("Expected the problem to name the unknown enricher")
+	}
+}
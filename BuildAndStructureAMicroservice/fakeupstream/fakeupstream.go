@@ -0,0 +1,311 @@
+// Package fakeupstream provides a local stand-in for the catfact.ninja API so tests can run
+// offline and deterministically. Faults such as latency, error statuses, malformed JSON,
+// truncated bodies and dropped connections can be scripted per endpoint.
+package fakeupstream
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Paths served by the fake upstream.
+const (
+	PathFact   = "/fact"
+	PathFacts  = "/facts"
+	PathBreeds = "/breeds"
+
+	// AnyPath applies a fault to every endpoint.
+	AnyPath = "*"
+)
+
+//go:embed fixtures/facts.json
+var factsFixture []byte
+
+//go:embed fixtures/breeds.json
+var breedsFixture []byte
+
+// Fact is a cat fact as served by the upstream.
+type Fact struct {
+	Fact   string `json:"fact"`
+	Length int    `json:"length"`
+}
+
+// Breed is a cat breed as served by the upstream.
+type Breed struct {
+	Breed   string `json:"breed"`
+	Country string `json:"country"`
+	Origin  string `json:"origin"`
+	Coat    string `json:"coat"`
+	Pattern string `json:"pattern"`
+}
+
+// Fault describes how a single response should misbehave. The zero Fault is a healthy response.
+type Fault struct {
+	// Latency delays the response. The delay is cut short when the client goes away.
+	Latency time.Duration
+	// Status, when set, replaces the response with an error page using this status code.
+	Status int
+	// ContentType overrides the Content-Type of the response.
+	ContentType string
+	// Body overrides the response body.
+	Body string
+	// Malformed replaces the body with invalid JSON.
+	Malformed bool
+	// Truncate announces the full body length but only sends half of it.
+	Truncate bool
+	// Drop closes the connection without sending a response. Clients transparently retry
+	// idempotent requests sent on a reused connection, so use SetFault to drop every attempt.
+	Drop bool
+}
+
+// Server is a fake catfact upstream built on httptest.Server.
+type Server struct {
+	*httptest.Server
+
+	facts  []Fact
+	breeds []Breed
+
+	listener net.Listener
+
+	mu       sync.Mutex
+	next     int
+	queued   map[string][]Fault
+	sticky   map[string]Fault
+	requests map[string]int
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithFacts replaces the fixture corpus of facts.
+func WithFacts(facts ...string) Option {
+	return func(s *Server) {
+		s.facts = makeFacts(facts)
+	}
+}
+
+// WithBreeds replaces the fixture corpus of breeds.
+func WithBreeds(breeds ...Breed) Option {
+	return func(s *Server) {
+		s.breeds = breeds
+	}
+}
+
+// WithListener serves on ln instead of a random local port, so processes other than the
+// test itself, such as a fact service started for a load test, can be pointed at it.
+func WithListener(ln net.Listener) Option {
+	return func(s *Server) {
+		s.listener = ln
+	}
+}
+
+// New starts a fake upstream serving the embedded fixture corpus. Call Close when done.
+func New(opts ...Option) *Server {
+	s := &Server{
+		facts:    DefaultFacts(),
+		breeds:   DefaultBreeds(),
+		queued:   make(map[string][]Fault),
+		sticky:   make(map[string]Fault),
+		requests: make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathFact, s.handleFact)
+	mux.HandleFunc(PathFacts, s.handleFacts)
+	mux.HandleFunc(PathBreeds, s.handleBreeds)
+	s.Server = httptest.NewUnstartedServer(mux)
+	if s.listener != nil {
+		s.Server.Listener.Close()
+		s.Server.Listener = s.listener
+	}
+	s.Server.Start()
+	return s
+}
+
+// DefaultFacts returns the embedded fixture corpus of facts.
+func DefaultFacts() []Fact {
+	var facts []string
+	if err := json.Unmarshal(factsFixture, &facts); err != nil {
+		panic(fmt.Sprintf("fakeupstream: invalid facts fixture: %s", err))
+	}
+	return makeFacts(facts)
+}
+
+// DefaultBreeds returns the embedded fixture corpus of breeds.
+func DefaultBreeds() []Breed {
+	var breeds []Breed
+	if err := json.Unmarshal(breedsFixture, &breeds); err != nil {
+		panic(fmt.Sprintf("fakeupstream: invalid breeds fixture: %s", err))
+	}
+	return breeds
+}
+
+// Inject queues faults for path. Each queued fault is used for exactly one request, in order,
+// before the server falls back to the sticky fault, if any, or a healthy response.
+func (s *Server) Inject(path string, faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queued[path] = append(s.queued[path], faults...)
+}
+
+// SetFault makes every request to path misbehave as described by fault until Reset is called.
+func (s *Server) SetFault(path string, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sticky[path] = fault
+}
+
+// Reset clears all faults and request counters and restarts the fact rotation.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next = 0
+	s.queued = make(map[string][]Fault)
+	s.sticky = make(map[string]Fault)
+	s.requests = make(map[string]int)
+}
+
+// Requests returns how many requests path has received.
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[path]
+}
+
+// fault records a request to path and returns the fault to apply to it.
+func (s *Server) fault(path string) Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[path]++
+	for _, key := range []string{path, AnyPath} {
+		if queue := s.queued[key]; len(queue) > 0 {
+			s.queued[key] = queue[1:]
+			return queue[0]
+		}
+	}
+	for _, key := range []string{path, AnyPath} {
+		if fault, ok := s.sticky[key]; ok {
+			return fault
+		}
+	}
+	return Fault{}
+}
+
+// handleFact serves the facts of the corpus one after the other.
+func (s *Server) handleFact(w http.ResponseWriter, r *http.Request) {
+	maxLength, _ := strconv.Atoi(r.URL.Query().Get("max_length"))
+	facts := filterFacts(s.facts, maxLength)
+
+	var fact Fact
+	if len(facts) > 0 {
+		s.mu.Lock()
+		fact = facts[s.next%len(facts)]
+		s.next++
+		s.mu.Unlock()
+	}
+
+	s.respond(w, r, PathFact, fact)
+}
+
+// handleFacts serves a page of the corpus of facts.
+func (s *Server) handleFacts(w http.ResponseWriter, r *http.Request) {
+	maxLength, _ := strconv.Atoi(r.URL.Query().Get("max_length"))
+	facts := filterFacts(s.facts, maxLength)
+
+	s.respond(w, r, PathFacts, paginate(s.URL+PathFacts, r, facts))
+}
+
+// handleBreeds serves a page of the corpus of breeds.
+func (s *Server) handleBreeds(w http.ResponseWriter, r *http.Request) {
+	s.respond(w, r, PathBreeds, paginate(s.URL+PathBreeds, r, s.breeds))
+}
+
+// respond writes v as JSON, applying whatever fault is scripted for path.
+func (s *Server) respond(w http.ResponseWriter, r *http.Request, path string, v interface{}) {
+	fault := s.fault(path)
+
+	if fault.Latency > 0 {
+		select {
+		case <-time.After(fault.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if fault.Drop {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			panic("fakeupstream: response writer does not support hijacking")
+		}
+		conn, _, err := hijacker.Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+
+	status, contentType := http.StatusOK, "application/json"
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("fakeupstream: encoding response: %s", err))
+	}
+	if fault.Status != 0 {
+		status, contentType = fault.Status, "text/html; charset=utf-8"
+		body = []byte(fmt.Sprintf("<html><body><h1>%d %s</h1></body></html>", fault.Status, http.StatusText(fault.Status)))
+	}
+	if fault.Malformed {
+		body = []byte(`{"fact": "Cats are`)
+	}
+	if fault.Body != "" {
+		body = []byte(fault.Body)
+	}
+	if fault.ContentType != "" {
+		contentType = fault.ContentType
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if fault.Truncate {
+		body = body[:len(body)/2]
+	}
+	w.Write(body)
+}
+
+// makeFacts turns plain strings into facts with their length set.
+func makeFacts(facts []string) []Fact {
+	out := make([]Fact, 0, len(facts))
+	for _, fact := range facts {
+		out = append(out, Fact{Fact: fact, Length: len(fact)})
+	}
+	return out
+}
+
+// filterFacts returns the facts not longer than maxLength. A maxLength of zero means no limit.
+func filterFacts(facts []Fact, maxLength int) []Fact {
+	if maxLength <= 0 {
+		return facts
+	}
+	var out []Fact
+	for _, fact := range facts {
+		if fact.Length <= maxLength {
+			out = append(out, fact)
+		}
+	}
+	return out
+}
@@ -0,0 +1,91 @@
+package fakeupstream
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// defaultLimit is the page size used when the request doesn't ask for one.
+const defaultLimit = 10
+
+// pageLink mirrors a navigation link of the upstream pagination.
+type pageLink struct {
+	URL    *string `json:"url"`
+	Label  string  `json:"label"`
+	Active bool    `json:"active"`
+}
+
+// page mirrors the paginated payload of the upstream, which nulls out missing values.
+type page[T any] struct {
+	CurrentPage  int        `json:"current_page"`
+	Data         []T        `json:"data"`
+	FirstPageURL string     `json:"first_page_url"`
+	From         *int       `json:"from"`
+	LastPage     int        `json:"last_page"`
+	LastPageURL  string     `json:"last_page_url"`
+	Links        []pageLink `json:"links"`
+	NextPageURL  *string    `json:"next_page_url"`
+	Path         string     `json:"path"`
+	PerPage      int        `json:"per_page"`
+	PrevPageURL  *string    `json:"prev_page_url"`
+	To           *int       `json:"to"`
+	Total        int        `json:"total"`
+}
+
+// paginate slices items according to the page and limit query parameters of r.
+func paginate[T any](path string, r *http.Request, items []T) page[T] {
+	current, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if current < 1 {
+		current = 1
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 {
+		limit = defaultLimit
+	}
+
+	lastPage := (len(items) + limit - 1) / limit
+	if lastPage < 1 {
+		lastPage = 1
+	}
+	pageURL := func(n int) string {
+		return path + "?page=" + strconv.Itoa(n)
+	}
+	optionalURL := func(n int) *string {
+		if n < 1 || n > lastPage {
+			return nil
+		}
+		u := pageURL(n)
+		return &u
+	}
+
+	p := page[T]{
+		CurrentPage:  current,
+		Data:         []T{},
+		FirstPageURL: pageURL(1),
+		LastPage:     lastPage,
+		LastPageURL:  pageURL(lastPage),
+		NextPageURL:  optionalURL(current + 1),
+		Path:         path,
+		PerPage:      limit,
+		PrevPageURL:  optionalURL(current - 1),
+		Total:        len(items),
+	}
+
+	start := (current - 1) * limit
+	if start < len(items) {
+		end := start + limit
+		if end > len(items) {
+			end = len(items)
+		}
+		from, to := start+1, end
+		p.Data = items[start:end]
+		p.From, p.To = &from, &to
+	}
+
+	p.Links = append(p.Links, pageLink{URL: optionalURL(current - 1), Label: "&laquo; Previous"})
+	for n := 1; n <= lastPage; n++ {
+		p.Links = append(p.Links, pageLink{URL: optionalURL(n), Label: strconv.Itoa(n), Active: n == current})
+	}
+	p.Links = append(p.Links, pageLink{URL: optionalURL(current + 1), Label: "Next &raquo;"})
+	return p
+}
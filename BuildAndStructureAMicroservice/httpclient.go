@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"BuildAndStructureAMicroservice/vcr"
+)
+
+// HTTPClientConfig holds the settings used to build the outbound HTTP client.
+type HTTPClientConfig struct {
+	// Timeout bounds the whole request, including reading the body.
+	Timeout               time.Duration `json:"timeout"`
+	DialTimeout           time.Duration `json:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration `json:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `json:"response_header_timeout"`
+	IdleConnTimeout       time.Duration `json:"idle_conn_timeout"`
+
+	MaxIdleConns        int `json:"max_idle_conns"`
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int `json:"max_conns_per_host"`
+
+	// Proxy is the proxy URL to use. When empty the standard proxy environment variables are used,
+	// and "direct" disables proxying altogether.
+	Proxy string `json:"proxy"`
+
+	// UserAgent is sent on every request that doesn't already set one.
+	UserAgent string `json:"user_agent"`
+
+	// WrapTransport, when set, receives the configured transport and returns the round-tripper
+	// the client will actually use. Tests use it to swap in a fake.
+	WrapTransport func(http.RoundTripper) http.RoundTripper `json:"-"`
+}
+
+// DefaultHTTPClientConfig returns sane defaults for talking to the upstream API.
+func DefaultHTTPClientConfig() HTTPClientConfig {
+	return HTTPClientConfig{
+		Timeout:               10 * time.Second,
+		DialTimeout:           5 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		UserAgent:             "fact-service/1.0",
+	}
+}
+
+// NewHTTPClient builds an *http.Client from the provided config.
+func NewHTTPClient(cfg HTTPClientConfig) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	switch cfg.Proxy {
+	case "":
+	case "direct":
+		proxy = nil
+	default:
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url %q: %w", cfg.Proxy, err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+	}
+	if cfg.WrapTransport != nil {
+		transport = cfg.WrapTransport(transport)
+	}
+	if cfg.UserAgent != "" {
+		transport = &userAgentTransport{next: transport, userAgent: cfg.UserAgent}
+	}
+	transport = &tracingTransport{next: transport}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+	}, nil
+}
+
+// VCRConfig plugs a vcr.Recorder into the upstream client, to record the upstream traffic to a
+// cassette or to replay it without the real upstream. It applies to the serve command, which
+// writes the cassette when it shuts down.
+type VCRConfig struct {
+	// Cassette is the file interactions are recorded to or replayed from. Leave it empty to
+	// disable the VCR.
+	Cassette string `json:"cassette"`
+	// Mode is replay, record or passthrough.
+	Mode string `json:"mode"`
+	// Overwrite lets record mode replace an existing cassette.
+	Overwrite bool `json:"overwrite"`
+}
+
+// DefaultVCRConfig returns VCR settings that leave the upstream client alone until a cassette is set.
+func DefaultVCRConfig() VCRConfig {
+	return VCRConfig{
+		Mode: vcr.ModeReplay.String(),
+	}
+}
+
+// newRecorder returns the vcr.Recorder described by cfg, or nil when no cassette is set.
+func newRecorder(cfg VCRConfig) (*vcr.Recorder, error) {
+	if cfg.Cassette == "" {
+		return nil, nil
+	}
+	mode, err := vcr.ParseMode(cfg.Mode)
+	if err != nil {
+		return nil, err
+	}
+	var opts []vcr.Option
+	if cfg.Overwrite {
+		opts = append(opts, vcr.WithOverwrite())
+	}
+	return vcr.New(cfg.Cassette, mode, opts...)
+}
+
+// userAgentTransport sets the User-Agent header on outgoing requests.
+type userAgentTransport struct {
+	next      http.RoundTripper
+	userAgent string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.next.RoundTrip(req)
+}
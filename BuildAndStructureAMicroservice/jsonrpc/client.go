@@ -0,0 +1,364 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"BuildAndStructureAMicroservice/catfact"
+)
+
+// ErrClientClosed is returned by the calls of a TCP Client whose connection is closed.
+var ErrClientClosed = errors.New("jsonrpc: client closed")
+
+// Call is a single call of a batch. Once the batch is done, Result holds the result of the
+// call, or Error its error.
+type Call struct {
+	Method string
+	// Params are the parameters of the call, given by name with a struct or a map, or by
+	// position with a slice. Nil means none.
+	Params interface{}
+	// Result is decoded from the result of the call, unless nil.
+	Result interface{}
+	Error  error
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithHTTPClient sets the HTTP client used by a Client created with NewHTTPClient.
+func WithHTTPClient(hc *http.Client) ClientOption {
+	return func(c *Client) {
+		if t, ok := c.transport.(*httpTransport); ok {
+			t.client = hc
+		}
+	}
+}
+
+// Client calls a Service served by a Server, over HTTP or TCP. A *Client implements
+// catfact.Service. It is safe for concurrent use; on TCP, concurrent calls share the
+// connection.
+type Client struct {
+	transport transport
+	nextID    atomic.Uint64
+}
+
+// transport carries a message to the server and returns the responses to the requests ids.
+type transport interface {
+	roundTrip(ctx context.Context, msg []byte, ids []uint64) ([]*Response, error)
+	close() error
+}
+
+// NewHTTPClient returns a Client POSTing its calls to url, such as http://localhost:3001/rpc.
+func NewHTTPClient(url string, opts ...ClientOption) *Client {
+	c := &Client{transport: &httpTransport{url: url, client: http.DefaultClient}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Dial connects to the TCP Server listening at addr and returns a Client using the connection.
+func Dial(addr string, opts ...ClientOption) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	t := &tcpTransport{conn: conn, pending: make(map[uint64]chan *Response), done: make(chan struct{})}
+	go t.read()
+
+	c := &Client{transport: t}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Close closes the connection of a TCP Client. It does nothing over HTTP.
+func (c *Client) Close() error {
+	return c.transport.close()
+}
+
+// GetCatFact fetches a random cat fact.
+func (c *Client) GetCatFact(ctx context.Context) (*catfact.CatFact, error) {
+	fact := &catfact.CatFact{}
+	if err := c.Call(ctx, MethodGetCatFact, nil, fact); err != nil {
+		return nil, err
+	}
+	return fact, nil
+}
+
+// ListFacts fetches a page of cat facts. Zero limit and maxLength leave the choice to the server.
+func (c *Client) ListFacts(ctx context.Context, page, limit, maxLength int) (*catfact.FactPage, error) {
+	facts := &catfact.FactPage{}
+	params := map[string]int{"page": page, "limit": limit, "max_length": maxLength}
+	if err := c.Call(ctx, MethodListFacts, params, facts); err != nil {
+		return nil, err
+	}
+	return facts, nil
+}
+
+// ListBreeds fetches a page of cat breeds. Zero limit leaves the choice to the server.
+func (c *Client) ListBreeds(ctx context.Context, page, limit int) (*catfact.BreedPage, error) {
+	breeds := &catfact.BreedPage{}
+	params := map[string]int{"page": page, "limit": limit}
+	if err := c.Call(ctx, MethodListBreeds, params, breeds); err != nil {
+		return nil, err
+	}
+	return breeds, nil
+}
+
+// Call calls method with params and decodes its result into result, unless nil. Errors
+// answered by the server are returned as *Error.
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	call := &Call{Method: method, Params: params, Result: result}
+	if err := c.Batch(ctx, call); err != nil {
+		return err
+	}
+	return call.Error
+}
+
+// Batch sends calls as a single batch. It returns an error when the batch as a whole failed;
+// otherwise the outcome of every call is in its Result or Error.
+func (c *Client) Batch(ctx context.Context, calls ...*Call) error {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	requests := make([]Request, len(calls))
+	ids := make([]uint64, len(calls))
+	for i, call := range calls {
+		ids[i] = c.nextID.Add(1)
+		requests[i] = Request{JSONRPC: Version, Method: call.Method, ID: json.RawMessage(strconv.FormatUint(ids[i], 10))}
+		if call.Params != nil {
+			params, err := json.Marshal(call.Params)
+			if err != nil {
+				return fmt.Errorf("jsonrpc: params of %s: %w", call.Method, err)
+			}
+			requests[i].Params = params
+		}
+	}
+
+	var (
+		msg []byte
+		err error
+	)
+	if len(requests) == 1 {
+		msg, err = json.Marshal(requests[0])
+	} else {
+		msg, err = json.Marshal(requests)
+	}
+	if err != nil {
+		return err
+	}
+
+	responses, err := c.transport.roundTrip(ctx, msg, ids)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[uint64]*Response, len(responses))
+	for _, resp := range responses {
+		if id, err := strconv.ParseUint(string(resp.ID), 10, 64); err == nil {
+			byID[id] = resp
+		} else if resp.Error != nil {
+			// The server couldn't tell which request failed, so the whole batch did.
+			return resp.Error
+		}
+	}
+	for i, call := range calls {
+		resp, ok := byID[ids[i]]
+		switch {
+		case !ok:
+			call.Error = fmt.Errorf("jsonrpc: no response to %s", call.Method)
+		case resp.Error != nil:
+			call.Error = resp.Error
+		case call.Result != nil:
+			if err := json.Unmarshal(resp.Result, call.Result); err != nil {
+				call.Error = fmt.Errorf("jsonrpc: result of %s: %w", call.Method, err)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeResponses decodes a single response or a batch of them.
+func decodeResponses(msg []byte) ([]*Response, error) {
+	var responses []*Response
+	if isBatch(msg) {
+		if err := json.Unmarshal(msg, &responses); err != nil {
+			return nil, err
+		}
+		return responses, nil
+	}
+	resp := &Response{}
+	if err := json.Unmarshal(msg, resp); err != nil {
+		return nil, err
+	}
+	return append(responses, resp), nil
+}
+
+// httpTransport POSTs every message to a URL.
+type httpTransport struct {
+	url    string
+	client *http.Client
+}
+
+func (t *httpTransport) roundTrip(ctx context.Context, msg []byte, ids []uint64) ([]*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if id := catfact.RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(catfact.RequestIDHeader, id)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jsonrpc: unexpected status %s", resp.Status)
+	}
+	return decodeResponses(body)
+}
+
+func (t *httpTransport) close() error {
+	return nil
+}
+
+// tcpTransport multiplexes calls over a single connection, matching responses to the
+// pending requests by ID.
+type tcpTransport struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint64]chan *Response
+	err     error
+	done    chan struct{}
+}
+
+func (t *tcpTransport) roundTrip(ctx context.Context, msg []byte, ids []uint64) ([]*Response, error) {
+	channels := make([]chan *Response, len(ids))
+	t.mu.Lock()
+	if t.err != nil {
+		t.mu.Unlock()
+		return nil, t.err
+	}
+	for i, id := range ids {
+		channels[i] = make(chan *Response, 1)
+		t.pending[id] = channels[i]
+	}
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		for _, id := range ids {
+			delete(t.pending, id)
+		}
+		t.mu.Unlock()
+	}()
+
+	t.writeMu.Lock()
+	_, err := t.conn.Write(append(msg, '\n'))
+	t.writeMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]*Response, 0, len(ids))
+	for _, ch := range channels {
+		select {
+		case resp := <-ch:
+			responses = append(responses, resp)
+		case <-t.done:
+			return nil, t.failure()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return responses, nil
+}
+
+// read dispatches the responses read from the connection until it fails.
+func (t *tcpTransport) read() {
+	dec := json.NewDecoder(bufio.NewReader(t.conn))
+	for {
+		var msg json.RawMessage
+		if err := dec.Decode(&msg); err != nil {
+			t.fail(err)
+			return
+		}
+		responses, err := decodeResponses(msg)
+		if err != nil {
+			t.fail(err)
+			return
+		}
+		for _, resp := range responses {
+			id, err := strconv.ParseUint(string(resp.ID), 10, 64)
+			if err != nil {
+				// Only a message the server couldn't read has no ID, which means this
+				// connection is out of sync.
+				if resp.Error != nil {
+					err = resp.Error
+				}
+				t.fail(err)
+				return
+			}
+			t.mu.Lock()
+			ch := t.pending[id]
+			t.mu.Unlock()
+			if ch != nil {
+				select {
+				case ch <- resp:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// fail fails the pending and future calls with err.
+func (t *tcpTransport) fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return
+	}
+	if err == nil || errors.Is(err, net.ErrClosed) {
+		err = ErrClientClosed
+	}
+	t.err = err
+	close(t.done)
+	t.conn.Close()
+}
+
+// failure returns the error the connection failed with.
+func (t *tcpTransport) failure() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+func (t *tcpTransport) close() error {
+	err := t.conn.Close()
+	t.fail(ErrClientClosed)
+	return err
+}
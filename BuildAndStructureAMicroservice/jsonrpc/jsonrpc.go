@@ -0,0 +1,174 @@
+// Package jsonrpc serves a catfact.Service over JSON-RPC 2.0, on HTTP and on raw TCP
+// connections, and provides a Client implementing catfact.Service over either transport.
+//
+// Every Service method is exposed under the name of the interface and the method, such as
+// Service.ListFacts. Parameters are given by name, as in {"page": 2, "limit": 10}, or by
+// position, as in [2, 10]. On TCP, messages are JSON values sent one after the other,
+// usually one per line.
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"BuildAndStructureAMicroservice/catfact"
+	"BuildAndStructureAMicroservice/client"
+)
+
+// Version is the JSON-RPC version spoken.
+const Version = "2.0"
+
+// Names of the methods exposed.
+const (
+	MethodGetCatFact = "Service.GetCatFact"
+	MethodListFacts  = "Service.ListFacts"
+	MethodListBreeds = "Service.ListBreeds"
+)
+
+// Error codes defined by JSON-RPC 2.0, and the one used for errors of the Service.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	// CodeServiceError is the code of the errors returned by the Service. Their data is the
+	// problem document the REST API would have answered with.
+	CodeServiceError = -32000
+)
+
+// Request is a JSON-RPC request. A request without an ID is a notification, which gets no response.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// Response is a JSON-RPC response, carrying either a result or an error.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Error is a JSON-RPC error. Errors of the Service carry their problem document as data and
+// unwrap to a *client.Error, so they match the same errors as those of the REST client.
+type Error struct {
+	Code    int              `json:"code"`
+	Message string           `json:"message"`
+	Data    *catfact.Problem `json:"data,omitempty"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Data != nil {
+		return e.Data.Error()
+	}
+	return fmt.Sprintf("jsonrpc: %s (%d)", e.Message, e.Code)
+}
+
+// Unwrap returns the problem document of a Service error as a *client.Error.
+func (e *Error) Unwrap() error {
+	if e.Data == nil {
+		return nil
+	}
+	return &client.Error{Problem: *e.Data, RequestID: e.Data.Instance}
+}
+
+// newError returns a JSON-RPC error with the standard message of code.
+func newError(code int, detail string) *Error {
+	messages := map[int]string{
+		CodeParseError:     "Parse error",
+		CodeInvalidRequest: "Invalid Request",
+		CodeMethodNotFound: "Method not found",
+		CodeInvalidParams:  "Invalid params",
+		CodeInternalError:  "Internal error",
+		CodeServiceError:   "Server error",
+	}
+	e := &Error{Code: code, Message: messages[code]}
+	if detail != "" {
+		e.Message += ": " + detail
+	}
+	return e
+}
+
+// validID reports whether id, when given, is a string, a number or null.
+func validID(id json.RawMessage) bool {
+	id = bytes.TrimSpace(id)
+	if len(id) == 0 {
+		return true
+	}
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+// isBatch reports whether msg is a batch, a JSON array.
+func isBatch(msg []byte) bool {
+	msg = bytes.TrimSpace(msg)
+	return len(msg) > 0 && msg[0] == '['
+}
+
+// pageParams are the parameters of the listing methods.
+type pageParams struct {
+	Page      int `json:"page"`
+	Limit     int `json:"limit"`
+	MaxLength int `json:"max_length"`
+}
+
+// decodeParams decodes params given by name or by position into p. names lists the
+// parameters in positional order.
+func decodeParams(raw json.RawMessage, p *pageParams, names ...string) error {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil
+	}
+
+	if raw[0] == '[' {
+		var values []int
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return err
+		}
+		if len(values) > len(names) {
+			return fmt.Errorf("at most %d parameters expected, got %d", len(names), len(values))
+		}
+		fields := map[string]*int{"page": &p.Page, "limit": &p.Limit, "max_length": &p.MaxLength}
+		for i, v := range values {
+			*fields[names[i]] = v
+		}
+		return p.validate()
+	}
+
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &named); err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[name] = true
+	}
+	for name := range named {
+		if !known[name] {
+			return fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	if err := json.Unmarshal(raw, p); err != nil {
+		return err
+	}
+	return p.validate()
+}
+
+// validate checks that no parameter is negative.
+func (p *pageParams) validate() error {
+	for name, v := range map[string]int{"page": p.Page, "limit": p.Limit, "max_length": p.MaxLength} {
+		if v < 0 {
+			return fmt.Errorf("invalid %s %d", name, v)
+		}
+	}
+	return nil
+}
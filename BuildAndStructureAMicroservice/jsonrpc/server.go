@@ -0,0 +1,474 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"BuildAndStructureAMicroservice/catfact"
+)
+
+const (
+	// maxMessageSize bounds the size of a single message, batch included.
+	maxMessageSize = 1 << 20
+	// maxBatchSize bounds the number of requests of a batch.
+	maxBatchSize = 100
+	// maxConcurrency bounds the calls running at once for a batch, and for a TCP connection.
+	maxConcurrency = 8
+	// maxConns bounds the TCP connections served at once; more are closed right away.
+	maxConns = 256
+	// writeTimeout bounds writing a response to a TCP connection.
+	writeTimeout = 10 * time.Second
+	// maxAcceptDelay caps the backoff after temporary accept errors.
+	maxAcceptDelay = time.Second
+)
+
+// errMessageTooLarge is returned when reading a message past maxMessageSize.
+var errMessageTooLarge = errors.New("message too large")
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+// WithErrorStatus sets the function giving the HTTP status of an error of the Service, used
+// for the problem document sent as data of the JSON-RPC error. The default answers 500.
+func WithErrorStatus(status func(error) int) ServerOption {
+	return func(s *Server) {
+		s.errorStatus = status
+	}
+}
+
+// WithTimeout bounds every call to the Service. Zero means no limit besides the context.
+func WithTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.timeout = d
+	}
+}
+
+// WithIdleTimeout closes TCP connections that send nothing for d while none of their calls
+// is running. Zero keeps idle connections open. The default is two minutes.
+func WithIdleTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.idleTimeout = d
+	}
+}
+
+// Server serves a catfact.Service over JSON-RPC 2.0. Handle is the transport-independent
+// core; ServeHTTP and Serve carry its messages over HTTP and TCP.
+type Server struct {
+	svc         catfact.Service
+	errorStatus func(error) int
+	timeout     time.Duration
+	idleTimeout time.Duration
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer returns a Server calling svc.
+func NewServer(svc catfact.Service, opts ...ServerOption) *Server {
+	s := &Server{
+		svc:         svc,
+		errorStatus: func(error) int { return http.StatusInternalServerError },
+		idleTimeout: 2 * time.Minute,
+		listeners:   make(map[net.Listener]struct{}),
+		conns:       make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Handle handles a single message or a batch and returns the response to send back, or nil
+// when there is none, as is the case when every request is a notification. The requests of
+// a batch, up to maxBatchSize, are handled maxConcurrency at a time, and their responses keep
+// the order of the batch.
+func (s *Server) Handle(ctx context.Context, msg []byte) []byte {
+	if !isBatch(msg) {
+		resp := s.handleRaw(ctx, msg)
+		if resp == nil {
+			return nil
+		}
+		out, _ := json.Marshal(resp)
+		return out
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(msg, &batch); err != nil {
+		out, _ := json.Marshal(errorResponse(nil, newError(CodeParseError, "")))
+		return out
+	}
+	if len(batch) == 0 {
+		out, _ := json.Marshal(errorResponse(nil, newError(CodeInvalidRequest, "empty batch")))
+		return out
+	}
+	if len(batch) > maxBatchSize {
+		out, _ := json.Marshal(errorResponse(nil, newError(CodeInvalidRequest, "batch too large")))
+		return out
+	}
+
+	responses := make([]*Response, len(batch))
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxConcurrency)
+	for i, raw := range batch {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, raw json.RawMessage) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			responses[i] = s.handleRaw(ctx, raw)
+		}(i, raw)
+	}
+	wg.Wait()
+
+	results := make([]*Response, 0, len(responses))
+	for _, resp := range responses {
+		if resp != nil {
+			results = append(results, resp)
+		}
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	out, _ := json.Marshal(results)
+	return out
+}
+
+// handleRaw decodes and handles a single request.
+func (s *Server) handleRaw(ctx context.Context, raw []byte) *Response {
+	var req Request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return errorResponse(nil, newError(CodeParseError, ""))
+		}
+		return errorResponse(nil, newError(CodeInvalidRequest, ""))
+	}
+	if req.JSONRPC != Version || req.Method == "" || !validID(req.ID) {
+		return errorResponse(nil, newError(CodeInvalidRequest, ""))
+	}
+
+	result, rpcErr := s.call(ctx, &req)
+	if len(req.ID) == 0 {
+		return nil
+	}
+	if rpcErr != nil {
+		return errorResponse(req.ID, rpcErr)
+	}
+	out, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(req.ID, newError(CodeInternalError, err.Error()))
+	}
+	return &Response{JSONRPC: Version, Result: out, ID: req.ID}
+}
+
+// call calls the Service method named by req.
+func (s *Server) call(ctx context.Context, req *Request) (interface{}, *Error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	var (
+		result interface{}
+		err    error
+		p      = pageParams{Page: 1}
+	)
+	switch req.Method {
+	case MethodGetCatFact:
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, newError(CodeInvalidParams, err.Error())
+		}
+		result, err = s.svc.GetCatFact(ctx)
+	case MethodListFacts:
+		if err := decodeParams(req.Params, &p, "page", "limit", "max_length"); err != nil {
+			return nil, newError(CodeInvalidParams, err.Error())
+		}
+		result, err = s.svc.ListFacts(ctx, p.Page, p.Limit, p.MaxLength)
+	case MethodListBreeds:
+		if err := decodeParams(req.Params, &p, "page", "limit"); err != nil {
+			return nil, newError(CodeInvalidParams, err.Error())
+		}
+		result, err = s.svc.ListBreeds(ctx, p.Page, p.Limit)
+	default:
+		return nil, newError(CodeMethodNotFound, req.Method)
+	}
+	if err != nil {
+		problem := catfact.NewProblem(s.errorStatus(err), err.Error())
+		problem.Instance = catfact.RequestIDFromContext(ctx)
+		rpcErr := newError(CodeServiceError, "")
+		rpcErr.Data = &problem
+		return nil, rpcErr
+	}
+	return result, nil
+}
+
+// errorResponse returns the response carrying err for the request id.
+func errorResponse(id json.RawMessage, err *Error) *Response {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &Response{JSONRPC: Version, Error: err, ID: id}
+}
+
+// ServeHTTP handles a message POSTed over HTTP. Requests are answered with 200 and the
+// response, or with 204 when there is none.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	if catfact.RequestIDFromContext(ctx) == "" {
+		id := r.Header.Get(catfact.RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = catfact.NewRequestID()
+		}
+		w.Header().Set(catfact.RequestIDHeader, id)
+		ctx = catfact.WithRequestID(ctx, id)
+	}
+
+	msg, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		out, _ := json.Marshal(errorResponse(nil, newError(CodeInvalidRequest, err.Error())))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(out)
+		return
+	}
+
+	out := s.Handle(ctx, msg)
+	if out == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(out, '\n'))
+}
+
+// Serve accepts TCP connections on ln and serves JSON-RPC messages on each of them, one JSON
+// value after the other. Responses are written one per line as soon as they are ready, so they
+// may come back in a different order than the requests. Up to maxConns connections are served
+// at once. Temporary accept errors are retried after a pause growing up to maxAcceptDelay.
+// Serve returns net.ErrClosed once Close has been called.
+func (s *Server) Serve(ln net.Listener) error {
+	if !s.track(ln, nil, true) {
+		ln.Close()
+		return net.ErrClosed
+	}
+	defer s.track(ln, nil, false)
+
+	var tempDelay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return net.ErrClosed
+			}
+			// Like net/http, ride out temporary errors such as running out of file
+			// descriptors rather than stop serving.
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if tempDelay > maxAcceptDelay {
+					tempDelay = maxAcceptDelay
+				}
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+		added, full := s.trackConn(conn)
+		if !added {
+			conn.Close()
+			if full {
+				continue
+			}
+			return net.ErrClosed
+		}
+		go func() {
+			defer s.wg.Done()
+			defer s.track(nil, conn, false)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn serves the messages of a single connection until it is closed, stays idle for
+// too long, or sends something that isn't JSON or is too large.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		writeMu sync.Mutex
+		calls   sync.WaitGroup
+		slots   = make(chan struct{}, maxConcurrency)
+	)
+	write := func(out []byte) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		conn.Write(append(out, '\n'))
+	}
+
+	in := &connReader{conn: conn, idle: s.idleTimeout}
+	dec := json.NewDecoder(in)
+	for {
+		in.budget = maxMessageSize
+		var msg json.RawMessage
+		if err := dec.Decode(&msg); err != nil {
+			// Calls made before a bad message still get their responses; those of a peer
+			// that went away, or of a closed server, are cancelled.
+			var syntaxErr *json.SyntaxError
+			switch {
+			case errors.Is(err, errMessageTooLarge):
+				out, _ := json.Marshal(errorResponse(nil, newError(CodeInvalidRequest, err.Error())))
+				write(out)
+			case errors.As(err, &syntaxErr):
+				out, _ := json.Marshal(errorResponse(nil, newError(CodeParseError, "")))
+				write(out)
+			default:
+				cancel()
+			}
+			break
+		}
+
+		// Stop reading while maxConcurrency calls are running.
+		slots <- struct{}{}
+		in.running.Add(1)
+		calls.Add(1)
+		go func(msg []byte) {
+			defer calls.Done()
+			defer in.running.Add(-1)
+			defer func() { <-slots }()
+			reqCtx := catfact.WithRequestID(ctx, catfact.NewRequestID())
+			if out := s.Handle(reqCtx, msg); out != nil {
+				write(out)
+			}
+		}(msg)
+	}
+	calls.Wait()
+}
+
+// connReader reads the messages of a TCP connection. It fails reads once budget bytes have
+// been read, so serveConn bounds every message by resetting budget before reading it, and
+// gives up on a connection idle for longer than idle unless some of its calls are running.
+type connReader struct {
+	conn    net.Conn
+	idle    time.Duration
+	budget  int64
+	running atomic.Int64
+}
+
+// Read implements io.Reader.
+func (r *connReader) Read(p []byte) (int, error) {
+	if r.budget <= 0 {
+		return 0, errMessageTooLarge
+	}
+	if int64(len(p)) > r.budget {
+		p = p[:r.budget]
+	}
+	for {
+		if r.idle > 0 {
+			r.conn.SetReadDeadline(time.Now().Add(r.idle))
+		}
+		n, err := r.conn.Read(p)
+		r.budget -= int64(n)
+
+		var netErr net.Error
+		if n == 0 && errors.As(err, &netErr) && netErr.Timeout() && r.running.Load() > 0 {
+			// Waiting on our own calls isn't being idle.
+			continue
+		}
+		return n, err
+	}
+}
+
+// trackConn adds conn to the connections closed by Close, counting it among those Close waits
+// for. It reports whether conn was added, and when it wasn't, whether it is because maxConns
+// connections are already served rather than because Close was called.
+func (s *Server) trackConn(conn net.Conn) (added, full bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.closed:
+		return false, false
+	case len(s.conns) >= maxConns:
+		return false, true
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true, false
+}
+
+// track adds or removes a listener from those closed by Close, or removes a connection added
+// by trackConn. It reports false when adding after Close.
+func (s *Server) track(ln net.Listener, conn net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add && s.closed {
+		return false
+	}
+	switch {
+	case ln != nil && add:
+		s.listeners[ln] = struct{}{}
+	case ln != nil:
+		delete(s.listeners, ln)
+	default:
+		delete(s.conns, conn)
+	}
+	return true
+}
+
+// isClosed reports whether Close has been called.
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Close closes the listeners of Serve and every open connection, then waits for the
+// connections to be done.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	for ln := range s.listeners {
+		if closeErr := ln.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
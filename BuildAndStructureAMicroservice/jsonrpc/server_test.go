@@ -0,0 +1,202 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"BuildAndStructureAMicroservice/catfact"
+)
+
+// blockingService answers GetCatFact after delay, unless the context is done first, and
+// reports the error of the context of every call it gave up on.
+type blockingService struct {
+	catfact.Service
+	delay     time.Duration
+	cancelled chan error
+}
+
+func (s *blockingService) GetCatFact(ctx context.Context) (*catfact.CatFact, error) {
+	select {
+	case <-time.After(s.delay):
+		return &catfact.CatFact{Fact: "Cats purr."}, nil
+	case <-ctx.Done():
+		s.cancelled <- ctx.Err()
+		return nil, ctx.Err()
+	}
+}
+
+// serve starts s on a local listener and returns its address.
+func serve(t *testing.T, s *Server) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	return ln.Addr().String()
+}
+
+const getCatFact = `{"jsonrpc":"2.0","method":"Service.GetCatFact","id":1}` + "\n"
+
+func TestHandleRejectsLargeBatch(t *testing.T) {
+	s := NewServer(&blockingService{cancelled: make(chan error, 1)})
+
+	batch := "[" + strings.TrimSuffix(strings.Repeat(`{"jsonrpc":"2.0","method":"Service.GetCatFact","id":1},`, maxBatchSize+1), ",") + "]"
+	var resp Response
+	if err := json.Unmarshal(s.Handle(context.Background(), []byte(batch)), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == nil || resp.Error.Code != CodeInvalidRequest {
+		t.Errorf("Expected an invalid request error but got %+v", resp)
+	}
+}
+
+func TestConnReaderBoundsMessages(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go client.Write([]byte(strings.Repeat("x", 20)))
+
+	r := &connReader{conn: server, budget: 10}
+	buf := make([]byte, 64)
+	var read int
+	var err error
+	for err == nil {
+		var n int
+		n, err = r.Read(buf)
+		read += n
+	}
+	if read != 10 || !errors.Is(err, errMessageTooLarge) {
+		t.Errorf("Expected to read 10 bytes then %v but got %d and %v", errMessageTooLarge, read, err)
+	}
+}
+
+func TestServeCancelsCallsOfGonePeer(t *testing.T) {
+	svc := &blockingService{delay: time.Minute, cancelled: make(chan error, 1)}
+	addr := serve(t, NewServer(svc))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte(getCatFact)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-svc.cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the call to be cancelled but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the call to be cancelled once the peer left")
+	}
+}
+
+func TestServeClosesIdleConnections(t *testing.T) {
+	svc := &blockingService{delay: 150 * time.Millisecond, cancelled: make(chan error, 1)}
+	addr := serve(t, NewServer(svc, WithIdleTimeout(50*time.Millisecond)))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// A call outlasting the idle timeout keeps the connection open until it answers.
+	if _, err := conn.Write([]byte(getCatFact)); err != nil {
+		t.Fatal(err)
+	}
+	lines := bufio.NewReader(conn)
+	line, err := lines.ReadString('\n')
+	if err != nil || !strings.Contains(line, "Cats purr.") {
+		t.Fatalf("Expected the fact but got %q and %v", line, err)
+	}
+
+	// Then the idle connection is closed.
+	start := time.Now()
+	if _, err := lines.ReadString('\n'); err == nil {
+		t.Fatal("Expected the connection to be closed")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Expected the idle connection to be closed quickly but it took %v", took)
+	}
+}
+
+// tempError is a temporary accept error, such as running out of file descriptors.
+type tempError struct{}
+
+func (tempError) Error() string   { return "too many open files" }
+func (tempError) Timeout() bool   { return false }
+func (tempError) Temporary() bool { return true }
+
+// flakyListener fails its first failures accepts with a temporary error.
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, tempError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestServeRidesOutTemporaryAcceptErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(&blockingService{cancelled: make(chan error, 1)})
+	defer s.Close()
+	go s.Serve(&flakyListener{Listener: ln, failures: 3})
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(getCatFact)); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || !strings.Contains(line, "Cats purr.") {
+		t.Errorf("Expected the fact but got %q and %v", line, err)
+	}
+}
+
+func TestServeHTTPBodyErrors(t *testing.T) {
+	s := NewServer(&blockingService{cancelled: make(chan error, 1)})
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		code int
+	}{
+		{"too large", httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat(" ", maxMessageSize+1))), http.StatusRequestEntityTooLarge},
+		{"broken body", httptest.NewRequest(http.MethodPost, "/", iotest.ErrReader(errors.New("connection reset"))), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, tt.r)
+		if w.Code != tt.code {
+			t.Errorf("%s: Expected %d but got %d", tt.name, tt.code, w.Code)
+		}
+	}
+}
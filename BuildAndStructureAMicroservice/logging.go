@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"time"
 )
 
@@ -21,8 +20,38 @@ func NewLoggingService(next Service) Service {
 // GetCatFact retrieves a cat fact and logs the execution time and any errors.
 func (s *LoggingService) GetCatFact(ctx context.Context) (fact *CatFact, err error) {
 	defer func(start time.Time) {
-		fmt.Printf("fact=%v err=%s took=%v\n", fact.Fact, err, time.Since(start))
+		var text string
+		if fact != nil {
+			text = fact.Fact
+		}
+		logf(levelFor(err), "fact=%v err=%v took=%v", text, err, time.Since(start))
 	}(time.Now())
 
 	return s.next.GetCatFact(ctx)
 }
+
+// ListFacts retrieves a page of cat facts and logs the execution time and any errors.
+func (s *LoggingService) ListFacts(ctx context.Context, page, limit, maxLength int) (facts *FactPage, err error) {
+	defer func(start time.Time) {
+		logf(levelFor(err), "method=ListFacts page=%d limit=%d max_length=%d err=%v took=%v", page, limit, maxLength, err, time.Since(start))
+	}(time.Now())
+
+	return s.next.ListFacts(ctx, page, limit, maxLength)
+}
+
+// ListBreeds retrieves a page of cat breeds and logs the execution time and any errors.
+func (s *LoggingService) ListBreeds(ctx context.Context, page, limit int) (breeds *BreedPage, err error) {
+	defer func(start time.Time) {
+		logf(levelFor(err), "method=ListBreeds page=%d limit=%d err=%v took=%v", page, limit, err, time.Since(start))
+	}(time.Now())
+
+	return s.next.ListBreeds(ctx, page, limit)
+}
+
+// levelFor returns the level a call ending with err is logged at.
+func levelFor(err error) LogLevel {
+	if err != nil {
+		return LevelError
+	}
+	return LevelInfo
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// LogLevel controls which service log lines are written.
+type LogLevel int32
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// String implements fmt.Stringer.
+func (l LogLevel) String() string {
+	if l >= 0 && int(l) < len(logLevelNames) {
+		return logLevelNames[l]
+	}
+	return fmt.Sprintf("LogLevel(%d)", int32(l))
+}
+
+// ParseLogLevel parses the name of a LogLevel, as returned by LogLevel.String.
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// logLevel is the current log level, adjustable at runtime.
+var logLevel atomic.Int32
+
+func init() {
+	logLevel.Store(int32(LevelInfo))
+}
+
+// SetLogLevel changes the log level.
+func SetLogLevel(l LogLevel) {
+	logLevel.Store(int32(l))
+}
+
+// CurrentLogLevel returns the log level.
+func CurrentLogLevel() LogLevel {
+	return LogLevel(logLevel.Load())
+}
+
+// logOutput is where log lines are written, stdout unless changed with SetLogOutput.
+var logOutput = struct {
+	sync.Mutex
+	w io.Writer
+}{w: os.Stdout}
+
+// SetLogOutput changes where log lines are written and returns the previous writer.
+func SetLogOutput(w io.Writer) io.Writer {
+	logOutput.Lock()
+	defer logOutput.Unlock()
+
+	prev := logOutput.w
+	logOutput.w = w
+	return prev
+}
+
+// logf prints a log line when level is enabled.
+func logf(level LogLevel, format string, args ...interface{}) {
+	if level < CurrentLogLevel() {
+		return
+	}
+	logOutput.Lock()
+	defer logOutput.Unlock()
+
+	fmt.Fprintf(logOutput.w, "level=%s "+format+"\n", append([]interface{}{level}, args...)...)
+}
@@ -1,26 +1,211 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
 )
 
 func main() {
-	// Create a new instance of CatFactService with the provided URL.
-	svc := NewCatFactService("https://catfact.ninja/fact")
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// serve runs the fact service described by cfg until ctx is cancelled, then returns once
+// the requests in flight have been answered and the servers are shut down.
+func serve(ctx context.Context, cfg Config) error {
+	var err error
+	if cfg.AdminAddr != "" && cfg.AdminToken == "" {
+		// Never leave the admin API unprotected: make up a token for this run, and write it
+		// to a file only the operator can read rather than to the logs.
+		if cfg.AdminToken, err = generateToken(); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(cfg.AdminTokenFile, []byte(cfg.AdminToken+"\n")); err != nil {
+			return fmt.Errorf("admin token: %w", err)
+		}
+		log.Printf("no admin token configured, wrote one for this run to %s", cfg.AdminTokenFile)
+	}
+
+	// Record or replay the upstream traffic when a cassette is configured.
+	recorder, err := newRecorder(cfg.VCR)
+	if err != nil {
+		return fmt.Errorf("vcr: %w", err)
+	}
+	if recorder != nil {
+		cfg.HTTPClient.WrapTransport = recorder.Wrap
+	}
+
+	// Build the outbound HTTP client and the decorated service talking to the upstream API.
+	stack, err := buildStack(cfg)
+	if err != nil {
+		return err
+	}
+	logStack(stack)
 
-	// Wrap the service with LoggingService to log execution time and errors.
-	svc = NewLoggingService(svc)
+	opts := []ApiOption{WithRequestTimeout(cfg.RequestTimeout)}
+	if cfg.TLS.Enabled() {
+		// Certificates are reloaded from disk when they change, so they can be rotated live.
+		reloader, err := NewTLSReloader(cfg.TLS)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, WithTLS(reloader))
+	}
+	if cfg.AccessLog.Path != "" {
+		accessLog, err := NewAccessLog(cfg.AccessLog)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, WithAccessLog(accessLog))
+	}
+	var tracer *Tracer
+	if cfg.TracingEnabled {
+		if tracer, err = NewTracer(cfg.Tracing); err != nil {
+			return err
+		}
+		opts = append(opts, WithTracer(tracer))
+	}
+	if cfg.DashboardEnabled {
+		opts = append(opts, WithDashboard(NewDashboard(stack, cfg.UpstreamURL, cfg.Dashboard)))
+	}
+	var prefetcher *Prefetcher
+	if cfg.PrefetchEnabled {
+		// Keep a pool of unused facts warm so the first requests don't wait on the upstream.
+		if prefetcher, err = NewPrefetcher(stack, cfg.Prefetch); err != nil {
+			return fmt.Errorf("prefetch: %w", err)
+		}
+		opts = append(opts, WithPrefetcher(prefetcher))
+	}
 
-	// fact, err := svc.GetCatFact(context.TODO())
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
+	var webhooks *Webhooks
+	if cfg.WebhooksEnabled {
+		// Push new facts to the URLs registered on the admin API instead of having teams poll.
+		if webhooks, err = NewWebhooks(stack, cfg.Webhooks); err != nil {
+			return err
+		}
+		opts = append(opts, WithWebhooks(webhooks))
+	}
 
-	// fmt.Printf("%+v\n", fact)
+	if cfg.SchedulerEnabled {
+		// Publish the fact of the day, and any other scheduled fact, once per slot.
+		scheduler, err := NewScheduler(stack, cfg.Scheduler)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, WithScheduler(scheduler))
+	}
 
 	// Create a new instance of ApiServer with the wrapped service.
-	apiServer := NewApiServer(svc)
+	apiServer := NewApiServer(stack, opts...)
+
+	// Serve the admin API on its own listener.
+	var adminServer *AdminServer
+	if cfg.AdminAddr != "" {
+		adminServer = NewAdminServer(cfg, stack, prefetcher, webhooks, tracer)
+		go func() {
+			if err := adminServer.Start(cfg.AdminAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// Serve the same stack over JSON-RPC, on HTTP and raw TCP, for consumers that don't speak REST.
+	var rpcServer *RPCServer
+	if cfg.RPCAddr != "" || cfg.RPCTCPAddr != "" {
+		rpcServer = NewRPCServer(stack, cfg.RequestTimeout)
+	}
+	if cfg.RPCAddr != "" {
+		go func() {
+			if err := rpcServer.StartHTTP(cfg.RPCAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if cfg.RPCTCPAddr != "" {
+		go func() {
+			if err := rpcServer.StartTCP(cfg.RPCTCPAddr); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// Shut the servers down once we receive a signal. Start returns as soon as the shutdown
+	// begins, so wait for it to finish before the requests in flight are cut off.
+	done := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if rpcServer != nil {
+			if err := rpcServer.Shutdown(shutdownCtx); err != nil {
+				log.Println(err)
+			}
+		}
+		if adminServer != nil {
+			if err := adminServer.Shutdown(shutdownCtx); err != nil {
+				log.Println(err)
+			}
+		}
+		done <- apiServer.Shutdown(shutdownCtx)
+	}()
+
+	// Start the API server and report any errors.
+	if err := apiServer.Start(cfg.ListenAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	err = <-done
+
+	// Write the recorded interactions only now that no upstream call is left running.
+	if recorder != nil {
+		if saveErr := recorder.Save(); saveErr != nil {
+			log.Println(saveErr)
+		}
+	}
+	return err
+}
+
+// buildStack builds the service stack described by cfg, over an outbound HTTP client of its own.
+func buildStack(cfg Config) (*Stack, error) {
+	client, err := NewHTTPClient(cfg.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a new instance of CatFactService talking to the upstream API.
+	svc := NewCatFactService(cfg.UpstreamURL, WithHTTPClient(client))
+
+	// Wrap the service in the decorators listed in the config, such as logging and caching.
+	stack, err := BuildStack(svc, cfg.Decorators)
+	if err != nil {
+		return nil, err
+	}
+	// Policy rules are loaded when the stack is built, so a broken rules file is caught at startup.
+	for _, layer := range stack.Layers {
+		if policy, ok := layer.Service.(*PolicyService); ok {
+			if err := policy.Err(); err != nil {
+				return nil, fmt.Errorf("policy: %w", err)
+			}
+		}
+	}
+	return stack, nil
+}
 
-	// Start the API server and log any errors.
-	log.Fatal(apiServer.Start(":3000"))
+// logStack logs the effective decorator stack and the options of each decorator.
+func logStack(stack *Stack) {
+	log.Printf("service stack: %s", stack)
+	specs, err := stack.Describe()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, spec := range specs {
+		if spec.Options != nil {
+			log.Printf("decorator %s: %s", spec.Name, spec.Options)
+		}
+	}
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"BuildAndStructureAMicroservice/fakeupstream"
+	"BuildAndStructureAMicroservice/vcr"
+)
+
+// testServeConfig returns a config serving on a free local port in front of upstream.
+func testServeConfig(t *testing.T, upstream *fakeupstream.Server) Config {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := DefaultConfig()
+	cfg.ListenAddr = addr
+	cfg.UpstreamURL = upstream.URL
+	cfg.AdminAddr = ""
+	cfg.PrefetchEnabled = false
+	cfg.Decorators = nil
+	return cfg
+}
+
+// waitListening waits for a server to listen at addr.
+func waitListening(t *testing.T, addr string) {
+	t.Helper()
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	t.Fatalf("Expected a server listening at %s", addr)
+}
+
+func TestServeWaitsForRequestsInFlight(t *testing.T) {
+	upstream := fakeupstream.New()
+	defer upstream.Close()
+	const latency = 300 * time.Millisecond
+	upstream.SetFault(fakeupstream.PathFact, fakeupstream.Fault{Latency: latency})
+	cfg := testServeConfig(t, upstream)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, cfg) }()
+	waitListening(t, cfg.ListenAddr)
+
+	start := time.Now()
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + cfg.ListenAddr + "/")
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	for upstream.Requests(fakeupstream.PathFact) == 0 && time.Since(start) < latency {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected serve to return")
+	}
+	if took := time.Since(start); took < latency {
+		t.Errorf("Expected serve to wait for the request in flight but it returned after %v", took)
+	}
+	if code := <-status; code != http.StatusOK {
+		t.Errorf("Expected the request in flight to complete with 200 but got %d", code)
+	}
+}
+
+func TestServeSavesInteractionsOfRequestsInFlight(t *testing.T) {
+	upstream := fakeupstream.New()
+	defer upstream.Close()
+	upstream.SetFault(fakeupstream.PathFact, fakeupstream.Fault{Latency: 200 * time.Millisecond})
+	cfg := testServeConfig(t, upstream)
+	cfg.VCR = VCRConfig{Cassette: filepath.Join(t.TempDir(), "cassette.json"), Mode: "record"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, cfg) }()
+	waitListening(t, cfg.ListenAddr)
+
+	go http.Get("http://" + cfg.ListenAddr + "/")
+	for start := time.Now(); upstream.Requests(fakeupstream.PathFact) == 0 && time.Since(start) < time.Second; {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(cfg.VCR.Cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cassette vcr.Cassette
+	if err := json.Unmarshal(raw, &cassette); err != nil {
+		t.Fatal(err)
+	}
+	if len(cassette.Interactions) != 1 {
+		t.Errorf("Expected the interaction of the request in flight to be saved but got %d", len(cassette.Interactions))
+	}
+}
+
+func TestServeFlushesAccessLog(t *testing.T) {
+	upstream := fakeupstream.New()
+	defer upstream.Close()
+	upstream.SetFault(fakeupstream.PathFact, fakeupstream.Fault{Latency: 200 * time.Millisecond})
+	cfg := testServeConfig(t, upstream)
+	cfg.AccessLog.Path = filepath.Join(t.TempDir(), "access.log")
+	cfg.AccessLog.Format = AccessLogJSON
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, cfg) }()
+	waitListening(t, cfg.ListenAddr)
+
+	for i := 0; i < 5; i++ {
+		resp, err := http.Get("http://" + cfg.ListenAddr + "/openapi.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	go http.Get("http://" + cfg.ListenAddr + "/")
+	for start := time.Now(); upstream.Requests(fakeupstream.PathFact) == 0 && time.Since(start) < time.Second; {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(cfg.AccessLog.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(raw), "\n"); lines != 6 {
+		t.Errorf("Expected the 6 entries to be written by the shutdown but got %d:\n%s", lines, raw)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// MetricsConfig holds the settings of a MetricsService.
+type MetricsConfig struct {
+	// Name is the expvar name the metrics are published under, at /debug/vars of the admin API.
+	Name string `json:"name"`
+}
+
+// DefaultMetricsConfig returns the default metrics settings.
+func DefaultMetricsConfig() MetricsConfig {
+	return MetricsConfig{
+		Name: "service",
+	}
+}
+
+// MethodStats holds the counters of a single Service method.
+type MethodStats struct {
+	Calls        int64         `json:"calls"`
+	Errors       int64         `json:"errors"`
+	TotalLatency time.Duration `json:"total_latency_ns"`
+	MaxLatency   time.Duration `json:"max_latency_ns"`
+}
+
+// MetricsService is a service wrapper that counts calls, errors and latency per method.
+type MetricsService struct {
+	next Service
+
+	mu    sync.Mutex
+	stats map[string]*MethodStats
+}
+
+// NewMetricsService creates a new instance of MetricsService with the provided underlying Service
+// and publishes its counters with expvar. Only the first service using a given name is published.
+func NewMetricsService(next Service, cfg MetricsConfig) *MetricsService {
+	s := &MetricsService{
+		next:  next,
+		stats: make(map[string]*MethodStats),
+	}
+	if expvar.Get(cfg.Name) == nil {
+		expvar.Publish(cfg.Name, expvar.Func(func() interface{} { return s.Snapshot() }))
+	}
+	return s
+}
+
+// GetCatFact retrieves a cat fact and records its metrics.
+func (s *MetricsService) GetCatFact(ctx context.Context) (fact *CatFact, err error) {
+	defer s.observe("GetCatFact", time.Now(), &err)
+
+	return s.next.GetCatFact(ctx)
+}
+
+// ListFacts retrieves a page of cat facts and records its metrics.
+func (s *MetricsService) ListFacts(ctx context.Context, page, limit, maxLength int) (facts *FactPage, err error) {
+	defer s.observe("ListFacts", time.Now(), &err)
+
+	return s.next.ListFacts(ctx, page, limit, maxLength)
+}
+
+// ListBreeds retrieves a page of cat breeds and records its metrics.
+func (s *MetricsService) ListBreeds(ctx context.Context, page, limit int) (breeds *BreedPage, err error) {
+	defer s.observe("ListBreeds", time.Now(), &err)
+
+	return s.next.ListBreeds(ctx, page, limit)
+}
+
+// Snapshot returns a copy of the counters of every method called so far.
+func (s *MetricsService) Snapshot() map[string]MethodStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]MethodStats, len(s.stats))
+	for method, stats := range s.stats {
+		out[method] = *stats
+	}
+	return out
+}
+
+// observe records a call to method that started at start and ended with *err.
+func (s *MetricsService) observe(method string, start time.Time, err *error) {
+	took := time.Since(start)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.stats[method]
+	if !ok {
+		stats = &MethodStats{}
+		s.stats[method] = stats
+	}
+	stats.Calls++
+	if *err != nil {
+		stats.Errors++
+	}
+	stats.TotalLatency += took
+	if took > stats.MaxLatency {
+		stats.MaxLatency = took
+	}
+}
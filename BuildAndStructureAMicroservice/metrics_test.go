@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+)
+
+func TestMetricsCounters(t *testing.T) {
+	upstream := &flakyService{failures: 1, err: errors.New("upstream down")}
+	metrics := NewMetricsService(&slowListService{flakyService: upstream}, MetricsConfig{Name: "metrics_test"})
+
+	metrics.GetCatFact(context.Background())
+	metrics.GetCatFact(context.Background())
+	metrics.ListBreeds(context.Background(), 1, 10)
+
+	stats := metrics.Snapshot()
+	tests := []struct {
+		method        string
+		calls, errors int64
+		minLatency    time.Duration
+	}{
+		{"GetCatFact", 2, 1, 0},
+		{"ListBreeds", 1, 0, 5 * time.Millisecond},
+		{"ListFacts", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		got := stats[tt.method]
+		if got.Calls != tt.calls || got.Errors != tt.errors {
+			t.Errorf("%s: Expected %d calls and %d errors but got %+v", tt.method, tt.calls, tt.errors, got)
+		}
+		if got.TotalLatency < tt.minLatency || got.MaxLatency > got.TotalLatency {
+			t.Errorf("%s: Expected a latency of at least %v within the total but got %+v", tt.method, tt.minLatency, got)
+		}
+	}
+
+	if v := expvar.Get("metrics_test"); v == nil || v.String() == "{}" {
+		t.Error("Expected the counters to be published with expvar")
+	}
+}
+
+// slowListService takes 5ms to list breeds.
+type slowListService struct {
+	*flakyService
+}
+
+func (s *slowListService) ListBreeds(context.Context, int, int) (*BreedPage, error) {
+	time.Sleep(5 * time.Millisecond)
+	return &BreedPage{}, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Middleware wraps a Service with extra behaviour.
+type Middleware func(Service) Service
+
+// Chain composes middlewares so that the first one is the outermost:
+// Chain(a, b, c)(svc) is the same as a(b(c(svc))).
+func Chain(mws ...Middleware) Middleware {
+	return func(svc Service) Service {
+		for i := len(mws) - 1; i >= 0; i-- {
+			svc = mws[i](svc)
+		}
+		return svc
+	}
+}
+
+// Decorator describes a named decorator that can be stacked from configuration.
+type Decorator struct {
+	// Options returns a pointer to the default options, or nil when the decorator takes none.
+	Options func() interface{}
+	// Build returns the Middleware for the options returned by Options, once decoded from config.
+	// It returns an error when the options are invalid.
+	Build func(options interface{}) (Middleware, error)
+}
+
+// decorators is the registry of named decorators that can be stacked from configuration.
+var decorators = map[string]Decorator{
+	"logging": {
+		Build: func(interface{}) (Middleware, error) { return NewLoggingService, nil },
+	},
+	"cache": {
+		Options: func() interface{} { cfg := DefaultCacheConfig(); return &cfg },
+		Build: func(options interface{}) (Middleware, error) {
+			cfg := *options.(*CacheConfig)
+			return func(next Service) Service { return NewCacheService(next, cfg) }, nil
+		},
+	},
+	"retry": {
+		Options: func() interface{} { cfg := DefaultRetryConfig(); return &cfg },
+		Build: func(options interface{}) (Middleware, error) {
+			cfg := *options.(*RetryConfig)
+			if err := cfg.validate(); err != nil {
+				return nil, err
+			}
+			return func(next Service) Service {
+				// The options were validated above.
+				retry, _ := NewRetryService(next, cfg)
+				return retry
+			}, nil
+		},
+	},
+	"metrics": {
+		Options: func() interface{} { cfg := DefaultMetricsConfig(); return &cfg },
+		Build: func(options interface{}) (Middleware, error) {
+			cfg := *options.(*MetricsConfig)
+			return func(next Service) Service { return NewMetricsService(next, cfg) }, nil
+		},
+	},
+	"breaker": {
+		Options: func() interface{} { cfg := DefaultBreakerConfig(); return &cfg },
+		Build: func(options interface{}) (Middleware, error) {
+			cfg := *options.(*BreakerConfig)
+			if err := cfg.validate(); err != nil {
+				return nil, err
+			}
+			return func(next Service) Service {
+				// The options were validated above.
+				breaker, _ := NewBreakerService(next, cfg)
+				return breaker
+			}, nil
+		},
+	},
+	"chaos": {
+		Options: func() interface{} { cfg := DefaultChaosConfig(); return &cfg },
+		Build: func(options interface{}) (Middleware, error) {
+			cfg := *options.(*ChaosConfig)
+			if err := cfg.validate(); err != nil {
+				return nil, err
+			}
+			return func(next Service) Service {
+				// The options were validated above.
+				chaos, _ := NewChaosService(next, cfg)
+				return chaos
+			}, nil
+		},
+	},
+	"policy": {
+		Options: func() interface{} { cfg := DefaultPolicyConfig(); return &cfg },
+		Build: func(options interface{}) (Middleware, error) {
+			cfg := *options.(*PolicyConfig)
+			return func(next Service) Service { return NewPolicyService(next, cfg) }, nil
+		},
+	},
+	"timeout": {
+		Options: func() interface{} { cfg := DefaultTimeoutConfig(); return &cfg },
+		Build: func(options interface{}) (Middleware, error) {
+			cfg := *options.(*TimeoutConfig)
+			if err := cfg.validate(); err != nil {
+				return nil, err
+			}
+			return func(next Service) Service {
+				// The options were validated above.
+				timeout, _ := NewTimeoutService(next, cfg)
+				return timeout
+			}, nil
+		},
+	},
+	"enrich": {
+		Options: func() interface{} { cfg := DefaultEnrichConfig(); return &cfg },
+		Build: func(options interface{}) (Middleware, error) {
+			cfg := *options.(*EnrichConfig)
+			return func(next Service) Service { return NewEnrichService(next, cfg) }, nil
+		},
+	},
+}
+
+// RegisterDecorator adds a named decorator to the registry, replacing any with the same name.
+func RegisterDecorator(name string, d Decorator) {
+	decorators[name] = d
+}
+
+// DecoratorNames returns the names of all registered decorators, sorted.
+func DecoratorNames() []string {
+	names := make([]string, 0, len(decorators))
+	for name := range decorators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Layer is one decorator of a built Stack.
+type Layer struct {
+	Name string
+	// Options holds the effective options of the decorator, nil when it takes none.
+	Options interface{}
+	Service Service
+}
+
+// Stack is a Service wrapped in the decorators listed in the configuration.
+type Stack struct {
+	Service
+	// Layers lists the decorators outermost first, followed by the base service.
+	Layers []Layer
+}
+
+// BuildStack wraps base in the decorators described by specs, outermost first.
+func BuildStack(base Service, specs []DecoratorConfig) (*Stack, error) {
+	layers := make([]Layer, len(specs)+1)
+	mws := make([]Middleware, len(specs))
+	for i, spec := range specs {
+		d, ok := decorators[spec.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown decorator %q (known: %s)", spec.Name, strings.Join(DecoratorNames(), ", "))
+		}
+
+		var options interface{}
+		if d.Options != nil {
+			options = d.Options()
+			if len(spec.Options) > 0 {
+				if err := unmarshalConfig(spec.Options, options); err != nil {
+					return nil, fmt.Errorf("decorator %q: %w", spec.Name, err)
+				}
+			}
+		} else if len(spec.Options) > 0 {
+			return nil, fmt.Errorf("decorator %q takes no options", spec.Name)
+		}
+
+		mw, err := d.Build(options)
+		if err != nil {
+			return nil, fmt.Errorf("decorator %q: %w", spec.Name, err)
+		}
+		layers[i] = Layer{Name: spec.Name, Options: options}
+		mws[i] = mw
+	}
+
+	// Apply the middlewares one at a time, innermost first, to keep hold of every layer.
+	// Every layer is wrapped so traced calls get a span per layer.
+	layers[len(specs)] = Layer{Name: "upstream", Service: base}
+	var svc Service = &tracedService{next: base, name: "upstream"}
+	for i := len(mws) - 1; i >= 0; i-- {
+		layers[i].Service = mws[i](svc)
+		svc = &tracedService{next: layers[i].Service, name: layers[i].Name}
+	}
+
+	return &Stack{Service: svc, Layers: layers}, nil
+}
+
+// String describes the stack, outermost layer first.
+func (s *Stack) String() string {
+	names := make([]string, 0, len(s.Layers))
+	for _, layer := range s.Layers {
+		names = append(names, layer.Name)
+	}
+	return strings.Join(names, " -> ")
+}
+
+// Describe returns the stack as decorator configs carrying their effective options,
+// so the output can be fed back into Config.Decorators.
+func (s *Stack) Describe() ([]DecoratorConfig, error) {
+	specs := make([]DecoratorConfig, 0, len(s.Layers)-1)
+	for _, layer := range s.Layers[:len(s.Layers)-1] {
+		spec := DecoratorConfig{Name: layer.Name}
+		if layer.Options != nil {
+			options, err := marshalConfig(layer.Options)
+			if err != nil {
+				return nil, err
+			}
+			spec.Options = options
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
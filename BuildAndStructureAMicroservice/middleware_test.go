@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// recordingService appends its name to calls whenever GetCatFact goes through it.
+type recordingService struct {
+	Service
+	name  string
+	calls *[]string
+}
+
+func (s *recordingService) GetCatFact(ctx context.Context) (*CatFact, error) {
+	*s.calls = append(*s.calls, s.name)
+	return s.Service.GetCatFact(ctx)
+}
+
+// staticService always returns the same fact.
+type staticService struct {
+	Service
+	fact string
+}
+
+func (s *staticService) GetCatFact(context.Context) (*CatFact, error) {
+	return &CatFact{Fact: s.fact}, nil
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next Service) Service {
+			return &recordingService{Service: next, name: name, calls: &calls}
+		}
+	}
+
+	svc := Chain(record("outer"), record("middle"), record("inner"))(&staticService{fact: "Cats purr."})
+	if _, err := svc.GetCatFact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"outer", "middle", "inner"}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected %v but got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("Expected %v but got %v", expected, calls)
+		}
+	}
+}
+
+func TestBuildStack(t *testing.T) {
+	var specs []DecoratorConfig
+	err := json.Unmarshal([]byte(`[
+		{"name": "cache", "options": {"ttl": "1m30s"}},
+		{"name": "logging"},
+		{"name": "retry", "options": {"max_attempts": 5}}
+	]`), &specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stack, err := BuildStack(&staticService{fact: "Cats purr."}, specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "cache -> logging -> retry -> upstream"; stack.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, stack.String())
+	}
+	cache, ok := stack.Layers[0].Service.(*CacheService)
+	if !ok {
+		t.Fatalf("Expected the outer layer to be a *CacheService but got %T", stack.Layers[0].Service)
+	}
+	if expected := 90 * time.Second; cache.cfg.TTL != expected {
+		t.Errorf("Expected TTL %v but got %v", expected, cache.cfg.TTL)
+	}
+	// Options left out of the config keep their defaults.
+	if expected := DefaultCacheConfig().StaleIfError; cache.cfg.StaleIfError != expected {
+		t.Errorf("Expected StaleIfError %v but got %v", expected, cache.cfg.StaleIfError)
+	}
+}
+
+func TestBuildStackErrors(t *testing.T) {
+	tests := map[string][]DecoratorConfig{
+		"unknown decorator": {{Name: "nope"}},
+		"unknown option":    {{Name: "cache", Options: json.RawMessage(`{"ttl_seconds": 10}`)}},
+		"invalid duration":  {{Name: "cache", Options: json.RawMessage(`{"ttl": "ten seconds"}`)}},
+		"unexpected option": {{Name: "logging", Options: json.RawMessage(`{"level": "debug"}`)}},
+		"invalid option":    {{Name: "timeout", Options: json.RawMessage(`{"percentile": 95}`)}},
+		"invalid retry":     {{Name: "retry", Options: json.RawMessage(`{"max_attempts": -1}`)}},
+		"invalid breaker":   {{Name: "breaker", Options: json.RawMessage(`{"failure_threshold": 0}`)}},
+	}
+
+	for name, specs := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := BuildStack(&staticService{}, specs); err == nil {
+				t.Error("Expected an error but got none")
+			}
+		})
+	}
+}
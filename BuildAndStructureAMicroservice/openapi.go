@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"BuildAndStructureAMicroservice/catfact"
+)
+
+// apiVersion is the version of the API advertised in the OpenAPI document.
+const apiVersion = "1.0.0"
+
+// routeDoc documents a route of the routing table.
+type routeDoc struct {
+	// Path is the OpenAPI path of the route, with its path parameters. It defaults to the
+	// pattern of the route.
+	Path       string
+	Operations []operationDoc
+}
+
+// operationDoc documents one method of a route.
+type operationDoc struct {
+	Method  string
+	Summary string
+	Params  []paramDoc
+	// Request is a value of the type of the request body, if any.
+	Request interface{}
+	Status  int
+	// Response is a value of the type of the response body, or nil for an empty response.
+	Response interface{}
+	// ContentType is the media type of a response that isn't JSON.
+	ContentType string
+	// Errors are the statuses answered with a problem document.
+	Errors []int
+}
+
+// paramDoc documents a query or path parameter.
+type paramDoc struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+}
+
+var (
+	pageParams = []paramDoc{
+		{Name: "page", In: "query", Type: "integer", Description: "Page to return, starting at 1."},
+		{Name: "limit", In: "query", Type: "integer", Description: "Number of items per page."},
+	}
+	enrichParams = []paramDoc{
+		{Name: "enrich", In: "query", Type: "string", Description: "Comma-separated enrichers to apply to facts (id, length, sentences, tags, words), or all."},
+	}
+	// serviceErrors are the statuses errorStatus answers failures of the Service with.
+	serviceErrors = []int{http.StatusUnprocessableEntity, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+)
+
+// OpenAPI returns the OpenAPI 3 document describing the routes served by the server.
+func (s *ApiServer) OpenAPI() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]interface{}{}
+	problem := map[string]interface{}{
+		"description": "Error",
+		"content": map[string]interface{}{
+			catfact.ProblemContentType: map[string]interface{}{"schema": schemaOf(reflect.TypeOf(catfact.Problem{}), schemas)},
+		},
+	}
+
+	for _, rt := range s.routes() {
+		doc := rt.doc
+		if doc.Path == "" {
+			doc.Path = rt.pattern
+		}
+		item := map[string]interface{}{}
+		for _, op := range doc.Operations {
+			responses := map[string]interface{}{}
+			success := map[string]interface{}{"description": http.StatusText(op.Status)}
+			switch {
+			case op.ContentType != "":
+				success["content"] = map[string]interface{}{
+					op.ContentType: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+				}
+			case op.Response != nil:
+				success["content"] = jsonContent(op.Response, schemas)
+			}
+			responses[strconv.Itoa(op.Status)] = success
+			for _, status := range op.Errors {
+				responses[strconv.Itoa(status)] = problem
+			}
+
+			operation := map[string]interface{}{
+				"summary":   op.Summary,
+				"responses": responses,
+			}
+			if len(op.Params) > 0 {
+				var params []interface{}
+				for _, p := range op.Params {
+					params = append(params, map[string]interface{}{
+						"name":        p.Name,
+						"in":          p.In,
+						"required":    p.In == "path",
+						"description": p.Description,
+						"schema":      map[string]interface{}{"type": p.Type},
+					})
+				}
+				operation["parameters"] = params
+			}
+			if op.Request != nil {
+				operation["requestBody"] = map[string]interface{}{
+					"required": true,
+					"content":  jsonContent(op.Request, schemas),
+				}
+			}
+			item[strings.ToLower(op.Method)] = operation
+		}
+		paths[doc.Path] = item
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Cat fact API",
+			"version": apiVersion,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+		},
+	}
+}
+
+// handleOpenAPI is the HTTP handler function serving the OpenAPI document.
+func (s *ApiServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.OpenAPI())
+}
+
+// jsonContent returns the OpenAPI content of a JSON body holding values like v.
+func jsonContent(v interface{}, schemas map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(v), schemas)},
+	}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaOf returns the OpenAPI schema of the JSON encoding of t. Named struct types are
+// added to schemas and referenced.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		// Durations are written as Go duration strings, see marshalConfig.
+		return map[string]interface{}{"type": "string", "example": "1m30s"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			// Reserve the name first so recursive types terminate.
+			schemas[t.Name()] = nil
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema returns the schema of a struct, flattening embedded structs the way
+// encoding/json does.
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := strings.Split(field.Tag.Get("json"), ",")
+			switch {
+			case tag[0] == "-":
+				continue
+			case field.Anonymous && tag[0] == "" && field.Type.Kind() == reflect.Struct:
+				walk(field.Type)
+				continue
+			case !field.IsExported():
+				continue
+			}
+
+			name := tag[0]
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaOf(field.Type, schemas)
+			if !strings.Contains(field.Tag.Get("json"), ",omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
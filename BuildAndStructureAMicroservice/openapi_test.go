@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newFullApiServer returns an ApiServer with every optional route turned on.
+func newFullApiServer(t *testing.T) *ApiServer {
+	t.Helper()
+
+	svc := &staticService{fact: "Cats purr."}
+	webhooks := newTestWebhooks(t, svc, filepath.Join(t.TempDir(), "webhooks.json"))
+
+	cfg := DefaultSchedulerConfig()
+	cfg.StorePath = filepath.Join(t.TempDir(), "schedule.json")
+	scheduler, err := NewScheduler(svc, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer, err := NewTracer(DefaultTracingConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefetcher, err := NewPrefetcher(svc, DefaultPrefetchConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewApiServer(svc,
+		WithPrefetcher(prefetcher),
+		WithWebhooks(webhooks),
+		WithScheduler(scheduler),
+		WithTracer(tracer),
+		WithDashboard(NewDashboard(nil, "http://upstream.test", DefaultDashboardConfig())),
+	)
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	s := newFullApiServer(t)
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	var doc struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	getJSON(t, server.URL+"/openapi.json", &doc)
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("Expected an OpenAPI 3 document but got version %q", doc.OpenAPI)
+	}
+	for _, rt := range s.routes() {
+		path := rt.doc.Path
+		if path == "" {
+			path = rt.pattern
+		}
+		if len(rt.doc.Operations) == 0 || len(doc.Paths[path]) != len(rt.doc.Operations) {
+			t.Errorf("Route %s is not documented in /openapi.json", rt.pattern)
+		}
+	}
+	// Every status errorStatus can answer with is documented.
+	for _, status := range []string{"422", "502", "503", "504"} {
+		if !strings.Contains(string(doc.Paths["/"]["get"]), `"`+status+`"`) {
+			t.Errorf("Expected GET / to document %s", status)
+		}
+	}
+	if len(doc.Paths) != len(s.routes()) {
+		t.Errorf("Expected %d paths but got %d", len(s.routes()), len(doc.Paths))
+	}
+}
+
+func TestOpenAPISchemasFollowGoTypes(t *testing.T) {
+	schemas := map[string]interface{}{}
+	ref := schemaOf(reflect.TypeOf(FactPage{}), schemas)
+
+	if ref["$ref"] != "#/components/schemas/FactPage" {
+		t.Fatalf("Expected a reference to FactPage but got %v", ref)
+	}
+	page := schemas["FactPage"].(map[string]interface{})["properties"].(map[string]interface{})
+	// The embedded Page is flattened like encoding/json does.
+	for _, name := range []string{"current_page", "next_page_url", "data"} {
+		if _, ok := page[name]; !ok {
+			t.Errorf("Expected FactPage to have property %s", name)
+		}
+	}
+	fact := schemas["CatFact"].(map[string]interface{})["properties"].(map[string]interface{})
+	if fact["fact"].(map[string]interface{})["type"] != "string" {
+		t.Errorf("Expected CatFact.fact to be a string but got %v", fact["fact"])
+	}
+}
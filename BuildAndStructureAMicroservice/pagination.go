@@ -0,0 +1,50 @@
+package main
+
+import "context"
+
+// EachFact calls fn for every cat fact across all pages of ListFacts.
+// It stops at the first error returned by fn or the service, or when ctx is cancelled.
+func EachFact(ctx context.Context, svc Service, limit, maxLength int, fn func(CatFact) error) error {
+	return eachPage(ctx, func(ctx context.Context, page int) ([]CatFact, Page, error) {
+		facts, err := svc.ListFacts(ctx, page, limit, maxLength)
+		if err != nil {
+			return nil, Page{}, err
+		}
+		return facts.Data, facts.Page, nil
+	}, fn)
+}
+
+// EachBreed calls fn for every cat breed across all pages of ListBreeds.
+// It stops at the first error returned by fn or the service, or when ctx is cancelled.
+func EachBreed(ctx context.Context, svc Service, limit int, fn func(Breed) error) error {
+	return eachPage(ctx, func(ctx context.Context, page int) ([]Breed, Page, error) {
+		breeds, err := svc.ListBreeds(ctx, page, limit)
+		if err != nil {
+			return nil, Page{}, err
+		}
+		return breeds.Data, breeds.Page, nil
+	}, fn)
+}
+
+// eachPage walks pages starting at the first one until the last page has been visited.
+func eachPage[T any](ctx context.Context, fetch func(context.Context, int) ([]T, Page, error), fn func(T) error) error {
+	for page := 1; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		items, meta, err := fetch(ctx, page)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+
+		if len(items) == 0 || meta.CurrentPage >= meta.LastPage {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrPolicyRejected is returned when every fact fetched within the retry budget broke a policy rule.
+var ErrPolicyRejected = errors.New("policy: no acceptable fact within the retry budget")
+
+// PolicyRule is a named content rule. A fact is rejected when it breaks any condition of
+// the rule; conditions left empty are ignored.
+type PolicyRule struct {
+	Name string `json:"name"`
+	// Clients limits the rule to the clients presenting these certificate common names.
+	// Empty applies the rule to every request.
+	Clients   []string `json:"clients,omitempty"`
+	MinLength int      `json:"min_length,omitempty"`
+	MaxLength int      `json:"max_length,omitempty"`
+	// Require lists keywords of which a fact must contain at least one.
+	Require []string `json:"require,omitempty"`
+	// Ban lists keywords a fact must not contain.
+	Ban []string `json:"ban,omitempty"`
+	// Deny lists regular expressions a fact must not match.
+	Deny []string `json:"deny,omitempty"`
+	// Dedupe rejects facts among the last Dedupe served ones. It only applies to single facts,
+	// and only sees the facts reaching the PolicyService: a cache stacked above it serves
+	// the same fact again until it expires.
+	Dedupe int `json:"dedupe,omitempty"`
+}
+
+// PolicyRules is the content of a rules file.
+type PolicyRules struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyConfig holds the settings of a PolicyService.
+type PolicyConfig struct {
+	// RulesFile is a JSON file holding PolicyRules. When set, it replaces Rules.
+	RulesFile string `json:"rules_file"`
+	// Rules are the rules used without a rules file.
+	Rules []PolicyRule `json:"rules"`
+	// MaxAttempts is how many facts are fetched, including the first one, before giving up
+	// on finding an acceptable one.
+	MaxAttempts int `json:"max_attempts"`
+	// ReloadInterval is how often the rules file is checked for changes, at the next call.
+	ReloadInterval time.Duration `json:"reload_interval"`
+}
+
+// DefaultPolicyConfig returns the default policy settings, without any rule.
+func DefaultPolicyConfig() PolicyConfig {
+	return PolicyConfig{
+		MaxAttempts:    5,
+		ReloadInterval: 10 * time.Second,
+	}
+}
+
+// PolicyStats reports the rules of a PolicyService and how often they rejected facts.
+type PolicyStats struct {
+	Rules []PolicyRule `json:"rules"`
+	// Rejections counts the facts rejected by each rule since the service started.
+	Rejections map[string]int64 `json:"rejections"`
+	// Exhausted counts the calls that ran out of attempts.
+	Exhausted int64     `json:"exhausted"`
+	LoadedAt  time.Time `json:"loaded_at"`
+	// LoadError is the error of the last failed load, if it failed.
+	LoadError string `json:"load_error,omitempty"`
+}
+
+// policyRule is a PolicyRule ready to be checked.
+type policyRule struct {
+	PolicyRule
+	clients map[string]bool
+	deny    []*regexp.Regexp
+}
+
+// compileRules checks rules and compiles their expressions.
+func compileRules(rules []PolicyRule) ([]policyRule, error) {
+	compiled := make([]policyRule, len(rules))
+	seen := make(map[string]bool)
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d has no name", i)
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("rule %q is defined twice", rule.Name)
+		}
+		seen[rule.Name] = true
+		if rule.MinLength < 0 || rule.MaxLength < 0 || rule.Dedupe < 0 {
+			return nil, fmt.Errorf("rule %q: lengths and dedupe must not be negative", rule.Name)
+		}
+
+		compiled[i] = policyRule{PolicyRule: rule}
+		if len(rule.Clients) > 0 {
+			compiled[i].clients = make(map[string]bool, len(rule.Clients))
+			for _, client := range rule.Clients {
+				compiled[i].clients[client] = true
+			}
+		}
+		for _, expr := range rule.Deny {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+			compiled[i].deny = append(compiled[i].deny, re)
+		}
+	}
+	return compiled, nil
+}
+
+// appliesTo reports whether the rule applies to the request of ctx.
+func (r *policyRule) appliesTo(ctx context.Context) bool {
+	if r.clients == nil {
+		return true
+	}
+	id, ok := ClientIdentityFromContext(ctx)
+	return ok && r.clients[id.CommonName]
+}
+
+// ScopedTo reports whether a rule limited to some clients applies to the request of ctx.
+func (s *PolicyService) ScopedTo(ctx context.Context) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rule := range s.currentRules() {
+		if rule.clients != nil && rule.appliesTo(ctx) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejects reports whether the content of fact breaks the rule. Deduplication is checked
+// separately, as it depends on the facts served before.
+func (r *policyRule) rejects(fact *CatFact) bool {
+	length := utf8.RuneCountInString(fact.Fact)
+	if length < r.MinLength || (r.MaxLength > 0 && length > r.MaxLength) {
+		return true
+	}
+	if len(r.Require) > 0 || len(r.Ban) > 0 {
+		text := " " + strings.Join(factWords(fact.Fact), " ") + " "
+		if len(r.Require) > 0 && !containsKeyword(text, r.Require) {
+			return true
+		}
+		if containsKeyword(text, r.Ban) {
+			return true
+		}
+	}
+	for _, re := range r.deny {
+		if re.MatchString(fact.Fact) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsKeyword reports whether text, as space-separated words, contains any keyword,
+// itself a word or phrase, as whole words.
+func containsKeyword(text string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if words := factWords(keyword); len(words) > 0 && strings.Contains(text, " "+strings.Join(words, " ")+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyService is a service wrapper keeping facts that break content rules away from
+// consumers. Rejected single facts are replaced by fetching again, up to MaxAttempts;
+// rejected facts are left out of pages. Stack it below any cache, or re-fetches will be
+// answered with the same fact. Facts served from a cache or a prefetch pool were checked
+// for another client, so requests a client-scoped rule applies to must skip them, see ScopedTo.
+type PolicyService struct {
+	next Service
+	cfg  PolicyConfig
+
+	mu         sync.Mutex
+	rules      []policyRule
+	stamp      fileStamp
+	checkedAt  time.Time
+	loadedAt   time.Time
+	loadErr    error
+	rejections map[string]int64
+	exhausted  int64
+	// recent holds the content IDs of the last facts served, oldest first.
+	recent []string
+}
+
+// NewPolicyService creates a new instance of PolicyService with the provided underlying
+// Service, loading its rules. If they can't be loaded the service has no rule until a
+// reload succeeds, and Err reports why.
+func NewPolicyService(next Service, cfg PolicyConfig) *PolicyService {
+	s := &PolicyService{
+		next:       next,
+		cfg:        cfg,
+		rejections: make(map[string]int64),
+	}
+	if err := s.Reload(); err != nil {
+		logf(LevelError, "policy load err=%v", err)
+	}
+	return s
+}
+
+// Err returns the error of the last load of the rules, or nil if it succeeded.
+func (s *PolicyService) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.loadErr
+}
+
+// Reload loads the rules again. A failed reload keeps the previous rules.
+func (s *PolicyService) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.load()
+}
+
+// load loads the rules from the rules file, or the config without one. The caller must hold s.mu.
+func (s *PolicyService) load() error {
+	s.checkedAt = time.Now()
+
+	rules := s.cfg.Rules
+	var stamp fileStamp
+	if s.cfg.RulesFile != "" {
+		info, err := os.Stat(s.cfg.RulesFile)
+		if err != nil {
+			s.loadErr = err
+			return err
+		}
+		stamp = fileStamp{modTime: info.ModTime(), size: info.Size()}
+
+		raw, err := os.ReadFile(s.cfg.RulesFile)
+		if err != nil {
+			s.loadErr = err
+			return err
+		}
+		var file PolicyRules
+		if err := json.Unmarshal(raw, &file); err != nil {
+			s.loadErr = fmt.Errorf("%s: %w", s.cfg.RulesFile, err)
+			return s.loadErr
+		}
+		rules = file.Rules
+	}
+
+	compiled, err := compileRules(rules)
+	if err != nil {
+		s.loadErr = err
+		return err
+	}
+	s.rules, s.stamp, s.loadedAt, s.loadErr = compiled, stamp, time.Now(), nil
+	return nil
+}
+
+// currentRules returns the rules, reloading the rules file first if it changed.
+// The caller must hold s.mu.
+func (s *PolicyService) currentRules() []policyRule {
+	if s.cfg.RulesFile == "" || time.Since(s.checkedAt) < s.cfg.ReloadInterval {
+		return s.rules
+	}
+	s.checkedAt = time.Now()
+
+	info, err := os.Stat(s.cfg.RulesFile)
+	if err != nil {
+		logf(LevelWarn, "policy reload err=%v", err)
+		return s.rules
+	}
+	if sameStamps([]fileStamp{{modTime: info.ModTime(), size: info.Size()}}, []fileStamp{s.stamp}) {
+		return s.rules
+	}
+	if err := s.load(); err != nil {
+		logf(LevelWarn, "policy reload err=%v", err)
+		return s.rules
+	}
+	logf(LevelInfo, "policy reloaded file=%s rules=%d", s.cfg.RulesFile, len(s.rules))
+	return s.rules
+}
+
+// Stats returns the current rules and rejection counts.
+func (s *PolicyService) Stats() PolicyStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := PolicyStats{
+		Rules:      make([]PolicyRule, len(s.rules)),
+		Rejections: make(map[string]int64, len(s.rejections)),
+		Exhausted:  s.exhausted,
+		LoadedAt:   s.loadedAt,
+	}
+	for i, rule := range s.rules {
+		stats.Rules[i] = rule.PolicyRule
+	}
+	for name, count := range s.rejections {
+		stats.Rejections[name] = count
+	}
+	if s.loadErr != nil {
+		stats.LoadError = s.loadErr.Error()
+	}
+	return stats
+}
+
+// GetCatFact retrieves a cat fact that passes every rule, fetching again when one doesn't.
+func (s *PolicyService) GetCatFact(ctx context.Context) (*CatFact, error) {
+	attempts := s.cfg.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		fact, err := s.next.GetCatFact(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if s.accept(ctx, fact) {
+			return fact, nil
+		}
+	}
+
+	s.mu.Lock()
+	s.exhausted++
+	s.mu.Unlock()
+	return nil, ErrPolicyRejected
+}
+
+// ListFacts retrieves a page of cat facts, leaving out those that break a rule.
+func (s *PolicyService) ListFacts(ctx context.Context, page, limit, maxLength int) (*FactPage, error) {
+	facts, err := s.next.ListFacts(ctx, page, limit, maxLength)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := s.currentRules()
+	filtered := *facts
+	filtered.Data = make([]CatFact, 0, len(facts.Data))
+	for i := range facts.Data {
+		if rule := s.rejectedBy(ctx, rules, &facts.Data[i], ""); rule != "" {
+			s.rejections[rule]++
+			continue
+		}
+		filtered.Data = append(filtered.Data, facts.Data[i])
+	}
+	return &filtered, nil
+}
+
+// ListBreeds retrieves a page of cat breeds, which no rule applies to.
+func (s *PolicyService) ListBreeds(ctx context.Context, page, limit int) (*BreedPage, error) {
+	return s.next.ListBreeds(ctx, page, limit)
+}
+
+// accept checks fact against the rules, counting a rejection or remembering the fact as served.
+func (s *PolicyService) accept(ctx context.Context, fact *CatFact) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := s.currentRules()
+	id := contentID(fact.Fact)
+	if rule := s.rejectedBy(ctx, rules, fact, id); rule != "" {
+		s.rejections[rule]++
+		return false
+	}
+
+	window := 0
+	for _, rule := range rules {
+		if rule.Dedupe > window {
+			window = rule.Dedupe
+		}
+	}
+	if window > 0 {
+		s.recent = append(s.recent, id)
+		if len(s.recent) > window {
+			s.recent = append(s.recent[:0], s.recent[len(s.recent)-window:]...)
+		}
+	}
+	return true
+}
+
+// rejectedBy returns the name of the first rule fact breaks, or "" if it passes them all.
+// Duplicates are only checked when id, the content ID of fact, is given. The caller must hold s.mu.
+func (s *PolicyService) rejectedBy(ctx context.Context, rules []policyRule, fact *CatFact, id string) string {
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.appliesTo(ctx) {
+			continue
+		}
+		if rule.rejects(fact) || (id != "" && s.servedRecently(id, rule.Dedupe)) {
+			return rule.Name
+		}
+	}
+	return ""
+}
+
+// servedRecently reports whether the fact with the content ID id is among the last n
+// facts served. The caller must hold s.mu.
+func (s *PolicyService) servedRecently(id string, n int) bool {
+	for i := len(s.recent) - 1; i >= 0 && i >= len(s.recent)-n; i-- {
+		if s.recent[i] == id {
+			return true
+		}
+	}
+	return false
+}
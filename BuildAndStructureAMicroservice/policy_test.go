@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// sequenceService returns its facts one after the other, starting over at the end.
+type sequenceService struct {
+	Service
+	facts []string
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *sequenceService) GetCatFact(context.Context) (*CatFact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fact := s.facts[s.calls%len(s.facts)]
+	s.calls++
+	return &CatFact{Fact: fact}, nil
+}
+
+func (s *sequenceService) ListFacts(context.Context, int, int, int) (*FactPage, error) {
+	page := &FactPage{}
+	for _, fact := range s.facts {
+		page.Data = append(page.Data, CatFact{Fact: fact})
+	}
+	return page, nil
+}
+
+func TestPolicyRules(t *testing.T) {
+	tests := []struct {
+		rule     PolicyRule
+		fact     string
+		rejected bool
+	}{
+		{PolicyRule{MinLength: 10}, "Cats purr.", false},
+		{PolicyRule{MinLength: 11}, "Cats purr.", true},
+		{PolicyRule{MaxLength: 10}, "Cats purr.", false},
+		{PolicyRule{MaxLength: 9}, "Cats purr.", true},
+		{PolicyRule{Require: []string{"kitten", "cats"}}, "Cats purr.", false},
+		{PolicyRule{Require: []string{"cat"}}, "Cats purr.", true},
+		{PolicyRule{Ban: []string{"dog"}}, "Cats chase dogs.", false},
+		{PolicyRule{Ban: []string{"Dogs"}}, "Cats chase dogs.", true},
+		{PolicyRule{Ban: []string{"chase dogs"}}, "Cats chase dogs.", true},
+		{PolicyRule{Deny: []string{`\d+ (years|lives)`}}, "Cats have 9 lives.", true},
+		{PolicyRule{Deny: []string{`\d+ (years|lives)`}}, "Cats have nine lives.", false},
+	}
+
+	for _, tt := range tests {
+		tt.rule.Name = "rule"
+		rules, err := compileRules([]PolicyRule{tt.rule})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rejected := rules[0].rejects(&CatFact{Fact: tt.fact}); rejected != tt.rejected {
+			t.Errorf("%+v on %q: Expected rejected %v but got %v", tt.rule, tt.fact, tt.rejected, rejected)
+		}
+	}
+
+	if _, err := compileRules([]PolicyRule{{Name: "bad", Deny: []string{"("}}}); err == nil {
+		t.Error("Expected an invalid expression to fail")
+	}
+}
+
+func TestPolicyRefetchesRejectedFacts(t *testing.T) {
+	svc := &sequenceService{facts: []string{"Dogs bark.", "Cats purr.", "Cats purr.", "Cats sleep a lot."}}
+	cfg := DefaultPolicyConfig()
+	cfg.Rules = []PolicyRule{{Name: "no-dogs", Ban: []string{"dogs"}}, {Name: "fresh", Dedupe: 10}}
+	policy := NewPolicyService(svc, cfg)
+
+	for _, expected := range []string{"Cats purr.", "Cats sleep a lot."} {
+		fact, err := policy.GetCatFact(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fact.Fact != expected {
+			t.Errorf("Expected %q but got %q", expected, fact.Fact)
+		}
+	}
+	// The next fetches are a dog fact and two facts served already.
+	policy.cfg.MaxAttempts = 3
+	if _, err := policy.GetCatFact(context.Background()); !errors.Is(err, ErrPolicyRejected) {
+		t.Errorf("Expected %v but got %v", ErrPolicyRejected, err)
+	}
+
+	stats := policy.Stats()
+	if expected := map[string]int64{"no-dogs": 2, "fresh": 3}; !reflect.DeepEqual(stats.Rejections, expected) {
+		t.Errorf("Expected rejections %v but got %v", expected, stats.Rejections)
+	}
+	if stats.Exhausted != 1 {
+		t.Errorf("Expected 1 exhausted call but got %d", stats.Exhausted)
+	}
+
+	// Pages leave rejected facts out, but never dedupe.
+	page, err := policy.ListFacts(context.Background(), 1, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Data) != 3 {
+		t.Errorf("Expected 3 facts but got %v", page.Data)
+	}
+}
+
+func TestPolicyRulesForClients(t *testing.T) {
+	cfg := DefaultPolicyConfig()
+	cfg.Rules = []PolicyRule{{Name: "kids", Clients: []string{"kids-app"}, Ban: []string{"hunt"}}}
+	policy := NewPolicyService(&staticService{fact: "Cats hunt mice."}, cfg)
+
+	if _, err := policy.GetCatFact(context.Background()); err != nil {
+		t.Errorf("Expected other clients to get the fact but got %v", err)
+	}
+	ctx := context.WithValue(context.Background(), clientIdentityKey{}, &ClientIdentity{CommonName: "kids-app"})
+	if _, err := policy.GetCatFact(ctx); !errors.Is(err, ErrPolicyRejected) {
+		t.Errorf("Expected %v but got %v", ErrPolicyRejected, err)
+	}
+}
+
+func TestPolicyReloadsRulesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"rules": [{"name": "short", "max_length": 100}]}`)
+
+	cfg := DefaultPolicyConfig()
+	cfg.RulesFile, cfg.ReloadInterval = path, 0
+	policy := NewPolicyService(&staticService{fact: "Cats purr."}, cfg)
+	if err := policy.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := policy.GetCatFact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The changed file is picked up at the next call.
+	write(`{"rules": [{"name": "no-purring", "ban": ["purr"]}]}`)
+	if _, err := policy.GetCatFact(context.Background()); !errors.Is(err, ErrPolicyRejected) {
+		t.Errorf("Expected the reloaded rule to reject the fact but got %v", err)
+	}
+
+	// A broken file keeps the last good rules.
+	write(`{"rules": [{"name": "broken", "deny": ["("]}]}`)
+	if err := policy.Reload(); err == nil {
+		t.Error("Expected the reload of a broken file to fail")
+	}
+	stats := policy.Stats()
+	if len(stats.Rules) != 1 || stats.Rules[0].Name != "no-purring" || stats.LoadError == "" {
+		t.Errorf("Expected the no-purring rule and a load error but got %+v", stats)
+	}
+}
+
+func TestApiServerSkipsSharedFactsForScopedClients(t *testing.T) {
+	options := json.RawMessage(`{"rules": [{"name": "kids", "clients": ["kids-app"], "ban": ["hunt"]}]}`)
+	upstream := &sequenceService{facts: []string{"Cats hunt mice.", "Cats sleep a lot."}}
+	stack, err := BuildStack(upstream, []DecoratorConfig{{Name: "cache"}, {Name: "policy", Options: options}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefetcher, err := NewPrefetcher(&staticService{fact: "Cats hunt birds."}, testPrefetchConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefetcher.Start()
+	defer prefetcher.Stop()
+	if n := waitPool(prefetcher, 3); n != 3 {
+		t.Fatalf("Expected a full pool of 3 but got %d", n)
+	}
+	handler := NewApiServer(stack, WithPrefetcher(prefetcher)).Handler()
+
+	get := func(client string) (string, string) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if client != "" {
+			r = r.WithContext(context.WithValue(r.Context(), clientIdentityKey{}, &ClientIdentity{CommonName: client}))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		var fact CatFact
+		json.NewDecoder(w.Body).Decode(&fact)
+		return fact.Fact, w.Header().Get("X-Cache")
+	}
+
+	tests := []struct {
+		name, client, fact, status string
+		drainPool                  bool
+	}{
+		{name: "scoped client skips the pool", client: "kids-app", fact: "Cats sleep a lot."},
+		{name: "other clients use the pool", fact: "Cats hunt birds.", status: string(CachePrefetch)},
+		{name: "other clients fill the cache", fact: "Cats hunt mice.", status: string(CacheMiss), drainPool: true},
+		{name: "scoped client skips the cache", client: "kids-app", fact: "Cats sleep a lot."},
+		{name: "other clients use the cache", client: "other-app", fact: "Cats hunt mice.", status: string(CacheHit)},
+	}
+	for _, tt := range tests {
+		if tt.drainPool {
+			prefetcher.Pause()
+			for prefetcher.Len() > 0 {
+				prefetcher.Take()
+			}
+		}
+		if fact, status := get(tt.client); fact != tt.fact || status != tt.status {
+			t.Errorf("%s: Expected %q from %q but got %q from %q", tt.name, tt.fact, tt.status, fact, status)
+		}
+	}
+}
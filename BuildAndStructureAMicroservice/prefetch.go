@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PrefetchConfig holds the settings of a Prefetcher.
+type PrefetchConfig struct {
+	// PoolSize is the maximum number of unused facts kept warm.
+	PoolSize int `json:"pool_size"`
+	// RefillInterval is the pause between two fetches while the pool isn't full.
+	RefillInterval time.Duration `json:"refill_interval"`
+	// FetchTimeout bounds a single fetch.
+	FetchTimeout time.Duration `json:"fetch_timeout"`
+	// MinBackoff and MaxBackoff bound the exponential backoff applied after failed fetches.
+	MinBackoff time.Duration `json:"min_backoff"`
+	MaxBackoff time.Duration `json:"max_backoff"`
+}
+
+// DefaultPrefetchConfig returns the default prefetch settings.
+func DefaultPrefetchConfig() PrefetchConfig {
+	return PrefetchConfig{
+		PoolSize:       20,
+		RefillInterval: 200 * time.Millisecond,
+		FetchTimeout:   5 * time.Second,
+		MinBackoff:     500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+	}
+}
+
+// validate checks that the pool and the intervals make sense. A zero interval or backoff
+// would turn the refill into a busy loop.
+func (c PrefetchConfig) validate() error {
+	if c.PoolSize <= 0 {
+		return fmt.Errorf("pool_size must be positive, got %d", c.PoolSize)
+	}
+	for name, d := range map[string]time.Duration{
+		"refill_interval": c.RefillInterval,
+		"fetch_timeout":   c.FetchTimeout,
+		"min_backoff":     c.MinBackoff,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive, got %v", name, d)
+		}
+	}
+	if c.MaxBackoff < c.MinBackoff {
+		return fmt.Errorf("max_backoff must not be below min_backoff, got %v", c.MaxBackoff)
+	}
+	return nil
+}
+
+// Prefetcher keeps a bounded pool of unused cat facts warm by fetching them in the background.
+type Prefetcher struct {
+	svc  Service
+	cfg  PrefetchConfig
+	pool chan *CatFact
+
+	paused atomic.Bool
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPrefetcher creates a new instance of Prefetcher that fills its pool from svc.
+func NewPrefetcher(svc Service, cfg PrefetchConfig) (*Prefetcher, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &Prefetcher{
+		svc:  svc,
+		cfg:  cfg,
+		pool: make(chan *CatFact, cfg.PoolSize),
+	}, nil
+}
+
+// Start starts filling the pool in the background. Calling Start on a running Prefetcher is a no-op.
+func (p *Prefetcher) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	go p.run(ctx, p.done)
+}
+
+// Stop stops the background refill, aborting any fetch in flight, and waits for it to finish.
+// Facts already in the pool stay available.
+func (p *Prefetcher) Stop() {
+	p.mu.Lock()
+	cancel, done := p.cancel, p.done
+	p.cancel, p.done = nil, nil
+	p.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Pause stops refilling the pool until Resume is called. Facts in the pool stay available.
+func (p *Prefetcher) Pause() {
+	p.paused.Store(true)
+}
+
+// Resume resumes refilling the pool after Pause.
+func (p *Prefetcher) Resume() {
+	p.paused.Store(false)
+}
+
+// Paused reports whether refilling is paused.
+func (p *Prefetcher) Paused() bool {
+	return p.paused.Load()
+}
+
+// Take returns a fact from the pool without blocking. It reports false when the pool is empty.
+func (p *Prefetcher) Take() (*CatFact, bool) {
+	select {
+	case fact := <-p.pool:
+		return fact, true
+	default:
+		return nil, false
+	}
+}
+
+// Len returns the number of facts currently in the pool.
+func (p *Prefetcher) Len() int {
+	return len(p.pool)
+}
+
+// run refills the pool until ctx is cancelled.
+func (p *Prefetcher) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	var backoff time.Duration
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if !p.Paused() && len(p.pool) < cap(p.pool) {
+			if err := p.fetch(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				backoff = nextBackoff(backoff, p.cfg.MinBackoff, p.cfg.MaxBackoff)
+				logf(LevelWarn, "prefetch err=%s backoff=%v", err, backoff)
+				timer.Reset(backoff)
+				continue
+			}
+			backoff = 0
+		}
+		timer.Reset(p.cfg.RefillInterval)
+	}
+}
+
+// fetch retrieves one fact and adds it to the pool, dropping it if the pool filled up meanwhile.
+func (p *Prefetcher) fetch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.FetchTimeout)
+	defer cancel()
+
+	// The pool wants distinct facts, not the one a cache would hand out again.
+	fact, err := p.svc.GetCatFact(withoutCache(ctx))
+	if err != nil {
+		return err
+	}
+
+	select {
+	case p.pool <- fact:
+	default:
+	}
+	return nil
+}
+
+// nextBackoff doubles the previous backoff, keeping it within [lo, hi].
+func nextBackoff(prev, lo, hi time.Duration) time.Duration {
+	next := prev * 2
+	if next < lo {
+		next = lo
+	}
+	if next > hi {
+		next = hi
+	}
+	return next
+}
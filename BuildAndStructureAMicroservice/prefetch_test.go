@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testPrefetchConfig refills a pool of 3 facts quickly.
+func testPrefetchConfig() PrefetchConfig {
+	cfg := DefaultPrefetchConfig()
+	cfg.PoolSize = 3
+	cfg.RefillInterval = time.Millisecond
+	cfg.MinBackoff = time.Millisecond
+	cfg.MaxBackoff = 5 * time.Millisecond
+	return cfg
+}
+
+// waitPool waits for the pool of p to hold n facts and returns its length.
+func waitPool(p *Prefetcher, n int) int {
+	deadline := time.Now().Add(time.Second)
+	for p.Len() < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return p.Len()
+}
+
+func TestPrefetcherFillsPoolPastCache(t *testing.T) {
+	upstream := &countingService{}
+	p, err := NewPrefetcher(NewCacheService(upstream, DefaultCacheConfig()), testPrefetchConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+	defer p.Stop()
+
+	if n := waitPool(p, 3); n != 3 {
+		t.Fatalf("Expected a full pool of 3 but got %d", n)
+	}
+
+	// The pool holds distinct facts, not the cached one over and over.
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		fact, ok := p.Take()
+		if !ok || seen[fact.Fact] {
+			t.Errorf("Expected a new fact but got %+v", fact)
+		}
+		if ok {
+			seen[fact.Fact] = true
+		}
+	}
+
+	// Once paused, the emptied pool isn't refilled.
+	p.Pause()
+	for {
+		if _, ok := p.Take(); !ok {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := p.Len(); n != 0 {
+		t.Errorf("Expected a paused prefetcher to leave the pool empty but got %d", n)
+	}
+	p.Resume()
+	if n := waitPool(p, 3); n != 3 {
+		t.Errorf("Expected the pool to be refilled after Resume but got %d", n)
+	}
+}
+
+func TestPrefetcherBacksOffOnErrors(t *testing.T) {
+	upstream := &countingService{err: errors.New("upstream down")}
+	cfg := testPrefetchConfig()
+	cfg.MinBackoff = 20 * time.Millisecond
+	cfg.MaxBackoff = 20 * time.Millisecond
+	p, err := NewPrefetcher(upstream, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+	time.Sleep(50 * time.Millisecond)
+	p.Stop()
+
+	// Without backoff, the refill interval of 1ms would have made dozens of calls.
+	if calls := upstream.Calls(); calls < 1 || calls > 4 {
+		t.Errorf("Expected a few upstream calls but got %d", calls)
+	}
+	if n := p.Len(); n != 0 {
+		t.Errorf("Expected an empty pool but got %d", n)
+	}
+}
+
+func TestPrefetchConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*PrefetchConfig)
+		ok     bool
+	}{
+		{"default", func(*PrefetchConfig) {}, true},
+		{"zero refill interval", func(c *PrefetchConfig) { c.RefillInterval = 0 }, false},
+		{"negative refill interval", func(c *PrefetchConfig) { c.RefillInterval = -time.Second }, false},
+		{"zero pool", func(c *PrefetchConfig) { c.PoolSize = 0 }, false},
+		{"zero fetch timeout", func(c *PrefetchConfig) { c.FetchTimeout = 0 }, false},
+		{"zero min backoff", func(c *PrefetchConfig) { c.MinBackoff = 0 }, false},
+		{"max below min backoff", func(c *PrefetchConfig) { c.MaxBackoff = c.MinBackoff / 2 }, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultPrefetchConfig()
+		tt.modify(&cfg)
+		_, err := NewPrefetcher(&staticService{fact: "Cats purr."}, cfg)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Expected ok %v but got %v", tt.name, tt.ok, err)
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	tests := []struct {
+		prev, expected time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{100 * time.Millisecond, 200 * time.Millisecond},
+		{600 * time.Millisecond, time.Second},
+		{time.Second, time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := nextBackoff(tt.prev, 100*time.Millisecond, time.Second); got != tt.expected {
+			t.Errorf("After %v: Expected %v but got %v", tt.prev, tt.expected, got)
+		}
+	}
+}
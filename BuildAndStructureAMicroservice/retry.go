@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryConfig holds the settings of a RetryService.
+type RetryConfig struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int `json:"max_attempts"`
+	// MinBackoff and MaxBackoff bound the exponential backoff between attempts.
+	MinBackoff time.Duration `json:"min_backoff"`
+	MaxBackoff time.Duration `json:"max_backoff"`
+}
+
+// DefaultRetryConfig returns the default retry settings.
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts: 3,
+		MinBackoff:  100 * time.Millisecond,
+		MaxBackoff:  time.Second,
+	}
+}
+
+// validate checks that the attempts and backoffs make sense.
+func (c RetryConfig) validate() error {
+	if c.MaxAttempts <= 0 {
+		return fmt.Errorf("max_attempts must be positive, got %d", c.MaxAttempts)
+	}
+	if c.MinBackoff < 0 {
+		return fmt.Errorf("min_backoff must not be negative, got %v", c.MinBackoff)
+	}
+	if c.MaxBackoff < c.MinBackoff {
+		return fmt.Errorf("max_backoff must be at least min_backoff %v, got %v", c.MinBackoff, c.MaxBackoff)
+	}
+	return nil
+}
+
+// RetryService is a service wrapper that retries calls failing with a transient error, with
+// exponential backoff. It gives up early when the caller's context is done.
+type RetryService struct {
+	next Service
+	cfg  RetryConfig
+}
+
+// NewRetryService creates a new instance of RetryService with the provided underlying Service.
+// It returns an error when the attempts or backoffs of cfg make no sense.
+func NewRetryService(next Service, cfg RetryConfig) (*RetryService, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &RetryService{
+		next: next,
+		cfg:  cfg,
+	}, nil
+}
+
+// GetCatFact retrieves a cat fact, retrying on failure.
+func (s *RetryService) GetCatFact(ctx context.Context) (fact *CatFact, err error) {
+	err = s.do(ctx, func() error {
+		fact, err = s.next.GetCatFact(ctx)
+		return err
+	})
+	return fact, err
+}
+
+// ListFacts retrieves a page of cat facts, retrying on failure.
+func (s *RetryService) ListFacts(ctx context.Context, page, limit, maxLength int) (facts *FactPage, err error) {
+	err = s.do(ctx, func() error {
+		facts, err = s.next.ListFacts(ctx, page, limit, maxLength)
+		return err
+	})
+	return facts, err
+}
+
+// ListBreeds retrieves a page of cat breeds, retrying on failure.
+func (s *RetryService) ListBreeds(ctx context.Context, page, limit int) (breeds *BreedPage, err error) {
+	err = s.do(ctx, func() error {
+		breeds, err = s.next.ListBreeds(ctx, page, limit)
+		return err
+	})
+	return breeds, err
+}
+
+// do calls fn until it succeeds, the attempts are used up or retrying is pointless.
+func (s *RetryService) do(ctx context.Context, fn func() error) error {
+	var backoff time.Duration
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= s.cfg.MaxAttempts || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+
+		backoff = nextBackoff(backoff, s.cfg.MinBackoff, s.cfg.MaxBackoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether err may go away on its own: a network failure, or the upstream
+// answering 5xx or 429. Anything else, such as a 4xx, an empty fact, a rejected fact or an
+// open breaker, would only fail again.
+func retryable(err error) bool {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.StatusCode >= 500 || upstreamErr.StatusCode == http.StatusTooManyRequests ||
+			errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
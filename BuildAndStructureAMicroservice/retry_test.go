@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyService fails its first failures calls with err, then succeeds.
+type flakyService struct {
+	Service
+	failures int
+	err      error
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *flakyService) GetCatFact(context.Context) (*CatFact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.calls <= s.failures {
+		return nil, s.err
+	}
+	return &CatFact{Fact: "Cats purr."}, nil
+}
+
+// newTestRetry wraps next in a retry with the settings of cfg.
+func newTestRetry(t *testing.T, next Service, cfg RetryConfig) *RetryService {
+	t.Helper()
+
+	retry, err := NewRetryService(next, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return retry
+}
+
+func TestRetry(t *testing.T) {
+	upstreamErr := &UpstreamError{StatusCode: http.StatusServiceUnavailable, Reason: "upstream down"}
+	notFound := &UpstreamError{StatusCode: http.StatusNotFound, Reason: "not found"}
+
+	tests := []struct {
+		name       string
+		failures   int
+		err        error
+		calls      int
+		wantErr    error
+		minElapsed time.Duration
+	}{
+		{name: "first attempt", failures: 0, err: upstreamErr, calls: 1},
+		{name: "second attempt", failures: 1, err: upstreamErr, calls: 2, minElapsed: 10 * time.Millisecond},
+		{name: "last attempt", failures: 2, err: upstreamErr, calls: 3, minElapsed: 30 * time.Millisecond},
+		{name: "attempts used up", failures: 5, err: upstreamErr, calls: 3, wantErr: upstreamErr, minElapsed: 30 * time.Millisecond},
+		{name: "breaker open", failures: 5, err: ErrBreakerOpen, calls: 1, wantErr: ErrBreakerOpen},
+		{name: "rate limited", failures: 1, err: &UpstreamError{StatusCode: http.StatusTooManyRequests}, calls: 2},
+		{name: "network error", failures: 1, err: &url.Error{Op: "Get", URL: "http://upstream.test", Err: io.ErrUnexpectedEOF}, calls: 2},
+		{name: "truncated body", failures: 1, err: &UpstreamError{StatusCode: http.StatusOK, Err: io.ErrUnexpectedEOF}, calls: 2},
+		{name: "client error", failures: 5, err: notFound, calls: 1, wantErr: notFound},
+		{name: "empty fact", failures: 5, err: ErrEmptyFact, calls: 1, wantErr: ErrEmptyFact},
+		{name: "policy rejected", failures: 5, err: ErrPolicyRejected, calls: 1, wantErr: ErrPolicyRejected},
+		{name: "chaos", failures: 5, err: ErrChaos, calls: 1, wantErr: ErrChaos},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &flakyService{failures: tt.failures, err: tt.err}
+			// The backoff doubles from 10ms to 20ms, the maximum.
+			svc := newTestRetry(t, upstream, RetryConfig{MaxAttempts: 3, MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+
+			start := time.Now()
+			_, err := svc.GetCatFact(context.Background())
+			elapsed := time.Since(start)
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("Expected %v but got %v", tt.wantErr, err)
+			}
+			if upstream.calls != tt.calls {
+				t.Errorf("Expected %d calls but got %d", tt.calls, upstream.calls)
+			}
+			if elapsed < tt.minElapsed {
+				t.Errorf("Expected to back off for at least %v but took %v", tt.minElapsed, elapsed)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenCallerGivesUp(t *testing.T) {
+	upstream := &flakyService{failures: 5, err: &UpstreamError{StatusCode: http.StatusBadGateway}}
+	svc := newTestRetry(t, upstream, RetryConfig{MaxAttempts: 5, MinBackoff: time.Second, MaxBackoff: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := svc.GetCatFact(ctx); err == nil {
+		t.Error("Expected an error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond || upstream.calls != 1 {
+		t.Errorf("Expected a single call abandoned during the backoff but got %d calls in %v", upstream.calls, elapsed)
+	}
+}
+
+func TestRetryConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*RetryConfig)
+		ok     bool
+	}{
+		{"default", func(*RetryConfig) {}, true},
+		{"single attempt", func(c *RetryConfig) { c.MaxAttempts = 1 }, true},
+		{"no backoff", func(c *RetryConfig) { c.MinBackoff, c.MaxBackoff = 0, 0 }, true},
+		{"zero attempts", func(c *RetryConfig) { c.MaxAttempts = 0 }, false},
+		{"negative attempts", func(c *RetryConfig) { c.MaxAttempts = -1 }, false},
+		{"negative min backoff", func(c *RetryConfig) { c.MinBackoff = -time.Second }, false},
+		{"max below min backoff", func(c *RetryConfig) { c.MaxBackoff = c.MinBackoff / 2 }, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultRetryConfig()
+		tt.modify(&cfg)
+		_, err := NewRetryService(&staticService{}, cfg)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Expected ok %v but got %v", tt.name, tt.ok, err)
+		}
+	}
+}
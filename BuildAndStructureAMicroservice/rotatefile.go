@@ -0,0 +1,208 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotationTimeFormat names rotated files after the time they were rotated at.
+const rotationTimeFormat = "20060102T150405.000"
+
+// RotatingFile is a file that is rotated when it grows too big or too old. Rotated files
+// are renamed after the time of rotation, optionally gzipped, and pruned beyond a count.
+type RotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+	now        func() time.Time
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	// background waits for compression and pruning running after a rotation, which
+	// backgroundMu runs one rotation at a time.
+	background   sync.WaitGroup
+	backgroundMu sync.Mutex
+}
+
+// OpenRotatingFile opens, or creates, the file at path for appending. Zero limits disable
+// the matching rotation, and a zero maxBackups keeps every rotated file.
+func OpenRotatingFile(path string, maxBytes int64, maxAge time.Duration, maxBackups int, compress bool) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+		compress:   compress,
+		now:        time.Now,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p to the file, rotating it first if needed.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		// A failed rotation couldn't even reopen the file.
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	tooBig := f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes
+	tooOld := f.maxAge > 0 && f.now().Sub(f.openedAt) >= f.maxAge
+	if tooBig || tooOld {
+		if err := f.rotate(); err != nil {
+			if f.file == nil {
+				return 0, err
+			}
+			// Keep writing to the reopened file; the next write tries again.
+			logf(LevelWarn, "rotate file=%s err=%v", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the file and waits for the background work of past rotations.
+func (f *RotatingFile) Close() error {
+	var err error
+	f.mu.Lock()
+	if f.file != nil {
+		err = f.file.Close()
+	}
+	f.mu.Unlock()
+
+	f.background.Wait()
+	return err
+}
+
+// open opens the file for appending. The caller must hold f.mu, or own f.
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.openedAt = file, info.Size(), f.now()
+	return nil
+}
+
+// rotate moves the current file aside and opens a new one. The caller must hold f.mu.
+// When that fails, the file at f.path is reopened, and f.file is only left nil if even
+// that fails.
+func (f *RotatingFile) rotate() error {
+	rotated := f.path + "." + f.now().UTC().Format(rotationTimeFormat)
+	err := f.file.Close()
+	if err == nil {
+		if err = os.Rename(f.path, rotated); err == nil {
+			if err = f.open(); err != nil {
+				// Put the old file back rather than lose it with the rest of the rotation.
+				os.Rename(rotated, f.path)
+			}
+		}
+	}
+	if err != nil {
+		f.file = nil
+		if openErr := f.open(); openErr != nil {
+			return errors.Join(err, openErr)
+		}
+		return err
+	}
+
+	f.background.Add(1)
+	go func() {
+		defer f.background.Done()
+		f.backgroundMu.Lock()
+		defer f.backgroundMu.Unlock()
+
+		if f.compress {
+			// The file may already have been pruned by a later rotation.
+			if err := gzipFile(rotated); err != nil && !os.IsNotExist(err) {
+				logf(LevelWarn, "rotate compress file=%s err=%v", rotated, err)
+			}
+		}
+		f.prune()
+	}()
+	return nil
+}
+
+// prune removes the oldest rotated files beyond maxBackups.
+func (f *RotatingFile) prune() {
+	if f.maxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+	// Skip compressions still in progress.
+	var done []string
+	for _, name := range backups {
+		if !strings.HasSuffix(name, ".gz.tmp") {
+			done = append(done, name)
+		}
+	}
+	// Names sort by rotation time.
+	sort.Strings(done)
+	for len(done) > f.maxBackups {
+		if err := os.Remove(done[0]); err != nil && !os.IsNotExist(err) {
+			logf(LevelWarn, "rotate prune file=%s err=%v", done[0], err)
+		}
+		done = done[1:]
+	}
+}
+
+// gzipFile replaces the file at path with a gzipped copy named path.gz.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"BuildAndStructureAMicroservice/jsonrpc"
+)
+
+// RPCServer serves the service stack over JSON-RPC 2.0, for consumers that don't speak REST.
+// The same jsonrpc.Server answers POSTs at /rpc and messages on raw TCP connections, and
+// errors carry the problem document the REST API would have answered with.
+type RPCServer struct {
+	rpc    *jsonrpc.Server
+	server *http.Server
+}
+
+// NewRPCServer creates a new instance of RPCServer calling svc, bounding every call by
+// requestTimeout.
+func NewRPCServer(svc Service, requestTimeout time.Duration) *RPCServer {
+	s := &RPCServer{
+		rpc: jsonrpc.NewServer(svc, jsonrpc.WithErrorStatus(errorStatus), jsonrpc.WithTimeout(requestTimeout)),
+	}
+	s.server = &http.Server{Handler: s.Handler()}
+	return s
+}
+
+// Handler returns the HTTP handler serving JSON-RPC at /rpc.
+func (s *RPCServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/rpc", s.rpc)
+	return withRequestID(mux)
+}
+
+// StartHTTP serves JSON-RPC over HTTP on the specified address.
+// It returns http.ErrServerClosed once Shutdown has been called.
+func (s *RPCServer) StartHTTP(listenAddr string) error {
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	return s.server.Serve(ln)
+}
+
+// StartTCP serves JSON-RPC over raw TCP connections on the specified address.
+// It returns net.ErrClosed once Shutdown has been called.
+func (s *RPCServer) StartTCP(listenAddr string) error {
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	return s.rpc.Serve(ln)
+}
+
+// Shutdown gracefully stops the HTTP server, then closes the TCP listeners and connections.
+func (s *RPCServer) Shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	if closeErr := s.rpc.Close(); err == nil && !errors.Is(closeErr, net.ErrClosed) {
+		err = closeErr
+	}
+	return err
+}
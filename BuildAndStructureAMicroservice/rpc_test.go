@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"BuildAndStructureAMicroservice/client"
+	"BuildAndStructureAMicroservice/fakeupstream"
+	"BuildAndStructureAMicroservice/jsonrpc"
+)
+
+// A JSON-RPC client can be used wherever a local Service is expected, too.
+var _ Service = (*jsonrpc.Client)(nil)
+
+// newRPCServers serves a logging stack over a fake upstream with JSON-RPC, returning the URL
+// it is served at over HTTP and the address it is served at over TCP.
+func newRPCServers(t *testing.T) (*fakeupstream.Server, string, string) {
+	t.Helper()
+
+	upstream := fakeupstream.New()
+	t.Cleanup(upstream.Close)
+
+	svc := NewLoggingService(NewCatFactService(upstream.URL))
+	rpcServer := NewRPCServer(svc, time.Second)
+
+	server := httptest.NewServer(rpcServer.Handler())
+	t.Cleanup(server.Close)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go rpcServer.rpc.Serve(ln)
+	t.Cleanup(func() { rpcServer.Shutdown(context.Background()) })
+
+	return upstream, server.URL + "/rpc", ln.Addr().String()
+}
+
+// postRPC POSTs body to url and returns the status and body of the response.
+func postRPC(t *testing.T, url, body string) (int, string) {
+	t.Helper()
+
+	response, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	var out strings.Builder
+	if _, err := bufio.NewReader(response.Body).WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, strings.TrimSpace(out.String())
+}
+
+func TestRPCClientOverBothTransports(t *testing.T) {
+	upstream, url, addr := newRPCServers(t)
+
+	tcpClient, err := jsonrpc.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpClient.Close()
+
+	for name, c := range map[string]*jsonrpc.Client{"http": jsonrpc.NewHTTPClient(url), "tcp": tcpClient} {
+		upstream.SetFault(fakeupstream.PathFact, fakeupstream.Fault{})
+
+		fact, err := c.GetCatFact(context.Background())
+		if err != nil || fact.Fact == "" {
+			t.Errorf("%s: Expected a fact but got %+v and %v", name, fact, err)
+		}
+		facts, err := c.ListFacts(context.Background(), 2, 5, 0)
+		if err != nil || facts.CurrentPage != 2 || len(facts.Data) != 5 {
+			t.Errorf("%s: Expected page 2 with 5 facts but got %+v and %v", name, facts, err)
+		}
+		breeds, err := c.ListBreeds(context.Background(), 1, 3)
+		if err != nil || len(breeds.Data) != 3 {
+			t.Errorf("%s: Expected 3 breeds but got %+v and %v", name, breeds, err)
+		}
+
+		// Errors of the service carry the same problem document as over REST.
+		upstream.SetFault(fakeupstream.PathFact, fakeupstream.Fault{Status: http.StatusInternalServerError})
+		_, err = c.GetCatFact(context.Background())
+		var rpcErr *jsonrpc.Error
+		if !errors.As(err, &rpcErr) || rpcErr.Code != jsonrpc.CodeServiceError || !errors.Is(err, client.ErrUpstream) {
+			t.Errorf("%s: Expected an upstream service error but got %v", name, err)
+		} else if rpcErr.Data.Status != http.StatusBadGateway || rpcErr.Data.Instance == "" {
+			t.Errorf("%s: Expected a 502 problem with a request ID but got %+v", name, rpcErr.Data)
+		}
+	}
+}
+
+func TestRPCClientBatch(t *testing.T) {
+	_, url, addr := newRPCServers(t)
+
+	tcpClient, err := jsonrpc.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpClient.Close()
+
+	for name, c := range map[string]*jsonrpc.Client{"http": jsonrpc.NewHTTPClient(url), "tcp": tcpClient} {
+		var (
+			fact  CatFact
+			facts FactPage
+		)
+		calls := []*jsonrpc.Call{
+			{Method: jsonrpc.MethodGetCatFact, Result: &fact},
+			{Method: jsonrpc.MethodListFacts, Params: []int{3, 2}, Result: &facts},
+			{Method: "Service.Missing"},
+			{Method: jsonrpc.MethodListBreeds, Params: map[string]int{"page": -1}},
+		}
+		if err := c.Batch(context.Background(), calls...); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if calls[0].Error != nil || fact.Fact == "" {
+			t.Errorf("%s: Expected a fact but got %v", name, calls[0].Error)
+		}
+		if calls[1].Error != nil || facts.CurrentPage != 3 || len(facts.Data) != 2 {
+			t.Errorf("%s: Expected page 3 with 2 facts but got %+v and %v", name, facts.Page, calls[1].Error)
+		}
+		for i, code := range map[int]int{2: jsonrpc.CodeMethodNotFound, 3: jsonrpc.CodeInvalidParams} {
+			var rpcErr *jsonrpc.Error
+			if !errors.As(calls[i].Error, &rpcErr) || rpcErr.Code != code {
+				t.Errorf("%s: Expected call %d to fail with code %d but got %v", name, i, code, calls[i].Error)
+			}
+		}
+	}
+}
+
+func TestRPCOverHTTP(t *testing.T) {
+	_, url, _ := newRPCServers(t)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		check  func(string) bool
+	}{
+		{
+			name:   "batch keeps the order and skips notifications",
+			body:   `[{"jsonrpc":"2.0","method":"Service.ListBreeds","params":[1,1],"id":"a"},{"jsonrpc":"2.0","method":"Service.GetCatFact"},{"jsonrpc":"2.0","method":"Service.Nope","id":2},1]`,
+			status: http.StatusOK,
+			check: func(body string) bool {
+				var responses []jsonrpc.Response
+				if json.Unmarshal([]byte(body), &responses) != nil || len(responses) != 3 {
+					return false
+				}
+				return string(responses[0].ID) == `"a"` && responses[0].Error == nil &&
+					string(responses[1].ID) == "2" && responses[1].Error.Code == jsonrpc.CodeMethodNotFound &&
+					string(responses[2].ID) == "null" && responses[2].Error.Code == jsonrpc.CodeInvalidRequest
+			},
+		},
+		{
+			name:   "notifications only",
+			body:   `[{"jsonrpc":"2.0","method":"Service.GetCatFact"}]`,
+			status: http.StatusNoContent,
+			check:  func(body string) bool { return body == "" },
+		},
+		{
+			name:   "parse error",
+			body:   `{"jsonrpc":"2.0",`,
+			status: http.StatusOK,
+			check: func(body string) bool {
+				return strings.Contains(body, `"code":-32700`) && strings.Contains(body, `"id":null`)
+			},
+		},
+		{
+			name:   "empty batch",
+			body:   `[]`,
+			status: http.StatusOK,
+			check:  func(body string) bool { return strings.Contains(body, `"code":-32600`) },
+		},
+		{
+			name:   "wrong version",
+			body:   `{"jsonrpc":"1.0","method":"Service.GetCatFact","id":1}`,
+			status: http.StatusOK,
+			check:  func(body string) bool { return strings.Contains(body, `"code":-32600`) },
+		},
+		{
+			name:   "unknown named parameter",
+			body:   `{"jsonrpc":"2.0","method":"Service.ListBreeds","params":{"max_length":3},"id":1}`,
+			status: http.StatusOK,
+			check:  func(body string) bool { return strings.Contains(body, `"code":-32602`) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := postRPC(t, url, tt.body)
+			if status != tt.status || !tt.check(body) {
+				t.Errorf("Expected status %d but got %d with %s", tt.status, status, body)
+			}
+		})
+	}
+
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d but got %d", http.StatusMethodNotAllowed, response.StatusCode)
+	}
+}
+
+func TestRPCOverTCPClosesOnParseError(t *testing.T) {
+	_, _, addr := newRPCServers(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte(`{"jsonrpc":"2.0","method":"Service.ListBreeds","params":{"limit":1},"id":7}` + "\n" + `{oops` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	var responses []jsonrpc.Response
+	dec := json.NewDecoder(conn)
+	for {
+		var response jsonrpc.Response
+		if err := dec.Decode(&response); err != nil {
+			break
+		}
+		responses = append(responses, response)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("Expected 2 responses before the connection closed but got %+v", responses)
+	}
+	codes := map[string]int{}
+	for _, response := range responses {
+		code := 0
+		if response.Error != nil {
+			code = response.Error.Code
+		}
+		codes[string(response.ID)] = code
+	}
+	if codes["7"] != 0 || codes["null"] != jsonrpc.CodeParseError {
+		t.Errorf("Expected a result for 7 and a parse error but got %v", codes)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoScheduledFact is returned when a job hasn't produced a fact yet.
+var ErrNoScheduledFact = errors.New("no scheduled fact yet")
+
+// CatchUpPolicy decides what happens to slots missed while the service was down.
+type CatchUpPolicy string
+
+const (
+	// CatchUpSkip ignores missed slots.
+	CatchUpSkip CatchUpPolicy = "skip"
+	// CatchUpLatest runs only the most recent missed slot.
+	CatchUpLatest CatchUpPolicy = "latest"
+	// CatchUpAll runs every missed slot, up to MaxCatchUp of them.
+	CatchUpAll CatchUpPolicy = "all"
+)
+
+// JobConfig describes a scheduled fact job.
+type JobConfig struct {
+	Name string `json:"name"`
+	// Schedule is a standard 5-field cron expression.
+	Schedule string `json:"schedule"`
+	// Timezone is the IANA time zone the schedule is evaluated in. Empty means UTC.
+	Timezone string        `json:"timezone"`
+	CatchUp  CatchUpPolicy `json:"catch_up"`
+	// MaxCatchUp bounds how many missed slots CatchUpAll runs, keeping the most recent ones.
+	// Zero means no bound.
+	MaxCatchUp int `json:"max_catch_up"`
+}
+
+// SchedulerConfig holds the settings of a Scheduler.
+type SchedulerConfig struct {
+	// StorePath is the JSON file scheduled facts are persisted to.
+	StorePath string `json:"store_path"`
+	// RetryInterval is the pause before retrying a slot whose fetch failed.
+	RetryInterval time.Duration `json:"retry_interval"`
+	// Grace is how late a slot may run and still be on time rather than missed.
+	Grace time.Duration `json:"grace"`
+	// FetchTimeout bounds a single fetch.
+	FetchTimeout time.Duration `json:"fetch_timeout"`
+	// History is how many facts are kept per job.
+	History int         `json:"history"`
+	Jobs    []JobConfig `json:"jobs"`
+}
+
+// DefaultSchedulerConfig returns the default scheduler settings, with a fact of the day at midnight UTC.
+func DefaultSchedulerConfig() SchedulerConfig {
+	return SchedulerConfig{
+		StorePath:     "schedule.json",
+		RetryInterval: time.Minute,
+		Grace:         5 * time.Minute,
+		FetchTimeout:  30 * time.Second,
+		History:       30,
+		Jobs: []JobConfig{
+			{Name: "daily", Schedule: "0 0 * * *", Timezone: "UTC", CatchUp: CatchUpLatest, MaxCatchUp: 7},
+		},
+	}
+}
+
+// ScheduledFact is the fact fetched for one slot of a job.
+type ScheduledFact struct {
+	Job       string    `json:"job"`
+	Slot      time.Time `json:"slot"`
+	Fact      CatFact   `json:"fact"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// jobState is the persisted state of a job.
+type jobState struct {
+	// Cursor is the last slot that was either run or deliberately skipped.
+	Cursor time.Time       `json:"cursor"`
+	Facts  []ScheduledFact `json:"facts"`
+}
+
+// job is a configured job with its parsed schedule.
+type job struct {
+	cfg      JobConfig
+	schedule *Schedule
+}
+
+// Scheduler fetches facts through a Service on cron schedules and keeps at most one fact
+// per scheduled slot, even across restarts.
+type Scheduler struct {
+	svc  Service
+	cfg  SchedulerConfig
+	jobs []job
+	now  func() time.Time
+
+	mu    sync.Mutex
+	state map[string]*jobState
+
+	lifecycle sync.Mutex
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// NewScheduler creates a new instance of Scheduler, restoring any state persisted at cfg.StorePath.
+func NewScheduler(svc Service, cfg SchedulerConfig) (*Scheduler, error) {
+	s := &Scheduler{
+		svc:   svc,
+		cfg:   cfg,
+		now:   time.Now,
+		state: make(map[string]*jobState),
+	}
+
+	names := make(map[string]bool)
+	for _, jc := range cfg.Jobs {
+		// Jobs are stored and served by name.
+		switch {
+		case jc.Name == "" || strings.Contains(jc.Name, "/"):
+			return nil, fmt.Errorf("invalid job name %q", jc.Name)
+		case names[jc.Name]:
+			return nil, fmt.Errorf("duplicate job name %q", jc.Name)
+		}
+		names[jc.Name] = true
+
+		loc, err := time.LoadLocation(jc.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("job %q: %w", jc.Name, err)
+		}
+		schedule, err := ParseSchedule(jc.Schedule, loc)
+		if err != nil {
+			return nil, fmt.Errorf("job %q: %w", jc.Name, err)
+		}
+		switch jc.CatchUp {
+		case CatchUpSkip, CatchUpLatest, CatchUpAll:
+		case "":
+			jc.CatchUp = CatchUpSkip
+		default:
+			return nil, fmt.Errorf("job %q: unknown catch-up policy %q", jc.Name, jc.CatchUp)
+		}
+		s.jobs = append(s.jobs, job{cfg: jc, schedule: schedule})
+	}
+
+	raw, err := os.ReadFile(cfg.StorePath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(raw, &s.state); err != nil {
+			return nil, fmt.Errorf("schedule store %s: %w", cfg.StorePath, err)
+		}
+	}
+	return s, nil
+}
+
+// Latest returns the most recent fact of the named job.
+func (s *Scheduler) Latest(name string) (ScheduledFact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.state[name]
+	if !ok || len(st.Facts) == 0 {
+		return ScheduledFact{}, ErrNoScheduledFact
+	}
+	return st.Facts[len(st.Facts)-1], nil
+}
+
+// Start runs the jobs in the background, first catching up on missed slots.
+// Calling Start on a running Scheduler is a no-op.
+func (s *Scheduler) Start() {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	if s.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go s.run(ctx, s.done)
+}
+
+// Stop stops the scheduler and waits for a running job to finish.
+func (s *Scheduler) Stop() {
+	s.lifecycle.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.lifecycle.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// run wakes up for every due slot until ctx is cancelled.
+func (s *Scheduler) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	for {
+		wake := s.RunDue(ctx)
+
+		timer := time.NewTimer(time.Until(wake))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// RunDue runs every slot that is due for every job, according to the catch-up policies,
+// and returns when it should be called again.
+func (s *Scheduler) RunDue(ctx context.Context) time.Time {
+	now := s.now()
+	wake := now.Add(24 * time.Hour)
+
+	for _, j := range s.jobs {
+		for _, slot := range s.dueSlots(j, now) {
+			if ctx.Err() != nil {
+				return now
+			}
+			if err := s.runSlot(ctx, j, slot); err != nil {
+				logf(LevelWarn, "scheduler job=%s slot=%s err=%v", j.cfg.Name, slot.Format(time.RFC3339), err)
+				if retry := now.Add(s.cfg.RetryInterval); retry.Before(wake) {
+					wake = retry
+				}
+				break
+			}
+		}
+
+		if next := j.schedule.Next(now); !next.IsZero() && next.Before(wake) {
+			wake = next
+		}
+	}
+	return wake
+}
+
+// dueSlots returns the slots of j to run now, oldest first. Slots older than the grace
+// period are missed and handled by the catch-up policy. The very first run of a job only
+// starts its clock.
+func (s *Scheduler) dueSlots(j job, now time.Time) []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.state[j.cfg.Name]
+	if !ok {
+		s.state[j.cfg.Name] = &jobState{Cursor: now}
+		if err := s.save(); err != nil {
+			logf(LevelError, "scheduler save err=%v", err)
+		}
+		return nil
+	}
+
+	keep := 1
+	if j.cfg.CatchUp == CatchUpAll {
+		keep = j.cfg.MaxCatchUp
+	}
+	var slots []time.Time
+	for slot := j.schedule.Next(st.Cursor); !slot.IsZero() && !slot.After(now); slot = j.schedule.Next(slot) {
+		slots = append(slots, slot)
+		// Only keep what the policy could ever run, so a long outage stays cheap.
+		if keep > 0 && len(slots) > keep {
+			slots = slots[1:]
+		}
+	}
+	if len(slots) == 0 {
+		return nil
+	}
+
+	latest := slots[len(slots)-1]
+	if j.cfg.CatchUp == CatchUpSkip && now.Sub(latest) > s.cfg.Grace {
+		st.Cursor = latest
+		if err := s.save(); err != nil {
+			logf(LevelError, "scheduler save err=%v", err)
+		}
+		return nil
+	}
+	return slots
+}
+
+// runSlot fetches and stores the fact for slot, unless the slot already has one.
+func (s *Scheduler) runSlot(ctx context.Context, j job, slot time.Time) error {
+	s.mu.Lock()
+	st := s.state[j.cfg.Name]
+	for _, f := range st.Facts {
+		if f.Slot.Equal(slot) {
+			s.mu.Unlock()
+			return nil
+		}
+	}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.FetchTimeout)
+	defer cancel()
+
+	fact, err := s.svc.GetCatFact(withoutCache(ctx))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	facts, cursor := st.Facts, st.Cursor
+	st.Facts = append(st.Facts, ScheduledFact{Job: j.cfg.Name, Slot: slot.UTC(), Fact: *fact, FetchedAt: s.now().UTC()})
+	if over := len(st.Facts) - s.cfg.History; over > 0 && s.cfg.History > 0 {
+		st.Facts = append([]ScheduledFact(nil), st.Facts[over:]...)
+	}
+	if slot.After(st.Cursor) {
+		st.Cursor = slot
+	}
+	if err := s.save(); err != nil {
+		// A slot done only in memory would be fetched again after a restart, so leave it
+		// to be retried instead.
+		st.Facts, st.Cursor = facts, cursor
+		return err
+	}
+	logf(LevelInfo, "scheduler job=%s slot=%s fact=%v", j.cfg.Name, slot.Format(time.RFC3339), fact.Fact)
+	return nil
+}
+
+// save persists the state. The caller must hold s.mu.
+func (s *Scheduler) save() error {
+	raw, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.cfg.StorePath, raw)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestScheduler returns a Scheduler with an hourly job whose clock is controlled by the test.
+func newTestScheduler(t *testing.T, svc Service, storePath string, policy CatchUpPolicy, now *time.Time) *Scheduler {
+	t.Helper()
+
+	cfg := DefaultSchedulerConfig()
+	cfg.StorePath = storePath
+	cfg.Jobs = []JobConfig{{Name: "hourly", Schedule: "0 * * * *", CatchUp: policy, MaxCatchUp: 3}}
+
+	s, err := NewScheduler(svc, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func TestSchedulerOneFactPerSlotAcrossRestarts(t *testing.T) {
+	var calls []string
+	svc := &recordingService{Service: &staticService{fact: "Cats purr."}, calls: &calls}
+	storePath := filepath.Join(t.TempDir(), "schedule.json")
+	now := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+
+	s := newTestScheduler(t, svc, storePath, CatchUpLatest, &now)
+	s.RunDue(context.Background())
+
+	now = time.Date(2024, 5, 1, 9, 0, 5, 0, time.UTC)
+	s.RunDue(context.Background())
+	s.RunDue(context.Background())
+
+	// A restart within the same slot must not fetch again.
+	restarted := newTestScheduler(t, svc, storePath, CatchUpLatest, &now)
+	restarted.RunDue(context.Background())
+
+	if len(calls) != 1 {
+		t.Errorf("Expected 1 fetch but got %d", len(calls))
+	}
+	fact, err := restarted.Latest("hourly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC); !fact.Slot.Equal(expected) {
+		t.Errorf("Expected slot %s but got %s", expected, fact.Slot)
+	}
+}
+
+func TestSchedulerCatchUpPolicies(t *testing.T) {
+	tests := []struct {
+		policy   CatchUpPolicy
+		expected int
+	}{
+		{CatchUpSkip, 0},
+		{CatchUpLatest, 1},
+		{CatchUpAll, 3},
+	}
+
+	for _, test := range tests {
+		var calls []string
+		svc := &recordingService{Service: &staticService{fact: "Cats purr."}, calls: &calls}
+		storePath := filepath.Join(t.TempDir(), "schedule.json")
+		now := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+
+		newTestScheduler(t, svc, storePath, test.policy, &now).RunDue(context.Background())
+
+		// Come back after missing five slots.
+		now = now.Add(5 * time.Hour)
+		newTestScheduler(t, svc, storePath, test.policy, &now).RunDue(context.Background())
+
+		if len(calls) != test.expected {
+			t.Errorf("%s: Expected %d fetches but got %d", test.policy, test.expected, len(calls))
+		}
+	}
+}
+
+func TestSchedulerRejectsBadJobNames(t *testing.T) {
+	tests := map[string][]JobConfig{
+		"empty name":     {{Name: "", Schedule: "0 * * * *"}},
+		"slash in name":  {{Name: "a/b", Schedule: "0 * * * *"}},
+		"duplicate name": {{Name: "hourly", Schedule: "0 * * * *"}, {Name: "hourly", Schedule: "30 * * * *"}},
+	}
+
+	for name, jobs := range tests {
+		cfg := DefaultSchedulerConfig()
+		cfg.StorePath = filepath.Join(t.TempDir(), "schedule.json")
+		cfg.Jobs = jobs
+		if _, err := NewScheduler(&staticService{}, cfg); err == nil {
+			t.Errorf("%s: Expected an error", name)
+		}
+	}
+}
+
+func TestSchedulerRetriesSlotsItCouldNotSave(t *testing.T) {
+	var calls []string
+	svc := &recordingService{Service: &staticService{fact: "Cats purr."}, calls: &calls}
+	storePath := filepath.Join(t.TempDir(), "schedule.json")
+	now := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	s := newTestScheduler(t, svc, storePath, CatchUpLatest, &now)
+	s.RunDue(context.Background())
+
+	// A directory in the way of the store makes saving fail.
+	if err := os.Remove(storePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(storePath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	now = time.Date(2024, 5, 1, 9, 0, 5, 0, time.UTC)
+	s.RunDue(context.Background())
+	if _, err := s.Latest("hourly"); !errors.Is(err, ErrNoScheduledFact) {
+		t.Errorf("Expected the unsaved fact to be dropped but got %v", err)
+	}
+
+	// Once the store can be written again, the slot is fetched and kept.
+	if err := os.Remove(storePath); err != nil {
+		t.Fatal(err)
+	}
+	s.RunDue(context.Background())
+	if len(calls) != 2 {
+		t.Errorf("Expected the slot to be fetched again but got %d fetches", len(calls))
+	}
+	restarted := newTestScheduler(t, svc, storePath, CatchUpLatest, &now)
+	if _, err := restarted.Latest("hourly"); err != nil {
+		t.Errorf("Expected the fact to be saved but got %v", err)
+	}
+}
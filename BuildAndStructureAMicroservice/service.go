@@ -3,38 +3,229 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"BuildAndStructureAMicroservice/catfact"
 )
 
-// Service is the interface that defines the methods for retrieving a cat fact.
-type Service interface {
-	GetCatFact(context.Context) (*CatFact, error)
+// defaultMaxBodyBytes is the largest upstream response body we are willing to read.
+const defaultMaxBodyBytes = 1 << 20
+
+// ErrEmptyFact is returned when the upstream answers successfully but without a fact.
+var ErrEmptyFact = errors.New("upstream returned an empty fact")
+
+// Service is the interface that defines the methods for retrieving cat facts and breeds.
+// Both CatFactService and the remote client.Client implement it.
+type Service = catfact.Service
+
+// UpstreamError is returned when the upstream API answers with something other than a valid JSON fact.
+type UpstreamError struct {
+	StatusCode  int
+	ContentType string
+	Reason      string
+	// Err is the underlying error, if any, such as a JSON decoding failure.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *UpstreamError) Error() string {
+	msg := fmt.Sprintf("upstream error: status=%d content-type=%q: %s", e.StatusCode, e.ContentType, e.Reason)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// CatFactOption configures a CatFactService.
+type CatFactOption func(*CatFactService)
+
+// WithMaxBodyBytes limits how many bytes of the upstream response body are read.
+func WithMaxBodyBytes(n int64) CatFactOption {
+	return func(s *CatFactService) {
+		s.maxBodyBytes = n
+	}
+}
+
+// WithDisallowUnknownFields makes decoding fail when the upstream sends fields we don't know about.
+func WithDisallowUnknownFields(disallow bool) CatFactOption {
+	return func(s *CatFactService) {
+		s.disallowUnknownFields = disallow
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to talk to the upstream API.
+func WithHTTPClient(client *http.Client) CatFactOption {
+	return func(s *CatFactService) {
+		s.client = client
+	}
 }
 
 // CatFactService is a concrete implementation of the Service interface.
 type CatFactService struct {
-	url string
+	baseURL               string
+	client                *http.Client
+	maxBodyBytes          int64
+	disallowUnknownFields bool
 }
 
-// NewCatFactService creates a new instance of CatFactService with the provided URL.
-func NewCatFactService(url string) Service {
-	return &CatFactService{
-		url: url,
+// NewCatFactService creates a new instance of CatFactService talking to the API at baseURL.
+func NewCatFactService(baseURL string, opts ...CatFactOption) Service {
+	s := &CatFactService{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		maxBodyBytes: defaultMaxBodyBytes,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	if s.client == nil {
+		// The default config never fails to build.
+		s.client, _ = NewHTTPClient(DefaultHTTPClientConfig())
+	}
+	return s
+}
+
+// catFactResponse is the payload returned by the upstream /fact endpoint.
+type catFactResponse struct {
+	Fact   string `json:"fact"`
+	Length int    `json:"length"`
 }
 
-// GetCatFact retrieves a cat fact from the specified URL.
+// factPageResponse is the payload returned by the upstream /facts endpoint.
+type factPageResponse struct {
+	Page
+	Data []catFactResponse `json:"data"`
+}
+
+// GetCatFact retrieves a cat fact from the upstream /fact endpoint.
 func (s *CatFactService) GetCatFact(ctx context.Context) (*CatFact, error) {
-	res, err := http.Get(s.url)
-	if err != nil {
+	var payload catFactResponse
+	if err := s.get(ctx, "/fact", nil, &payload); err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
+	if strings.TrimSpace(payload.Fact) == "" {
+		return nil, ErrEmptyFact
+	}
+
+	return &CatFact{Fact: payload.Fact}, nil
+}
+
+// ListFacts retrieves a page of cat facts from the upstream /facts endpoint.
+// A maxLength of zero means no limit.
+func (s *CatFactService) ListFacts(ctx context.Context, page, limit, maxLength int) (*FactPage, error) {
+	query := paginationQuery(page, limit)
+	if maxLength > 0 {
+		query.Set("max_length", strconv.Itoa(maxLength))
+	}
+
+	var payload factPageResponse
+	if err := s.get(ctx, "/facts", query, &payload); err != nil {
+		return nil, err
+	}
+
+	facts := &FactPage{
+		Page: payload.Page,
+		Data: make([]CatFact, 0, len(payload.Data)),
+	}
+	for _, fact := range payload.Data {
+		facts.Data = append(facts.Data, CatFact{Fact: fact.Fact})
+	}
+	return facts, nil
+}
 
-	fact := &CatFact{}
-	if err := json.NewDecoder(res.Body).Decode(fact); err != nil {
+// ListBreeds retrieves a page of cat breeds from the upstream /breeds endpoint.
+func (s *CatFactService) ListBreeds(ctx context.Context, page, limit int) (*BreedPage, error) {
+	breeds := &BreedPage{}
+	if err := s.get(ctx, "/breeds", paginationQuery(page, limit), breeds); err != nil {
 		return nil, err
 	}
+	return breeds, nil
+}
+
+// paginationQuery builds the query parameters shared by the paginated endpoints.
+func paginationQuery(page, limit int) url.Values {
+	query := url.Values{}
+	if page > 0 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	return query
+}
+
+// get performs a GET request against the upstream path and decodes the response into v.
+func (s *CatFactService) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	endpoint := s.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
 
-	return fact, nil
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return s.decode(res, v)
+}
+
+// decode validates the status and content type of res and decodes its body into v.
+func (s *CatFactService) decode(res *http.Response, v interface{}) error {
+	contentType := res.Header.Get("Content-Type")
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &UpstreamError{
+			StatusCode:  res.StatusCode,
+			ContentType: contentType,
+			Reason:      http.StatusText(res.StatusCode),
+		}
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "application/json" {
+		return &UpstreamError{
+			StatusCode:  res.StatusCode,
+			ContentType: contentType,
+			Reason:      "unexpected content type",
+		}
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(nil, res.Body, s.maxBodyBytes))
+	if s.disallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &UpstreamError{
+				StatusCode:  res.StatusCode,
+				ContentType: contentType,
+				Reason:      fmt.Sprintf("response body exceeds %d bytes", tooLarge.Limit),
+			}
+		}
+		return &UpstreamError{
+			StatusCode:  res.StatusCode,
+			ContentType: contentType,
+			Reason:      "invalid response body",
+			Err:         err,
+		}
+	}
+	return nil
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"BuildAndStructureAMicroservice/fakeupstream"
+	"BuildAndStructureAMicroservice/vcr"
+)
+
+func TestCatFactServiceGetCatFact(t *testing.T) {
+	upstream := fakeupstream.New()
+	defer upstream.Close()
+
+	svc := NewCatFactService(upstream.URL)
+
+	fact, err := svc.GetCatFact(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fakeupstream.DefaultFacts()[0].Fact
+	if fact.Fact != expected {
+		t.Errorf("Expected %q but got %q", expected, fact.Fact)
+	}
+}
+
+func TestCatFactServiceUpstreamFaults(t *testing.T) {
+	upstream := fakeupstream.New()
+	defer upstream.Close()
+
+	tests := []struct {
+		name         string
+		fault        fakeupstream.Fault
+		opts         []CatFactOption
+		wantUpstream bool
+		wantErr      error
+	}{
+		{name: "error status", fault: fakeupstream.Fault{Status: http.StatusInternalServerError}, wantUpstream: true},
+		{name: "wrong content type", fault: fakeupstream.Fault{ContentType: "text/plain"}, wantUpstream: true},
+		{name: "body too large", fault: fakeupstream.Fault{}, opts: []CatFactOption{WithMaxBodyBytes(8)}, wantUpstream: true},
+		{name: "empty fact", fault: fakeupstream.Fault{Body: `{"fact":"","length":0}`}, wantErr: ErrEmptyFact},
+		{name: "malformed json", fault: fakeupstream.Fault{Malformed: true}},
+		{name: "truncated body", fault: fakeupstream.Fault{Truncate: true}},
+		{name: "dropped connection", fault: fakeupstream.Fault{Drop: true}},
+		{
+			name:  "unknown fields disallowed",
+			fault: fakeupstream.Fault{Body: `{"fact":"Cats purr.","length":10,"extra":true}`},
+			opts:  []CatFactOption{WithDisallowUnknownFields(true)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream.Reset()
+			upstream.Inject(fakeupstream.PathFact, tt.fault)
+
+			_, err := NewCatFactService(upstream.URL, tt.opts...).GetCatFact(context.Background())
+			if err == nil {
+				t.Fatal("Expected an error but got none")
+			}
+
+			var upstreamErr *UpstreamError
+			if tt.wantUpstream && !errors.As(err, &upstreamErr) {
+				t.Errorf("Expected an UpstreamError but got %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v but got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCatFactServiceHonoursContext(t *testing.T) {
+	upstream := fakeupstream.New()
+	defer upstream.Close()
+	upstream.Inject(fakeupstream.PathFact, fakeupstream.Fault{Latency: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := NewCatFactService(upstream.URL).GetCatFact(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v but got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestCatFactServiceListFacts(t *testing.T) {
+	upstream := fakeupstream.New()
+	defer upstream.Close()
+
+	svc := NewCatFactService(upstream.URL, WithDisallowUnknownFields(true))
+
+	facts, err := svc.ListFacts(context.Background(), 2, 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if facts.CurrentPage != 2 || len(facts.Data) != 5 {
+		t.Errorf("Expected page 2 with 5 facts but got page %d with %d facts", facts.CurrentPage, len(facts.Data))
+	}
+
+	// Walking every page should visit the whole corpus exactly once.
+	var count int
+	err = EachFact(context.Background(), svc, 5, 0, func(CatFact) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := len(fakeupstream.DefaultFacts()); count != expected {
+		t.Errorf("Expected %d facts but got %d", expected, count)
+	}
+}
+
+func TestCatFactServiceRecordReplay(t *testing.T) {
+	upstream := fakeupstream.New()
+	cassette := filepath.Join(t.TempDir(), "catfact.json")
+
+	// newService builds a CatFactService whose transport goes through a vcr.Recorder.
+	newService := func(mode vcr.Mode) (Service, *vcr.Recorder) {
+		recorder, err := vcr.New(cassette, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := DefaultHTTPClientConfig()
+		cfg.WrapTransport = recorder.Wrap
+		client, err := NewHTTPClient(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewCatFactService(upstream.URL, WithHTTPClient(client)), recorder
+	}
+
+	// Record two facts against the upstream.
+	svc, recorder := newService(vcr.ModeRecord)
+	var recorded []string
+	for i := 0; i < 2; i++ {
+		fact, err := svc.GetCatFact(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, fact.Fact)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay them with the upstream gone.
+	upstream.Close()
+	svc, _ = newService(vcr.ModeReplay)
+	for _, expected := range recorded {
+		fact, err := svc.GetCatFact(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fact.Fact != expected {
+			t.Errorf("Expected %q but got %q", expected, fact.Fact)
+		}
+	}
+
+	// The cassette is used up, so a third call must fail loudly.
+	if _, err := svc.GetCatFact(context.Background()); !errors.Is(err, vcr.ErrInteractionNotFound) {
+		t.Errorf("Expected %v but got %v", vcr.ErrInteractionNotFound, err)
+	}
+}
@@ -0,0 +1,23 @@
+// Package stats holds the latency statistics shared by the fact service and its tools.
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+// Percentile returns the p-th percentile of sorted, with p between 0 and 1, by the
+// nearest-rank method. It returns 0 when sorted is empty.
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
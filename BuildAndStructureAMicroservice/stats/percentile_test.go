@@ -0,0 +1,21 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for p, expected := range map[float64]time.Duration{0: 1, 0.5: 5, 0.9: 9, 0.95: 10, 0.99: 10, 0.999: 10, 1: 10} {
+		if got := Percentile(sorted, p); got != expected {
+			t.Errorf("p%v: Expected %v but got %v", p, expected, got)
+		}
+	}
+	if got := Percentile([]time.Duration{7}, 0.5); got != 7 {
+		t.Errorf("Expected the single sample but got %v", got)
+	}
+	if got := Percentile(nil, 0.5); got != 0 {
+		t.Errorf("Expected 0 without samples but got %v", got)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"BuildAndStructureAMicroservice/stats"
+)
+
+// TimeoutConfig holds the settings of a TimeoutService.
+type TimeoutConfig struct {
+	// Percentile is the percentile of the observed latencies the timeout is based on, such as 0.95.
+	Percentile float64 `json:"percentile"`
+	// Multiplier scales the percentile to give the timeout.
+	Multiplier float64 `json:"multiplier"`
+	// Floor and Ceiling bound the timeout. Until MinSamples latencies have been observed,
+	// the timeout is Ceiling.
+	Floor   time.Duration `json:"floor"`
+	Ceiling time.Duration `json:"ceiling"`
+	// Window is the number of latest latencies the percentile is computed over.
+	Window     int `json:"window"`
+	MinSamples int `json:"min_samples"`
+	// Name is the expvar name the current timeout is published under, at /debug/vars of the admin API.
+	Name string `json:"name"`
+}
+
+// DefaultTimeoutConfig returns the default adaptive timeout settings.
+func DefaultTimeoutConfig() TimeoutConfig {
+	return TimeoutConfig{
+		Percentile: 0.95,
+		Multiplier: 2,
+		Floor:      500 * time.Millisecond,
+		Ceiling:    10 * time.Second,
+		Window:     200,
+		MinSamples: 20,
+		Name:       "timeout",
+	}
+}
+
+// validate checks that the percentile, multiplier and bounds make sense.
+func (c TimeoutConfig) validate() error {
+	if c.Percentile <= 0 || c.Percentile > 1 {
+		return fmt.Errorf("percentile must be above 0 and at most 1, got %v", c.Percentile)
+	}
+	if c.Multiplier <= 0 {
+		return fmt.Errorf("multiplier must be positive, got %v", c.Multiplier)
+	}
+	if c.Floor < 0 {
+		return fmt.Errorf("floor must not be negative, got %v", c.Floor)
+	}
+	if c.Ceiling <= 0 || c.Ceiling < c.Floor {
+		return fmt.Errorf("ceiling must be positive and at least the floor %v, got %v", c.Floor, c.Ceiling)
+	}
+	if c.Window <= 0 {
+		return fmt.Errorf("window must be positive, got %d", c.Window)
+	}
+	if c.MinSamples < 0 || c.MinSamples > c.Window {
+		return fmt.Errorf("min_samples must be between 0 and the window %d, got %d", c.Window, c.MinSamples)
+	}
+	return nil
+}
+
+// TimeoutStats describes the state of a TimeoutService.
+type TimeoutStats struct {
+	Timeout    time.Duration `json:"timeout_ns"`
+	Percentile time.Duration `json:"percentile_ns"`
+	Samples    int           `json:"samples"`
+	Timeouts   int64         `json:"timeouts"`
+}
+
+// TimeoutService is a service wrapper that bounds GetCatFact by a timeout following the observed
+// latency of the upstream: a percentile of the latest latencies times a multiplier, between a
+// floor and a ceiling. It only ever shortens the caller's deadline.
+type TimeoutService struct {
+	next Service
+	cfg  TimeoutConfig
+
+	mu       sync.Mutex
+	samples  []time.Duration
+	pos      int
+	timeout  time.Duration
+	logged   time.Duration
+	timeouts int64
+	quantile time.Duration
+}
+
+// NewTimeoutService creates a new instance of TimeoutService with the provided underlying Service
+// and publishes its stats with expvar. Only the first service using a given name is published.
+// It returns an error when the settings of cfg make no sense.
+func NewTimeoutService(next Service, cfg TimeoutConfig) (*TimeoutService, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	s := &TimeoutService{
+		next:    next,
+		cfg:     cfg,
+		samples: make([]time.Duration, 0, cfg.Window),
+		timeout: cfg.Ceiling,
+		logged:  cfg.Ceiling,
+	}
+	if cfg.Name != "" && expvar.Get(cfg.Name) == nil {
+		expvar.Publish(cfg.Name, expvar.Func(func() interface{} { return s.Stats() }))
+	}
+	return s, nil
+}
+
+// GetCatFact retrieves a cat fact within the current timeout.
+func (s *TimeoutService) GetCatFact(ctx context.Context) (*CatFact, error) {
+	timeout := s.Timeout()
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	fact, err := s.next.GetCatFact(callCtx)
+	took := time.Since(start)
+
+	switch {
+	case err == nil:
+		s.observe(took, false)
+	case ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded):
+		// Our own deadline fired: the upstream took at least that long, which lets the
+		// timeout grow back when the upstream slows down.
+		s.observe(took, true)
+		logf(LevelWarn, "adaptive timeout=%v method=GetCatFact err=%v", timeout, err)
+		return nil, fmt.Errorf("adaptive timeout %v: %w", timeout, err)
+	}
+	return fact, err
+}
+
+// ListFacts retrieves a page of cat facts. Only GetCatFact is bounded.
+func (s *TimeoutService) ListFacts(ctx context.Context, page, limit, maxLength int) (*FactPage, error) {
+	return s.next.ListFacts(ctx, page, limit, maxLength)
+}
+
+// ListBreeds retrieves a page of cat breeds. Only GetCatFact is bounded.
+func (s *TimeoutService) ListBreeds(ctx context.Context, page, limit int) (*BreedPage, error) {
+	return s.next.ListBreeds(ctx, page, limit)
+}
+
+// Timeout returns the timeout the next call will get.
+func (s *TimeoutService) Timeout() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.timeout
+}
+
+// Stats returns the current timeout and the observations it is based on.
+func (s *TimeoutService) Stats() TimeoutStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return TimeoutStats{
+		Timeout:    s.timeout,
+		Percentile: s.quantile,
+		Samples:    len(s.samples),
+		Timeouts:   s.timeouts,
+	}
+}
+
+// observe records the latency of a call and computes the timeout of the next ones.
+func (s *TimeoutService) observe(took time.Duration, timedOut bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if timedOut {
+		s.timeouts++
+	}
+	if len(s.samples) < s.cfg.Window {
+		s.samples = append(s.samples, took)
+	} else {
+		s.samples[s.pos] = took
+		s.pos = (s.pos + 1) % s.cfg.Window
+	}
+	if len(s.samples) < s.cfg.MinSamples {
+		return
+	}
+
+	sorted := make([]time.Duration, len(s.samples))
+	copy(sorted, s.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	s.quantile = stats.Percentile(sorted, s.cfg.Percentile)
+	s.timeout = time.Duration(float64(s.quantile) * s.cfg.Multiplier)
+	if s.timeout < s.cfg.Floor {
+		s.timeout = s.cfg.Floor
+	}
+	if s.timeout > s.cfg.Ceiling {
+		s.timeout = s.cfg.Ceiling
+	}
+
+	// Log the timeout when it moved by a tenth or more since it was last logged.
+	if diff := s.timeout - s.logged; diff < -s.logged/10 || diff > s.logged/10 {
+		logf(LevelInfo, "adaptive timeout=%v p%g=%v samples=%d", s.timeout, s.cfg.Percentile*100, s.quantile, len(s.samples))
+		s.logged = s.timeout
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+)
+
+// slowService takes delay to answer, unless its context is done first, and remembers the
+// deadline of the last call.
+type slowService struct {
+	Service
+	delay    time.Duration
+	deadline time.Time
+}
+
+func (s *slowService) GetCatFact(ctx context.Context) (*CatFact, error) {
+	s.deadline, _ = ctx.Deadline()
+	select {
+	case <-time.After(s.delay):
+		return &CatFact{Fact: "Cats purr."}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	cfg := DefaultTimeoutConfig()
+	cfg.Floor = 20 * time.Millisecond
+	cfg.Ceiling = time.Second
+	cfg.MinSamples = 5
+	cfg.Window = 5
+	cfg.Name = "timeout_test"
+	slow := &slowService{}
+	svc, err := NewTimeoutService(slow, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Until enough latencies are known, calls get the ceiling.
+	if timeout := svc.Timeout(); timeout != time.Second {
+		t.Errorf("Expected the ceiling but got %v", timeout)
+	}
+
+	// Fast calls bring the timeout down to the floor.
+	for i := 0; i < 5; i++ {
+		if _, err := svc.GetCatFact(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if timeout := svc.Timeout(); timeout != cfg.Floor {
+		t.Errorf("Expected the floor but got %v", timeout)
+	}
+
+	// A slow call is cut short, and the timeout grows with it.
+	slow.delay = 200 * time.Millisecond
+	start := time.Now()
+	_, err = svc.GetCatFact(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) || errorStatus(err) != 504 {
+		t.Errorf("Expected a deadline error but got %v", err)
+	}
+	if took := time.Since(start); took >= slow.delay {
+		t.Errorf("Expected the call to be cut short but it took %v", took)
+	}
+	if timeout := svc.Timeout(); timeout < 2*cfg.Floor {
+		t.Errorf("Expected the timeout to grow past %v but got %v", 2*cfg.Floor, timeout)
+	}
+
+	// The current timeout is published.
+	stats := svc.Stats()
+	if stats.Timeouts != 1 || stats.Samples != 5 || stats.Timeout != svc.Timeout() {
+		t.Errorf("Expected 1 timeout over 5 samples but got %+v", stats)
+	}
+	if v := expvar.Get("timeout_test"); v == nil || v.String() == "" {
+		t.Error("Expected the stats to be published with expvar")
+	}
+}
+
+func TestAdaptiveTimeoutKeepsCallerDeadline(t *testing.T) {
+	cfg := DefaultTimeoutConfig()
+	cfg.Name = ""
+	slow := &slowService{}
+	svc, err := NewTimeoutService(slow, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A caller in more of a hurry keeps its deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	if _, err := svc.GetCatFact(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !slow.deadline.Equal(deadline) {
+		t.Errorf("Expected deadline %v but got %v", deadline, slow.deadline)
+	}
+
+	// A caller giving up isn't a timeout of ours.
+	slow.delay = time.Second
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := svc.GetCatFact(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the caller's deadline error but got %v", err)
+	}
+	if stats := svc.Stats(); stats.Timeouts != 0 || stats.Samples != 1 {
+		t.Errorf("Expected a single sample and no timeout but got %+v", stats)
+	}
+}
+
+func TestTimeoutConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*TimeoutConfig)
+		ok     bool
+	}{
+		{"default", func(*TimeoutConfig) {}, true},
+		{"max percentile", func(c *TimeoutConfig) { c.Percentile = 1 }, true},
+		{"zero floor", func(c *TimeoutConfig) { c.Floor = 0 }, true},
+		{"floor at ceiling", func(c *TimeoutConfig) { c.Floor = c.Ceiling }, true},
+		{"zero percentile", func(c *TimeoutConfig) { c.Percentile = 0 }, false},
+		{"percentile above 1", func(c *TimeoutConfig) { c.Percentile = 95 }, false},
+		{"zero multiplier", func(c *TimeoutConfig) { c.Multiplier = 0 }, false},
+		{"negative floor", func(c *TimeoutConfig) { c.Floor = -time.Second }, false},
+		{"zero ceiling", func(c *TimeoutConfig) { c.Ceiling = 0 }, false},
+		{"ceiling below floor", func(c *TimeoutConfig) { c.Ceiling = c.Floor / 2 }, false},
+		{"zero window", func(c *TimeoutConfig) { c.Window = 0 }, false},
+		{"min samples beyond window", func(c *TimeoutConfig) { c.MinSamples = c.Window + 1 }, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultTimeoutConfig()
+		cfg.Name = ""
+		tt.modify(&cfg)
+		_, err := NewTimeoutService(&slowService{}, cfg)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Expected ok %v but got %v", tt.name, tt.ok, err)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Client certificate policies of TLSConfig.ClientAuth.
+const (
+	ClientAuthNone    = "none"
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+)
+
+// TLSConfig holds the TLS settings of the API server. TLS is on when CertFile is set.
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// ClientCAFile is a PEM bundle of the CAs client certificates are verified against.
+	ClientCAFile string `json:"client_ca_file"`
+	// ClientAuth is none, request (verify a client certificate if one is sent) or require.
+	ClientAuth string `json:"client_auth"`
+	// ReloadInterval is how often the files are checked for changes, at the next handshake.
+	ReloadInterval time.Duration `json:"reload_interval"`
+}
+
+// DefaultTLSConfig returns the default TLS settings, with TLS off.
+func DefaultTLSConfig() TLSConfig {
+	return TLSConfig{
+		ClientAuth:     ClientAuthNone,
+		ReloadInterval: 10 * time.Second,
+	}
+}
+
+// Enabled reports whether TLS is configured.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// TLSReloader serves the certificate and client CAs of a TLSConfig, reloading them when
+// the files change so certificates can be rotated without a restart.
+type TLSReloader struct {
+	cfg        TLSConfig
+	clientAuth tls.ClientAuthType
+
+	mu        sync.Mutex
+	current   *tls.Config
+	stamps    []fileStamp
+	checkedAt time.Time
+}
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewTLSReloader creates a new instance of TLSReloader, loading the files once so a bad
+// configuration is caught at startup.
+func NewTLSReloader(cfg TLSConfig) (*TLSReloader, error) {
+	r := &TLSReloader{cfg: cfg}
+
+	switch cfg.ClientAuth {
+	case "", ClientAuthNone:
+		r.clientAuth = tls.NoClientCert
+	case ClientAuthRequest:
+		r.clientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		r.clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown client_auth %q", cfg.ClientAuth)
+	}
+	if r.clientAuth != tls.NoClientCert && cfg.ClientCAFile == "" {
+		return nil, errors.New("client_auth needs a client_ca_file")
+	}
+
+	stamps, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	current, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.current, r.stamps, r.checkedAt = current, stamps, time.Now()
+	return r, nil
+}
+
+// TLSConfig returns the TLS configuration to serve with. Every handshake gets the
+// certificate and client CAs current at that time.
+func (r *TLSReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config(), nil
+		},
+	}
+}
+
+// config returns the current TLS configuration, reloading the files first if they changed.
+// A failed reload keeps the previous configuration.
+func (r *TLSReloader) config() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) < r.cfg.ReloadInterval {
+		return r.current
+	}
+	r.checkedAt = time.Now()
+
+	stamps, err := r.stat()
+	if err != nil {
+		logf(LevelWarn, "tls reload err=%v", err)
+		return r.current
+	}
+	if sameStamps(stamps, r.stamps) {
+		return r.current
+	}
+
+	current, err := r.load()
+	if err != nil {
+		logf(LevelWarn, "tls reload err=%v", err)
+		return r.current
+	}
+	r.current, r.stamps = current, stamps
+	logf(LevelInfo, "tls reloaded cert=%s", r.cfg.CertFile)
+	return r.current
+}
+
+// files returns the files making up the configuration.
+func (r *TLSReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// stat returns the current versions of the files.
+func (r *TLSReloader) stat() ([]fileStamp, error) {
+	var stamps []fileStamp
+	for _, name := range r.files() {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{modTime: info.ModTime(), size: info.Size()})
+	}
+	return stamps, nil
+}
+
+// sameStamps reports whether a and b are the same versions of the same files.
+func sameStamps(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
+
+// load reads the files into a TLS configuration.
+func (r *TLSReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   r.clientAuth,
+	}
+
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", r.cfg.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+	}
+	return cfg, nil
+}
+
+// ClientIdentity is the identity of a client that presented a verified certificate.
+type ClientIdentity struct {
+	CommonName   string   `json:"common_name"`
+	Organization []string `json:"organization,omitempty"`
+	DNSNames     []string `json:"dns_names,omitempty"`
+	SerialNumber string   `json:"serial_number"`
+}
+
+// clientIdentityKey is the context key of the client identity.
+type clientIdentityKey struct{}
+
+// ClientIdentityFromContext returns the identity of the client of the request handled
+// under ctx, if it presented a verified certificate.
+func ClientIdentityFromContext(ctx context.Context) (*ClientIdentity, bool) {
+	id, ok := ctx.Value(clientIdentityKey{}).(*ClientIdentity)
+	return id, ok
+}
+
+// withClientIdentity puts the identity of clients presenting a verified certificate into
+// the request context.
+func withClientIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			cert := r.TLS.VerifiedChains[0][0]
+			id := &ClientIdentity{
+				CommonName:   cert.Subject.CommonName,
+				Organization: cert.Subject.Organization,
+				DNSNames:     cert.DNSNames,
+				SerialNumber: cert.SerialNumber.String(),
+			}
+			r = r.WithContext(context.WithValue(r.Context(), clientIdentityKey{}, id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a locally generated certificate and its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert issues a certificate for name, signed by parent or self-signed when parent is nil.
+func newTestCert(t *testing.T, name string, serial int64, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"Cat Facts"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write writes the certificate and key as PEM files.
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile != "" {
+		if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tlsCertificate returns the certificate for use in a tls.Config.
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// serveTLS serves handler over TLS with reloader on a random local port and returns its address.
+func serveTLS(t *testing.T, reloader *TLSReloader, handler http.Handler) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(tls.NewListener(ln, reloader.TLSConfig()))
+	t.Cleanup(func() { server.Close() })
+	return ln.Addr().String()
+}
+
+// tlsClient returns a client trusting ca and presenting cert, if any. Connections aren't
+// reused so every request makes a new handshake.
+func tlsClient(ca *testCert, cert *testCert) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	cfg := &tls.Config{RootCAs: pool}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{cert.tlsCertificate()}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+}
+
+func TestTLSReloadsChangedCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ca := newTestCert(t, "Test CA", 1, nil)
+	newTestCert(t, "server", 2, ca).write(t, certFile, keyFile)
+
+	cfg := DefaultTLSConfig()
+	cfg.CertFile, cfg.KeyFile, cfg.ReloadInterval = certFile, keyFile, 0
+	reloader, err := NewTLSReloader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTLS(t, reloader, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serverSerial := func() int64 {
+		t.Helper()
+		response, err := tlsClient(ca, nil).Get("https://" + addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		return response.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	if serial := serverSerial(); serial != 2 {
+		t.Fatalf("Expected serial 2 but got %d", serial)
+	}
+
+	// Rotate the certificate on disk, without touching the server.
+	newTestCert(t, "server", 3, ca).write(t, certFile, keyFile)
+	if serial := serverSerial(); serial != 3 {
+		t.Errorf("Expected the rotated serial 3 but got %d", serial)
+	}
+
+	// A broken rotation keeps the last good certificate.
+	if err := os.WriteFile(certFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if serial := serverSerial(); serial != 3 {
+		t.Errorf("Expected serial 3 to be kept but got %d", serial)
+	}
+}
+
+func TestMutualTLSClientIdentity(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	ca := newTestCert(t, "Test CA", 1, nil)
+	ca.write(t, caFile, "")
+	newTestCert(t, "server", 2, ca).write(t, certFile, keyFile)
+
+	cfg := DefaultTLSConfig()
+	cfg.CertFile, cfg.KeyFile, cfg.ClientCAFile, cfg.ClientAuth = certFile, keyFile, caFile, ClientAuthRequire
+	reloader, err := NewTLSReloader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTLS(t, reloader, withClientIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := ClientIdentityFromContext(r.Context())
+		writeJSON(w, http.StatusOK, id)
+	})))
+
+	// Without a client certificate the handshake fails.
+	if _, err := tlsClient(ca, nil).Get("https://" + addr); err == nil {
+		t.Error("Expected a request without a client certificate to fail")
+	}
+
+	// A certificate from another CA is rejected too.
+	other := newTestCert(t, "Other CA", 10, nil)
+	if _, err := tlsClient(ca, newTestCert(t, "mallory", 11, other)).Get("https://" + addr); err == nil {
+		t.Error("Expected a request with an untrusted client certificate to fail")
+	}
+
+	response, err := tlsClient(ca, newTestCert(t, "billing-service", 4, ca)).Get("https://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	var id ClientIdentity
+	if err := json.NewDecoder(response.Body).Decode(&id); err != nil {
+		t.Fatal(err)
+	}
+	if id.CommonName != "billing-service" || id.SerialNumber != "4" {
+		t.Errorf("Expected identity billing-service/4 but got %s/%s", id.CommonName, id.SerialNumber)
+	}
+}
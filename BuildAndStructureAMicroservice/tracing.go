@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"BuildAndStructureAMicroservice/catfact"
+)
+
+// TraceParentHeader is the W3C Trace Context header carrying the trace of a request.
+const TraceParentHeader = "traceparent"
+
+// Trace exporters.
+const (
+	TraceExporterStdout = "stdout"
+	TraceExporterRing   = "ring"
+)
+
+// TracingConfig holds the settings of the tracer.
+type TracingConfig struct {
+	// Exporter is where finished traces go: "stdout" writes them as JSON lines, "ring" keeps
+	// the last RingSize traces in memory for /debug/traces of the admin API.
+	Exporter string `json:"exporter"`
+	RingSize int    `json:"ring_size"`
+	// SampleRatio is the share of new traces that are recorded, from 0 to 1. Requests carrying
+	// a traceparent follow the sampling decision of the caller.
+	SampleRatio float64 `json:"sample_ratio"`
+}
+
+// DefaultTracingConfig returns the default tracing settings, keeping every trace in memory.
+func DefaultTracingConfig() TracingConfig {
+	return TracingConfig{
+		Exporter:    TraceExporterRing,
+		RingSize:    256,
+		SampleRatio: 1,
+	}
+}
+
+// SpanData is a finished span, as exported.
+type SpanData struct {
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Start      time.Time         `json:"start"`
+	Duration   time.Duration     `json:"duration_ns"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Trace is the spans recorded by this process for a trace, in the order they ended.
+type Trace struct {
+	TraceID string     `json:"trace_id"`
+	Spans   []SpanData `json:"spans"`
+}
+
+// TraceExporter receives the traces finished by a Tracer.
+type TraceExporter interface {
+	Export(trace Trace)
+}
+
+// Tracer starts the traces of incoming requests and hands them to an exporter once their
+// root span ends. Spans ending after the root span are not exported.
+type Tracer struct {
+	cfg      TracingConfig
+	exporter TraceExporter
+}
+
+// NewTracer creates a new instance of Tracer exporting to the exporter of cfg.
+func NewTracer(cfg TracingConfig) (*Tracer, error) {
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, fmt.Errorf("sample_ratio must be between 0 and 1, got %v", cfg.SampleRatio)
+	}
+	switch cfg.Exporter {
+	case TraceExporterStdout:
+		return &Tracer{cfg: cfg, exporter: NewJSONExporter(os.Stdout)}, nil
+	case TraceExporterRing:
+		if cfg.RingSize <= 0 {
+			return nil, fmt.Errorf("ring_size must be positive, got %d", cfg.RingSize)
+		}
+		return &Tracer{cfg: cfg, exporter: NewRingExporter(cfg.RingSize)}, nil
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+}
+
+// Ring returns the ring buffer the tracer exports to, or nil if it exports elsewhere.
+func (t *Tracer) Ring() *RingExporter {
+	ring, _ := t.exporter.(*RingExporter)
+	return ring
+}
+
+// Start starts the root span of a trace. The trace continues the one described by
+// traceparent when it is valid, and is new otherwise.
+func (t *Tracer) Start(ctx context.Context, name, traceparent string) (context.Context, *Span) {
+	rec := &traceRecord{tracer: t}
+	var parentID string
+	if traceID, spanID, sampled, ok := parseTraceParent(traceparent); ok {
+		rec.traceID, parentID, rec.sampled = traceID, spanID, sampled
+	} else {
+		rec.traceID = newTraceID(16)
+		rec.sampled = t.cfg.SampleRatio >= 1 || randomRatio() < t.cfg.SampleRatio
+	}
+	span := rec.start(name, parentID)
+	span.root = true
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Middleware traces every request served by next, continuing the trace sent by the client.
+// name names the span of a request.
+func (t *Tracer) Middleware(next http.Handler, name func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := t.Start(r.Context(), name(r), r.Header.Get(TraceParentHeader))
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+		if id := catfact.RequestIDFromContext(ctx); id != "" {
+			span.SetAttribute("request_id", id)
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttribute("http.status_code", strconv.Itoa(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("%d %s", rec.status, http.StatusText(rec.status)))
+		}
+	})
+}
+
+// traceRecord collects the spans of a trace until its root span ends.
+type traceRecord struct {
+	tracer  *Tracer
+	traceID string
+	sampled bool
+
+	mu    sync.Mutex
+	spans []SpanData
+	done  bool
+}
+
+// start starts a span of the trace.
+func (rec *traceRecord) start(name, parentID string) *Span {
+	return &Span{
+		rec: rec,
+		data: SpanData{
+			TraceID:  rec.traceID,
+			SpanID:   newTraceID(8),
+			ParentID: parentID,
+			Name:     name,
+			Start:    time.Now(),
+		},
+	}
+}
+
+// finish records a span that ended, exporting the trace when it is the root span.
+func (rec *traceRecord) finish(data SpanData, root bool) {
+	if !rec.sampled {
+		return
+	}
+
+	rec.mu.Lock()
+	if rec.done {
+		rec.mu.Unlock()
+		return
+	}
+	rec.spans = append(rec.spans, data)
+	if !root {
+		rec.mu.Unlock()
+		return
+	}
+	rec.done = true
+	trace := Trace{TraceID: rec.traceID, Spans: rec.spans}
+	rec.mu.Unlock()
+
+	rec.tracer.exporter.Export(trace)
+}
+
+// spanKey is the context key of the current span.
+type spanKey struct{}
+
+// Span is an operation of a trace. A nil *Span is valid and records nothing, so code can
+// start spans whether or not its caller is traced.
+type Span struct {
+	rec  *traceRecord
+	root bool
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// StartSpan starts a child of the span of ctx and returns a context carrying it. Without a
+// span in ctx, it returns ctx and a nil *Span.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := parent.rec.start(name, parent.data.SpanID)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// SpanFromContext returns the current span of ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SetAttribute annotates the span with a key and value.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]string)
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetError marks the span as failed with err, if not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+	s.data.Error = err.Error()
+}
+
+// End ends the span. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.Duration = time.Since(s.data.Start)
+	data := s.data
+	s.mu.Unlock()
+
+	s.rec.finish(data, s.root)
+}
+
+// TraceParent returns the traceparent header value making the span the parent of a remote call.
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	flags := "00"
+	if s.rec.sampled {
+		flags = "01"
+	}
+	return "00-" + s.data.TraceID + "-" + s.data.SpanID + "-" + flags
+}
+
+// parseTraceParent parses a W3C traceparent header value. Versions after 00 are read as
+// far as version 00 goes, as the specification asks.
+func parseTraceParent(value string) (traceID, spanID string, sampled, ok bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 55 || (len(value) > 55 && (value[:2] == "00" || value[55] != '-')) {
+		return "", "", false, false
+	}
+	version, traceID, spanID, flags := value[:2], value[3:35], value[36:52], value[53:55]
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' || version == "ff" {
+		return "", "", false, false
+	}
+	for _, field := range []string{version, traceID, spanID, flags} {
+		if !isLowerHex(field) {
+			return "", "", false, false
+		}
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(spanID, "0") == "" {
+		return "", "", false, false
+	}
+	flagBits, _ := strconv.ParseUint(flags, 16, 8)
+	return traceID, spanID, flagBits&1 == 1, true
+}
+
+// isLowerHex reports whether s only holds lowercase hex digits.
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// newTraceID returns a random hex ID of n bytes.
+func newTraceID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		// Fall back on the clock rather than sending an invalid ID.
+		binary.BigEndian.PutUint64(b[n-8:], uint64(time.Now().UnixNano()))
+	}
+	return hex.EncodeToString(b)
+}
+
+// randomRatio returns a random number between 0 and 1.
+func randomRatio() float64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return float64(binary.BigEndian.Uint64(b[:])>>11) / (1 << 53)
+}
+
+// JSONExporter writes every trace as a line of JSON.
+type JSONExporter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewJSONExporter creates a new instance of JSONExporter writing to out.
+func NewJSONExporter(out io.Writer) *JSONExporter {
+	return &JSONExporter{out: out}
+}
+
+// Export implements TraceExporter.
+func (e *JSONExporter) Export(trace Trace) {
+	raw, err := json.Marshal(trace)
+	if err != nil {
+		logf(LevelWarn, "trace export err=%v", err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := e.out.Write(append(raw, '\n')); err != nil {
+		logf(LevelWarn, "trace export err=%v", err)
+	}
+}
+
+// RingExporter keeps the last traces in memory.
+type RingExporter struct {
+	mu     sync.Mutex
+	traces []Trace
+	next   int
+	full   bool
+}
+
+// NewRingExporter creates a new instance of RingExporter keeping size traces.
+func NewRingExporter(size int) *RingExporter {
+	return &RingExporter{traces: make([]Trace, size)}
+}
+
+// Export implements TraceExporter.
+func (e *RingExporter) Export(trace Trace) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.traces[e.next] = trace
+	e.next = (e.next + 1) % len(e.traces)
+	if e.next == 0 {
+		e.full = true
+	}
+}
+
+// Traces returns the traces kept, newest first.
+func (e *RingExporter) Traces() []Trace {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	n := e.next
+	if e.full {
+		n = len(e.traces)
+	}
+	traces := make([]Trace, 0, n)
+	for i := 1; i <= n; i++ {
+		traces = append(traces, e.traces[(e.next-i+len(e.traces))%len(e.traces)])
+	}
+	return traces
+}
+
+// tracedService traces the calls to a layer of a Stack. It does nothing for calls that
+// aren't traced.
+type tracedService struct {
+	next Service
+	name string
+}
+
+// GetCatFact implements the Service interface.
+func (s *tracedService) GetCatFact(ctx context.Context) (fact *CatFact, err error) {
+	ctx, span := StartSpan(ctx, s.name+".GetCatFact")
+	defer func() { span.SetError(err); span.End() }()
+
+	return s.next.GetCatFact(ctx)
+}
+
+// ListFacts implements the Service interface.
+func (s *tracedService) ListFacts(ctx context.Context, page, limit, maxLength int) (facts *FactPage, err error) {
+	ctx, span := StartSpan(ctx, s.name+".ListFacts")
+	defer func() { span.SetError(err); span.End() }()
+
+	return s.next.ListFacts(ctx, page, limit, maxLength)
+}
+
+// ListBreeds implements the Service interface.
+func (s *tracedService) ListBreeds(ctx context.Context, page, limit int) (breeds *BreedPage, err error) {
+	ctx, span := StartSpan(ctx, s.name+".ListBreeds")
+	defer func() { span.SetError(err); span.End() }()
+
+	return s.next.ListBreeds(ctx, page, limit)
+}
+
+// tracingTransport traces outgoing requests and sends their traceparent upstream. It does
+// nothing for requests that aren't traced.
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := StartSpan(req.Context(), "HTTP "+req.Method+" "+req.URL.Host)
+	if span == nil {
+		return t.next.RoundTrip(req)
+	}
+	defer span.End()
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.Redacted())
+
+	req = req.Clone(ctx)
+	req.Header.Set(TraceParentHeader, span.TraceParent())
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetError(fmt.Errorf("%s", resp.Status))
+	}
+	return resp, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"BuildAndStructureAMicroservice/fakeupstream"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"garbage", false, false},
+		{"", false, false},
+	}
+
+	for _, tt := range tests {
+		_, _, sampled, ok := parseTraceParent(tt.value)
+		if ok != tt.ok || sampled != tt.sampled {
+			t.Errorf("%q: Expected ok %v and sampled %v but got %v and %v", tt.value, tt.ok, tt.sampled, ok, sampled)
+		}
+	}
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newTracedServer serves a cache and logging stack over a fake upstream with a tracer
+// keeping traces in memory. sent receives the traceparent headers sent upstream.
+func newTracedServer(t *testing.T, sent chan<- string) (*Tracer, *httptest.Server) {
+	t.Helper()
+
+	upstream := fakeupstream.New()
+	t.Cleanup(upstream.Close)
+
+	clientCfg := DefaultHTTPClientConfig()
+	clientCfg.WrapTransport = func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			sent <- req.Header.Get(TraceParentHeader)
+			return next.RoundTrip(req)
+		})
+	}
+	client, err := NewHTTPClient(clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack, err := BuildStack(NewCatFactService(upstream.URL, WithHTTPClient(client)),
+		[]DecoratorConfig{{Name: "cache"}, {Name: "logging"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer, err := NewTracer(DefaultTracingConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewApiServer(stack, WithTracer(tracer)).Handler())
+	t.Cleanup(server.Close)
+	return tracer, server
+}
+
+// getTraced sends a GET request carrying traceparent to url.
+func getTraced(t *testing.T, url, traceparent string) {
+	t.Helper()
+
+	request, _ := http.NewRequest(http.MethodGet, url, nil)
+	request.Header.Set(TraceParentHeader, traceparent)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+}
+
+// waitTraces waits for tracer to hold n traces and returns them. Root spans end once the
+// handler returns, which may be after the client got its response.
+func waitTraces(t *testing.T, tracer *Tracer, n int) []Trace {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		traces := tracer.Ring().Traces()
+		if len(traces) >= n || time.Now().After(deadline) {
+			return traces
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTracingAcrossDecoratorsAndUpstream(t *testing.T) {
+	sent := make(chan string, 10)
+	tracer, server := newTracedServer(t, sent)
+
+	const traceID, callerSpan = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	getTraced(t, server.URL+"/", "00-"+traceID+"-"+callerSpan+"-01")
+
+	traces := waitTraces(t, tracer, 1)
+	if len(traces) != 1 || traces[0].TraceID != traceID {
+		t.Fatalf("Expected a single trace %s but got %+v", traceID, traces)
+	}
+
+	// Every span is the child of the one before, from the handler down to the upstream request.
+	spans := make(map[string]SpanData)
+	for _, span := range traces[0].Spans {
+		spans[span.Name] = span
+	}
+	host := strings.TrimPrefix(server.URL, "http://")
+	upstreamHost := ""
+	for name := range spans {
+		if strings.HasPrefix(name, "HTTP GET 127.0.0.1") && !strings.HasSuffix(name, host) {
+			upstreamHost = name
+		}
+	}
+	parent := callerSpan
+	for _, name := range []string{"HTTP GET /", "cache.GetCatFact", "logging.GetCatFact", "upstream.GetCatFact", upstreamHost} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("Expected a span %q but got %+v", name, traces[0].Spans)
+		}
+		if span.ParentID != parent {
+			t.Errorf("Expected span %q to have parent %s but got %s", name, parent, span.ParentID)
+		}
+		parent = span.SpanID
+	}
+	if status := spans["HTTP GET /"].Attributes["http.status_code"]; status != "200" {
+		t.Errorf("Expected status code 200 but got %q", status)
+	}
+
+	// The upstream is told about the request span.
+	if expected := "00-" + traceID + "-" + parent + "-01"; <-sent != expected {
+		t.Errorf("Expected traceparent %s upstream", expected)
+	}
+
+	// A cached fact doesn't reach the upstream, and the trace shows it.
+	getTraced(t, server.URL+"/", "")
+	traces = waitTraces(t, tracer, 2)
+	if len(traces) != 2 || traces[0].TraceID == traceID || len(traces[0].Spans) != 2 {
+		t.Errorf("Expected a new trace of 2 spans first but got %+v", traces)
+	}
+}
+
+func TestTracingFollowsSamplingAndServesTraces(t *testing.T) {
+	tracer, server := newTracedServer(t, make(chan string, 10))
+
+	// An unsampled caller isn't recorded, but still propagates its trace.
+	getTraced(t, server.URL+"/breeds", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if traces := tracer.Ring().Traces(); len(traces) != 0 {
+		t.Errorf("Expected no trace but got %+v", traces)
+	}
+
+	getTraced(t, server.URL+"/breeds", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	getTraced(t, server.URL+"/breeds", "")
+	waitTraces(t, tracer, 2)
+
+	// The traces are served by the admin API only.
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	stack, err := BuildStack(&staticService{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := httptest.NewServer(NewAdminServer(cfg, stack, nil, nil, tracer).Handler())
+	defer admin.Close()
+
+	var body struct {
+		Traces []Trace `json:"traces"`
+	}
+	adminRequest(t, http.MethodGet, admin.URL+"/debug/traces?trace_id="+url.QueryEscape("0af7651916cd43dd8448eb211c80319c"), "", &body)
+	if len(body.Traces) != 1 || body.Traces[0].TraceID != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("Expected the requested trace but got %+v", body.Traces)
+	}
+	adminRequest(t, http.MethodGet, admin.URL+"/debug/traces?limit=1", "", &body)
+	if len(body.Traces) != 1 || body.Traces[0].TraceID == "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("Expected the newest trace only but got %+v", body.Traces)
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	var out bytes.Buffer
+	tracer := &Tracer{cfg: DefaultTracingConfig(), exporter: NewJSONExporter(&out)}
+	ctx, root := tracer.Start(context.Background(), "root", "")
+	_, child := StartSpan(ctx, "child")
+	child.SetAttribute("k", "v")
+	child.End()
+	root.End()
+
+	var trace Trace
+	if err := json.Unmarshal(out.Bytes(), &trace); err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Spans) != 2 || trace.Spans[0].Name != "child" || trace.Spans[0].Attributes["k"] != "v" || trace.Spans[1].Name != "root" {
+		t.Errorf("Expected the child then the root span but got %+v", trace.Spans)
+	}
+}
@@ -1,6 +1,13 @@
 package main
 
-// CatFact represents a cat fact.
-type CatFact struct {
-	Fact string `json:"fact"`
-}
+import "BuildAndStructureAMicroservice/catfact"
+
+// The types served by the API live in the catfact package so clients can share them.
+type (
+	CatFact   = catfact.CatFact
+	Breed     = catfact.Breed
+	PageLink  = catfact.PageLink
+	Page      = catfact.Page
+	FactPage  = catfact.FactPage
+	BreedPage = catfact.BreedPage
+)
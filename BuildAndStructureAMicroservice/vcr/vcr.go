@@ -0,0 +1,306 @@
+// Package vcr provides an http.RoundTripper that records upstream HTTP interactions to a
+// cassette file once and replays them afterwards, so tests don't need the real upstream.
+package vcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Mode selects what a Recorder does with requests.
+type Mode int
+
+const (
+	// ModeReplay answers requests from the cassette and never touches the network.
+	ModeReplay Mode = iota
+	// ModeRecord sends requests upstream and appends the interactions to the cassette.
+	ModeRecord
+	// ModePassthrough sends requests upstream without recording them.
+	ModePassthrough
+)
+
+// String implements fmt.Stringer.
+func (m Mode) String() string {
+	switch m {
+	case ModeReplay:
+		return "replay"
+	case ModeRecord:
+		return "record"
+	case ModePassthrough:
+		return "passthrough"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// ParseMode parses the name of a Mode, as returned by Mode.String.
+func ParseMode(s string) (Mode, error) {
+	for _, m := range []Mode{ModeReplay, ModeRecord, ModePassthrough} {
+		if strings.EqualFold(s, m.String()) {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("vcr: unknown mode %q", s)
+}
+
+// ErrInteractionNotFound is returned in replay mode when no recorded interaction matches a request.
+var ErrInteractionNotFound = errors.New("vcr: no recorded interaction matches the request")
+
+// redacted replaces the values of sensitive headers in cassettes.
+const redacted = "[REDACTED]"
+
+// Cassette is the on-disk collection of recorded interactions.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a single recorded request and its response.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is the recorded part of an outgoing request.
+type Request struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+// Response is the recorded part of an upstream response.
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body"`
+}
+
+// Recorder is an http.RoundTripper that records, replays or passes through HTTP traffic.
+type Recorder struct {
+	path string
+	mode Mode
+	next http.RoundTripper
+
+	matchMethod bool
+	matchURL    bool
+	matchBody   bool
+	redact      []string
+	overwrite   bool
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// Option configures a Recorder.
+type Option func(*Recorder)
+
+// WithMatching selects which parts of a request must match a recorded one. URL and method
+// matching are enabled by default, body matching is not.
+func WithMatching(method, url, body bool) Option {
+	return func(r *Recorder) {
+		r.matchMethod, r.matchURL, r.matchBody = method, url, body
+	}
+}
+
+// WithRedactedHeaders adds headers whose values are never written to the cassette.
+// Authorization, Cookie and Set-Cookie are always redacted.
+func WithRedactedHeaders(headers ...string) Option {
+	return func(r *Recorder) {
+		r.redact = append(r.redact, headers...)
+	}
+}
+
+// WithOverwrite lets a Recorder in record mode replace an existing cassette.
+func WithOverwrite() Option {
+	return func(r *Recorder) {
+		r.overwrite = true
+	}
+}
+
+// New creates a Recorder backed by the cassette at path. In replay mode the cassette must exist.
+// In record mode it must not, unless WithOverwrite is given, so a cassette isn't lost by accident.
+func New(path string, mode Mode, opts ...Option) (*Recorder, error) {
+	r := &Recorder{
+		path:        path,
+		mode:        mode,
+		next:        http.DefaultTransport,
+		matchMethod: true,
+		matchURL:    true,
+		redact:      []string{"Authorization", "Cookie", "Set-Cookie"},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if mode == ModeReplay {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("vcr: loading cassette: %w", err)
+		}
+		if err := json.Unmarshal(raw, &r.cassette); err != nil {
+			return nil, fmt.Errorf("vcr: decoding cassette %s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+	if mode == ModeRecord && !r.overwrite {
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("vcr: cassette %s already exists", path)
+		}
+	}
+	return r, nil
+}
+
+// Wrap makes the Recorder send live requests through next and returns it. Its signature
+// matches HTTPClientConfig.WrapTransport so a Recorder can be plugged into the service client.
+func (r *Recorder) Wrap(next http.RoundTripper) http.RoundTripper {
+	r.next = next
+	return r
+}
+
+// Save writes the recorded interactions to the cassette file. It is a no-op outside record mode.
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	raw, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(raw, '\n'), 0o644)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch r.mode {
+	case ModePassthrough:
+		return r.next.RoundTrip(req)
+	case ModeRecord:
+		return r.record(req)
+	default:
+		return r.replay(req)
+	}
+}
+
+// record sends req upstream and stores the interaction.
+func (r *Recorder) record(req *http.Request) (*http.Response, error) {
+	req, body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: Request{
+			Method:  req.Method,
+			URL:     req.URL.String(),
+			Headers: r.redactHeaders(req.Header),
+			Body:    string(body),
+		},
+		Response: Response{
+			StatusCode: res.StatusCode,
+			Headers:    r.redactHeaders(res.Header),
+			Body:       string(resBody),
+		},
+	})
+	return res, nil
+}
+
+// replay answers req with the first unused recorded interaction matching it.
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	_, body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || !r.matches(req, body, interaction.Request) {
+			continue
+		}
+		r.used[i] = true
+
+		recorded := interaction.Response
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+			StatusCode:    recorded.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        recorded.Headers.Clone(),
+			Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+			ContentLength: int64(len(recorded.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s %s in %s", ErrInteractionNotFound, req.Method, req.URL, r.path)
+}
+
+// matches reports whether req corresponds to the recorded request.
+func (r *Recorder) matches(req *http.Request, body []byte, recorded Request) bool {
+	if r.matchMethod && req.Method != recorded.Method {
+		return false
+	}
+	if r.matchURL && req.URL.String() != recorded.URL {
+		return false
+	}
+	if r.matchBody && string(body) != recorded.Body {
+		return false
+	}
+	return true
+}
+
+// redactHeaders returns a copy of h with the values of sensitive headers hidden.
+func (r *Recorder) redactHeaders(h http.Header) http.Header {
+	out := h.Clone()
+	for _, name := range r.redact {
+		if _, ok := out[http.CanonicalHeaderKey(name)]; ok {
+			out.Set(name, redacted)
+		}
+	}
+	return out
+}
+
+// readBody reads the body of req, if any. As a RoundTripper must not modify the request it
+// is given, it returns a copy of req whose body can still be sent.
+func readBody(req *http.Request) (*http.Request, []byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return out, body, nil
+}
@@ -0,0 +1,144 @@
+package vcr
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newEcho returns a server answering every request with its method, path and body.
+func newEcho(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		io.WriteString(w, r.Method+" "+r.URL.Path+" "+string(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// send sends a request through rt and returns the response body.
+func send(t *testing.T, rt http.RoundTripper, method, url, body string) (string, error) {
+	t.Helper()
+
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	out, _ := io.ReadAll(res.Body)
+	return string(out), nil
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	server := newEcho(t)
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+
+	recorder, err := New(cassette, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Wrap(http.DefaultTransport)
+	for _, body := range []string{"one", "two"} {
+		if _, err := send(t, recorder, http.MethodPost, server.URL+"/echo", body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Secrets never reach the cassette.
+	raw, err := os.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "secret") {
+		t.Errorf("Expected the secrets to be redacted but got %s", raw)
+	}
+
+	// Replayed in order with the server gone, then used up.
+	server.Close()
+	replayer, err := New(cassette, ModeReplay, WithMatching(true, true, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"two", "one"} {
+		got, err := send(t, replayer, http.MethodPost, server.URL+"/echo", body)
+		if err != nil || got != "POST /echo "+body {
+			t.Errorf("Expected the recorded answer to %q but got %q and %v", body, got, err)
+		}
+	}
+	if _, err := send(t, replayer, http.MethodPost, server.URL+"/echo", "one"); !errors.Is(err, ErrInteractionNotFound) {
+		t.Errorf("Expected %v but got %v", ErrInteractionNotFound, err)
+	}
+}
+
+func TestRecordDoesNotModifyRequest(t *testing.T) {
+	server := newEcho(t)
+	recorder, err := New(filepath.Join(t.TempDir(), "cassette.json"), ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Wrap(http.DefaultTransport)
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("body"))
+	body := req.Body
+	res, err := recorder.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if req.Body != body {
+		t.Error("Expected the body of the request to be left alone")
+	}
+}
+
+func TestNewChecksCassette(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.json")
+	if err := os.WriteFile(existing, []byte(`{"interactions":[]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		mode Mode
+		opts []Option
+		ok   bool
+	}{
+		{"replay missing", filepath.Join(dir, "missing.json"), ModeReplay, nil, false},
+		{"replay existing", existing, ModeReplay, nil, true},
+		{"record new", filepath.Join(dir, "new.json"), ModeRecord, nil, true},
+		{"record over existing", existing, ModeRecord, nil, false},
+		{"overwrite existing", existing, ModeRecord, []Option{WithOverwrite()}, true},
+		{"passthrough", filepath.Join(dir, "missing.json"), ModePassthrough, nil, true},
+	}
+
+	for _, tt := range tests {
+		if _, err := New(tt.path, tt.mode, tt.opts...); (err == nil) != tt.ok {
+			t.Errorf("%s: Expected ok %v but got %v", tt.name, tt.ok, err)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, m := range []Mode{ModeReplay, ModeRecord, ModePassthrough} {
+		if got, err := ParseMode(strings.ToUpper(m.String())); err != nil || got != m {
+			t.Errorf("Expected %v but got %v and %v", m, got, err)
+		}
+	}
+	if _, err := ParseMode("rewind"); err == nil {
+		t.Error("Expected an unknown mode to fail")
+	}
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// WebhookSignatureHeader carries the HMAC-SHA256 of the delivery body, keyed with the
+// subscription secret, as "sha256=<hex>".
+const WebhookSignatureHeader = "X-Fact-Signature"
+
+// ErrSubscriptionNotFound is returned when a webhook subscription doesn't exist.
+var ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+
+// ErrPrivateTarget is returned when a webhook URL points at an address subscribers may not reach.
+var ErrPrivateTarget = errors.New("webhook target is a loopback, link-local or private address")
+
+// WebhookConfig holds the settings of the webhook delivery worker.
+type WebhookConfig struct {
+	// StorePath is the JSON file subscriptions and delivery state are persisted to.
+	StorePath string `json:"store_path"`
+	// PollInterval is how often the Service is asked for a new fact.
+	PollInterval time.Duration `json:"poll_interval"`
+	// MaxAttempts is the number of delivery attempts before a delivery is dead-lettered.
+	MaxAttempts int `json:"max_attempts"`
+	// MinBackoff and MaxBackoff bound the exponential backoff between delivery attempts.
+	MinBackoff time.Duration `json:"min_backoff"`
+	MaxBackoff time.Duration `json:"max_backoff"`
+	// DeliveryTimeout bounds a single delivery attempt.
+	DeliveryTimeout time.Duration `json:"delivery_timeout"`
+	// Concurrency is how many subscriptions are delivered to at once. The deliveries of a
+	// subscription are sent one at a time, in order.
+	Concurrency int `json:"concurrency"`
+	// MaxDeadLetters caps the dead-letter list, dropping the oldest entries first.
+	MaxDeadLetters int `json:"max_dead_letters"`
+	// AllowPrivateTargets lets subscriptions point at loopback, link-local and private
+	// addresses, such as the admin listener or internal hosts. Only turn it on for tests.
+	AllowPrivateTargets bool `json:"allow_private_targets"`
+}
+
+// DefaultWebhookConfig returns the default webhook settings.
+func DefaultWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		StorePath:       "webhooks.json",
+		PollInterval:    time.Minute,
+		MaxAttempts:     5,
+		MinBackoff:      time.Second,
+		MaxBackoff:      5 * time.Minute,
+		DeliveryTimeout: 10 * time.Second,
+		Concurrency:     8,
+		MaxDeadLetters:  1000,
+	}
+}
+
+// validate checks that the intervals, attempts and limits make sense. A zero poll interval
+// would turn the worker into a busy loop.
+func (c WebhookConfig) validate() error {
+	for name, d := range map[string]time.Duration{
+		"poll_interval":    c.PollInterval,
+		"delivery_timeout": c.DeliveryTimeout,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive, got %v", name, d)
+		}
+	}
+	if c.MaxAttempts <= 0 {
+		return fmt.Errorf("max_attempts must be positive, got %d", c.MaxAttempts)
+	}
+	if c.MinBackoff < 0 {
+		return fmt.Errorf("min_backoff must not be negative, got %v", c.MinBackoff)
+	}
+	if c.MaxBackoff < c.MinBackoff {
+		return fmt.Errorf("max_backoff must be at least min_backoff %v, got %v", c.MinBackoff, c.MaxBackoff)
+	}
+	if c.Concurrency <= 0 {
+		return fmt.Errorf("concurrency must be positive, got %d", c.Concurrency)
+	}
+	if c.MaxDeadLetters < 0 {
+		return fmt.Errorf("max_dead_letters must not be negative, got %d", c.MaxDeadLetters)
+	}
+	return nil
+}
+
+// Subscription is a URL registered to receive new facts.
+type Subscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delivery is a fact on its way to a subscription.
+type Delivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	Payload        json.RawMessage `json:"payload"`
+	Attempts       int             `json:"attempts"`
+	NextAttempt    time.Time       `json:"next_attempt"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// WebhookPayload is the JSON body POSTed to subscribers.
+type WebhookPayload struct {
+	DeliveryID string    `json:"delivery_id"`
+	Fact       CatFact   `json:"fact"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// webhookState is everything persisted to the store file.
+type webhookState struct {
+	Subscriptions []Subscription `json:"subscriptions"`
+	Pending       []Delivery     `json:"pending"`
+	DeadLetters   []Delivery     `json:"dead_letters"`
+	LastFact      string         `json:"last_fact"`
+}
+
+// Webhooks manages webhook subscriptions and pushes new facts to them.
+type Webhooks struct {
+	svc    Service
+	cfg    WebhookConfig
+	client *http.Client
+	now    func() time.Time
+
+	mu    sync.Mutex
+	state webhookState
+
+	lifecycle sync.Mutex
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// NewWebhooks creates a new instance of Webhooks, restoring any state persisted at cfg.StorePath.
+// Deliveries go through an HTTP client of their own, which refuses private targets unless
+// cfg.AllowPrivateTargets is set.
+func NewWebhooks(svc Service, cfg WebhookConfig) (*Webhooks, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	w := &Webhooks{
+		svc:    svc,
+		cfg:    cfg,
+		client: newWebhookClient(cfg),
+		now:    time.Now,
+	}
+
+	raw, err := os.ReadFile(cfg.StorePath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(raw, &w.state); err != nil {
+			return nil, fmt.Errorf("webhook store %s: %w", cfg.StorePath, err)
+		}
+	}
+	return w, nil
+}
+
+// Subscribe registers rawURL to receive new facts. A secret is generated when none is given.
+func (w *Webhooks) Subscribe(rawURL, secret string) (Subscription, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return Subscription{}, fmt.Errorf("invalid webhook url %q", rawURL)
+	}
+	// Names are resolved again when dialing, so the ones resolving to private addresses are
+	// refused there; catch the obvious cases up front.
+	if !w.cfg.AllowPrivateTargets && privateHost(u.Hostname()) {
+		return Subscription{}, fmt.Errorf("webhook url %q: %w", rawURL, ErrPrivateTarget)
+	}
+	if secret == "" {
+		if secret, err = generateToken(); err != nil {
+			return Subscription{}, err
+		}
+	}
+	id, err := generateToken()
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	sub := Subscription{ID: id, URL: u.String(), Secret: secret, CreatedAt: w.now().UTC()}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.state.Subscriptions = append(w.state.Subscriptions, sub)
+	if err := w.save(); err != nil {
+		// Don't deliver to a subscription the caller was told failed.
+		w.state.Subscriptions = w.state.Subscriptions[:len(w.state.Subscriptions)-1]
+		return Subscription{}, err
+	}
+	return sub, nil
+}
+
+// Unsubscribe removes a subscription and drops its pending deliveries.
+func (w *Webhooks) Unsubscribe(id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	subs := w.state.Subscriptions[:0]
+	for _, sub := range w.state.Subscriptions {
+		if sub.ID != id {
+			subs = append(subs, sub)
+		}
+	}
+	if len(subs) == len(w.state.Subscriptions) {
+		return ErrSubscriptionNotFound
+	}
+	w.state.Subscriptions = subs
+
+	pending := w.state.Pending[:0]
+	for _, d := range w.state.Pending {
+		if d.SubscriptionID != id {
+			pending = append(pending, d)
+		}
+	}
+	w.state.Pending = pending
+	return w.save()
+}
+
+// Subscriptions returns every subscription, with secrets hidden.
+func (w *Webhooks) Subscriptions() []Subscription {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	subs := make([]Subscription, 0, len(w.state.Subscriptions))
+	for _, sub := range w.state.Subscriptions {
+		sub.Secret = ""
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// SubscriptionStatus is a subscription together with its delivery state.
+type SubscriptionStatus struct {
+	Subscription
+	Pending     []Delivery `json:"pending"`
+	DeadLetters []Delivery `json:"dead_letters"`
+}
+
+// Status returns the subscription with the given id and its delivery state, with the secret hidden.
+func (w *Webhooks) Status(id string) (SubscriptionStatus, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, sub := range w.state.Subscriptions {
+		if sub.ID != id {
+			continue
+		}
+		sub.Secret = ""
+		status := SubscriptionStatus{Subscription: sub, Pending: []Delivery{}, DeadLetters: []Delivery{}}
+		for _, d := range w.state.Pending {
+			if d.SubscriptionID == id {
+				status.Pending = append(status.Pending, d)
+			}
+		}
+		for _, d := range w.state.DeadLetters {
+			if d.SubscriptionID == id {
+				status.DeadLetters = append(status.DeadLetters, d)
+			}
+		}
+		return status, nil
+	}
+	return SubscriptionStatus{}, ErrSubscriptionNotFound
+}
+
+// Start starts polling for new facts and delivering them in the background.
+// Calling Start on running Webhooks is a no-op.
+func (w *Webhooks) Start() {
+	w.lifecycle.Lock()
+	defer w.lifecycle.Unlock()
+
+	if w.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+	go w.run(ctx, w.done)
+}
+
+// Stop stops the background worker and waits for it to finish. Undelivered facts stay
+// persisted and are delivered after the next Start.
+func (w *Webhooks) Stop() {
+	w.lifecycle.Lock()
+	cancel, done := w.cancel, w.done
+	w.cancel, w.done = nil, nil
+	w.lifecycle.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// run polls for facts every PollInterval and attempts due deliveries every second.
+func (w *Webhooks) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var lastPoll time.Time
+	for {
+		if w.now().Sub(lastPoll) >= w.cfg.PollInterval {
+			lastPoll = w.now()
+			if err := w.Poll(ctx); err != nil && ctx.Err() == nil {
+				logf(LevelWarn, "webhooks poll err=%v", err)
+			}
+		}
+		w.Deliver(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll fetches a fact and, when it differs from the last one, queues it for every subscription.
+func (w *Webhooks) Poll(ctx context.Context) error {
+	fact, err := w.svc.GetCatFact(ctx)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if fact.Fact == "" || fact.Fact == w.state.LastFact {
+		return nil
+	}
+	w.state.LastFact = fact.Fact
+
+	now := w.now().UTC()
+	for _, sub := range w.state.Subscriptions {
+		id, err := generateToken()
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(WebhookPayload{DeliveryID: id, Fact: *fact, CreatedAt: now})
+		if err != nil {
+			return err
+		}
+		w.state.Pending = append(w.state.Pending, Delivery{
+			ID:             id,
+			SubscriptionID: sub.ID,
+			Payload:        payload,
+			NextAttempt:    now,
+			CreatedAt:      now,
+		})
+	}
+	return w.save()
+}
+
+// Deliver attempts every delivery that is due, retrying failures with backoff and
+// dead-lettering them once MaxAttempts is reached.
+func (w *Webhooks) Deliver(ctx context.Context) {
+	w.mu.Lock()
+	now := w.now()
+	secrets := make(map[string]Subscription, len(w.state.Subscriptions))
+	for _, sub := range w.state.Subscriptions {
+		secrets[sub.ID] = sub
+	}
+	var due []Delivery
+	for _, d := range w.state.Pending {
+		if !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+	w.mu.Unlock()
+
+	if len(due) == 0 {
+		return
+	}
+
+	// Each subscription gets its deliveries in order from a worker of its own, so a slow
+	// subscriber only delays itself.
+	var subscriptions []string
+	bySubscription := make(map[string][]Delivery)
+	for _, d := range due {
+		if _, ok := bySubscription[d.SubscriptionID]; !ok {
+			subscriptions = append(subscriptions, d.SubscriptionID)
+		}
+		bySubscription[d.SubscriptionID] = append(bySubscription[d.SubscriptionID], d)
+	}
+
+	var (
+		resultsMu sync.Mutex
+		results   = make(map[string]error, len(due))
+		wg        sync.WaitGroup
+		slots     = make(chan struct{}, w.cfg.Concurrency)
+	)
+	for _, id := range subscriptions {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(sub Subscription, deliveries []Delivery) {
+			defer func() { <-slots; wg.Done() }()
+			for _, d := range deliveries {
+				if ctx.Err() != nil {
+					return
+				}
+				err := w.send(ctx, sub, d)
+				resultsMu.Lock()
+				results[d.ID] = err
+				resultsMu.Unlock()
+			}
+		}(secrets[id], bySubscription[id])
+	}
+	wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	pending := w.state.Pending[:0]
+	for _, d := range w.state.Pending {
+		err, attempted := results[d.ID]
+		switch {
+		case !attempted:
+			pending = append(pending, d)
+		case err == nil:
+			// Delivered.
+		default:
+			d.Attempts++
+			d.LastError = err.Error()
+			if d.Attempts >= w.cfg.MaxAttempts {
+				logf(LevelWarn, "webhook delivery=%s subscription=%s dead-lettered err=%v", d.ID, d.SubscriptionID, err)
+				w.state.DeadLetters = append(w.state.DeadLetters, d)
+				continue
+			}
+			var backoff time.Duration
+			for i := 0; i < d.Attempts; i++ {
+				backoff = nextBackoff(backoff, w.cfg.MinBackoff, w.cfg.MaxBackoff)
+			}
+			d.NextAttempt = w.now().Add(backoff)
+			pending = append(pending, d)
+		}
+	}
+	w.state.Pending = pending
+	if over := len(w.state.DeadLetters) - w.cfg.MaxDeadLetters; over > 0 {
+		w.state.DeadLetters = append([]Delivery(nil), w.state.DeadLetters[over:]...)
+	}
+
+	if err := w.save(); err != nil {
+		logf(LevelError, "webhooks save err=%v", err)
+	}
+}
+
+// send POSTs a delivery to its subscription, signed with the subscription secret.
+func (w *Webhooks) send(ctx context.Context, sub Subscription, d Delivery) error {
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.DeliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Fact-Delivery", d.ID)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(sub.Secret, d.Payload))
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("subscriber answered %s", res.Status)
+	}
+	return nil
+}
+
+// save persists the state. The caller must hold w.mu.
+func (w *Webhooks) save() error {
+	raw, err := json.MarshalIndent(w.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(w.cfg.StorePath, raw)
+}
+
+// newWebhookClient returns the HTTP client deliveries are sent with. It never goes through a
+// proxy, so the addresses it dials are the ones subscribers point at, and it refuses to dial
+// private addresses, including after a redirect, unless cfg.AllowPrivateTargets is set.
+func newWebhookClient(cfg WebhookConfig) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if !cfg.AllowPrivateTargets {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if privateHost(host) {
+				return fmt.Errorf("dial %s: %w", address, ErrPrivateTarget)
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: cfg.DeliveryTimeout,
+			MaxIdleConnsPerHost:   2,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		Timeout: cfg.DeliveryTimeout,
+	}
+}
+
+// privateHost reports whether host is localhost or an IP address subscribers may not reach:
+// loopback, link-local, private, unspecified or multicast.
+func privateHost(host string) bool {
+	if strings.EqualFold(strings.TrimSuffix(host, "."), "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// SignWebhook returns the value of the signature header for body signed with secret.
+// Subscribers recompute it to check a delivery is genuine.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}